@@ -0,0 +1,211 @@
+package catalog
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "consul_k8s"
+	metricsSubsystem = "sync_catalog"
+)
+
+var (
+	// syncDuration tracks how long each full sync of the registrations
+	// with Consul takes.
+	syncDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "sync_duration_seconds",
+		Help:      "Duration of a full sync of the Kubernetes services to Consul.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// registrations and deregistrations count the successful catalog
+	// writes made by the syncer.
+	registrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "registrations_total",
+		Help:      "Number of service instances registered in Consul.",
+	})
+	deregistrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "deregistrations_total",
+		Help:      "Number of service instances deregistered from Consul.",
+	})
+
+	// consulAPIErrors counts the failed Consul API calls by operation.
+	consulAPIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "consul_api_errors_total",
+		Help:      "Number of failed Consul API calls.",
+	}, []string{"operation"})
+
+	// serviceInstances is the number of instances of each Consul service
+	// that the syncer should have registered.
+	serviceInstances = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "service_instances",
+		Help:      "Number of instances of each service to register in Consul.",
+	}, []string{"service"})
+
+	// lastSync tracks when all instances of each service were last known
+	// to be in sync with Consul.
+	lastSync = newLastSyncCollector()
+
+	// resourceVersionLag is how far the resourceVersion of the last
+	// processed Kubernetes object changed by a watch event is behind the
+	// latest resourceVersion seen by the informer of that resource.
+	resourceVersionLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "k8s_resource_version_lag",
+		Help:      "Difference between the latest observed and the last processed Kubernetes resourceVersion.",
+	}, []string{"resource"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		syncDuration,
+		registrations,
+		deregistrations,
+		consulAPIErrors,
+		serviceInstances,
+		lastSync,
+		resourceVersionLag,
+	)
+}
+
+// lastSyncCollector exports the seconds since each service was last in
+// sync. It's computed when scraped so that it keeps growing while a
+// service fails to sync.
+type lastSyncCollector struct {
+	desc *prometheus.Desc
+
+	lock  sync.Mutex
+	times map[string]time.Time
+}
+
+func newLastSyncCollector() *lastSyncCollector {
+	return &lastSyncCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, metricsSubsystem, "seconds_since_last_sync"),
+			"Seconds since all instances of each service were last written to or confirmed in Consul.",
+			[]string{"service"}, nil,
+		),
+		times: make(map[string]time.Time),
+	}
+}
+
+// Set records that the given service was in sync at the given time.
+func (c *lastSyncCollector) Set(service string, t time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.times[service] = t
+}
+
+// Retain forgets the services that aren't in the given set.
+func (c *lastSyncCollector) Retain(services map[string]struct{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for service := range c.times {
+		if _, ok := services[service]; !ok {
+			delete(c.times, service)
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *lastSyncCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *lastSyncCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	for service, t := range c.times {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue,
+			now.Sub(t).Seconds(), service)
+	}
+}
+
+// recordResourceVersionLag sets the resourceVersion lag of the given
+// resource. Kubernetes doesn't guarantee that resourceVersions are
+// numbers, so nothing is recorded if either isn't.
+func recordResourceVersionLag(resource, latest, processed string) {
+	l, err := strconv.ParseUint(latest, 10, 64)
+	if err != nil {
+		return
+	}
+	p, err := strconv.ParseUint(processed, 10, 64)
+	if err != nil {
+		return
+	}
+	lag := 0.0
+	if l > p {
+		lag = float64(l - p)
+	}
+	resourceVersionLag.WithLabelValues(resource).Set(lag)
+}
+
+// lagRecorder records the resourceVersion lag of a resource for the
+// objects changed by watch events. Objects from a list, and unchanged
+// objects that are processed again on resync or retry, have the
+// resourceVersion of their last change rather than of an event, so the
+// lag they'd record isn't there.
+type lagRecorder struct {
+	resource string
+
+	lock      sync.Mutex
+	listed    uint64            // resourceVersion of the last list
+	processed map[string]string // last processed resourceVersion by key
+}
+
+func newLagRecorder(resource string) *lagRecorder {
+	return &lagRecorder{
+		resource:  resource,
+		processed: make(map[string]string),
+	}
+}
+
+// List records the resourceVersion of a list of the resource.
+func (r *lagRecorder) List(version string) {
+	v, err := strconv.ParseUint(version, 10, 64)
+	if err != nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.listed = v
+}
+
+// Record sets the lag if the object with the given key and resourceVersion
+// was changed since the last list and hasn't been processed before.
+func (r *lagRecorder) Record(key, latest, processed string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.processed[key] == processed {
+		return
+	}
+	r.processed[key] = processed
+	if v, err := strconv.ParseUint(processed, 10, 64); err != nil || v <= r.listed {
+		return
+	}
+	recordResourceVersionLag(r.resource, latest, processed)
+}
+
+// Forget forgets the object with the given key once it's deleted.
+func (r *lagRecorder) Forget(key string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.processed, key)
+}
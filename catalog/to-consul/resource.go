@@ -2,6 +2,7 @@ package catalog
 
 import (
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,6 +27,21 @@ const (
 	// ConsulK8SNS is the key used in the meta to record the namespace
 	// of the service/node registration.
 	ConsulK8SNS = "external-k8s-ns"
+
+	// ConsulK8SService is the key used in the meta to record the
+	// <namespace>/<name> of the Kubernetes service that the registration
+	// was made for.
+	ConsulK8SService = "external-k8s-service"
+
+	// DefaultConsulNodeName is the Consul node that services are registered
+	// on if ConsulNodeName isn't set.
+	DefaultConsulNodeName = "k8s-sync"
+
+	// ConsulK8SReadinessCheckID and ConsulK8SReadinessCheckName are the ID
+	// suffix and name of the check registered with instances of services
+	// that publish not ready addresses.
+	ConsulK8SReadinessCheckID   = "kubernetes-readiness"
+	ConsulK8SReadinessCheckName = "Kubernetes Readiness Check"
 )
 
 type NodePortSyncType string
@@ -57,6 +73,12 @@ type ServiceResource struct {
 	//ConsulServicePrefix prepends K8s services in Consul with a prefix
 	ConsulServicePrefix string
 
+	// ConsulNodeName is the Consul node that services are registered on.
+	// Defaults to DefaultConsulNodeName. Running several instances with
+	// different node names lets each instance have a token that can only
+	// write its own node.
+	ConsulNodeName string
+
 	// ExplictEnable should be set to true to require explicit enabling
 	// using annotations. If this is false, then services are implicitly
 	// enabled (aka default enabled).
@@ -77,6 +99,13 @@ type ServiceResource struct {
 	// as 'foo-default'.
 	AddK8SNamespaceSuffix bool
 
+	// PodMetaAnnotationPrefix, if set, is the prefix of the pod annotations
+	// that are copied into the meta of the service instance registered for
+	// that pod's endpoint. The remainder of the annotation key is the meta
+	// key. This only applies to ClusterIP services since those are the only
+	// services that register an instance per pod.
+	PodMetaAnnotationPrefix string
+
 	// serviceLock must be held for any read/write to these maps.
 	serviceLock sync.RWMutex
 
@@ -88,18 +117,33 @@ type ServiceResource struct {
 	// of each service.
 	endpointsMap map[string]*apiv1.Endpoints
 
+	// podMetaMap holds the meta from the annotations of the pods that have
+	// any with the PodMetaAnnotationPrefix, keyed by <kube namespace>/<pod name>.
+	// It's kept up to date by watching pods.
+	podMetaMap map[string]map[string]string
+
 	// consulMap holds the services in Consul that we've registered from kube.
 	// It's populated via Consul's API and lets us diff what is actually in
 	// Consul vs. what we expect to be there.
 	consulMap map[string][]*consulapi.CatalogRegistration
+
+	// informer is the service informer. It's used to get the latest
+	// resourceVersion for the lag metric, which lag records.
+	informer cache.SharedIndexInformer
+	lag      *lagRecorder
 }
 
 // Informer implements the controller.Resource interface.
 func (t *ServiceResource) Informer() cache.SharedIndexInformer {
-	return cache.NewSharedIndexInformer(
+	t.lag = newLagRecorder("service")
+	t.informer = cache.NewSharedIndexInformer(
 		&cache.ListWatch{
 			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-				return t.Client.CoreV1().Services(t.namespace()).List(options)
+				list, err := t.Client.CoreV1().Services(t.namespace()).List(options)
+				if err == nil {
+					t.lag.List(list.ResourceVersion)
+				}
+				return list, err
 			},
 
 			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
@@ -110,6 +154,7 @@ func (t *ServiceResource) Informer() cache.SharedIndexInformer {
 		0,
 		cache.Indexers{},
 	)
+	return t.informer
 }
 
 // Upsert implements the controller.Resource interface.
@@ -124,6 +169,10 @@ func (t *ServiceResource) Upsert(key string, raw interface{}) error {
 	t.serviceLock.Lock()
 	defer t.serviceLock.Unlock()
 
+	if t.informer != nil {
+		defer t.lag.Record(key, t.informer.LastSyncResourceVersion(), service.ResourceVersion)
+	}
+
 	if t.serviceMap == nil {
 		t.serviceMap = make(map[string]*apiv1.Service)
 	}
@@ -170,6 +219,9 @@ func (t *ServiceResource) Upsert(key string, raw interface{}) error {
 func (t *ServiceResource) Delete(key string) error {
 	t.serviceLock.Lock()
 	defer t.serviceLock.Unlock()
+	if t.lag != nil {
+		t.lag.Forget(key)
+	}
 	t.doDelete(key)
 	t.Log.Info("delete", "key", key)
 	return nil
@@ -191,11 +243,25 @@ func (t *ServiceResource) doDelete(key string) {
 
 // Run implements the controller.Backgrounder interface.
 func (t *ServiceResource) Run(ch <-chan struct{}) {
+	var wg sync.WaitGroup
+	if t.PodMetaAnnotationPrefix != "" {
+		t.Log.Info("starting runner for pods")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			(&controller.Controller{
+				Log:      t.Log.Named("controller/pods"),
+				Resource: &servicePodsResource{Service: t},
+			}).Run(ch)
+		}()
+	}
+
 	t.Log.Info("starting runner for endpoints")
 	(&controller.Controller{
 		Log:      t.Log.Named("controller/endpoints"),
 		Resource: &serviceEndpointsResource{Service: t},
 	}).Run(ch)
+	wg.Wait()
 }
 
 // shouldSync returns true if resyncing should be enabled for the given service.
@@ -279,7 +345,7 @@ func (t *ServiceResource) generateRegistrations(key string) {
 	// shallow copied for each instance.
 	baseNode := consulapi.CatalogRegistration{
 		SkipNodeUpdate: true,
-		Node:           "k8s-sync",
+		Node:           t.consulNodeName(),
 		Address:        "127.0.0.1",
 		NodeMeta: map[string]string{
 			ConsulSourceKey: ConsulSourceValue,
@@ -290,8 +356,9 @@ func (t *ServiceResource) generateRegistrations(key string) {
 		Service: t.addPrefixAndK8SNamespace(svc.Name, svc.Namespace),
 		Tags:    []string{t.ConsulK8STag},
 		Meta: map[string]string{
-			ConsulSourceKey: ConsulSourceValue,
-			ConsulK8SNS:     t.namespace(),
+			ConsulSourceKey:  ConsulSourceValue,
+			ConsulK8SNS:      t.namespace(),
+			ConsulK8SService: key,
 		},
 	}
 
@@ -533,7 +600,15 @@ func (t *ServiceResource) generateRegistrations(key string) {
 					break
 				}
 			}
-			for _, subsetAddr := range subset.Addresses {
+			// Services that publish not ready addresses (usually so that
+			// peers can find each other while the cluster is forming) get
+			// their not ready addresses registered too. Those instances are
+			// distinguished by a readiness check in the warning state.
+			addresses := subset.Addresses
+			if svc.Spec.PublishNotReadyAddresses {
+				addresses = append(append([]apiv1.EndpointAddress{}, subset.Addresses...), subset.NotReadyAddresses...)
+			}
+			for i, subsetAddr := range addresses {
 				addr := subsetAddr.IP
 				if addr == "" {
 					addr = subsetAddr.Hostname
@@ -556,6 +631,23 @@ func (t *ServiceResource) generateRegistrations(key string) {
 				r.Service.ID = serviceID(r.Service.Service, addr)
 				r.Service.Address = addr
 				r.Service.Port = epPort
+				if svc.Spec.PublishNotReadyAddresses {
+					r.Check = readinessCheck(r.Node, r.Service, i < len(subset.Addresses))
+				}
+				if meta := t.podMeta(subsetAddr); len(meta) > 0 {
+					r.Service.Meta = make(map[string]string, len(baseService.Meta)+len(meta))
+					for k, v := range meta {
+						r.Service.Meta[k] = v
+					}
+					// Pod meta takes precedence over the service's meta
+					// except for the keys we rely on for reaping and
+					// auditing.
+					for k, v := range baseService.Meta {
+						if _, ok := r.Service.Meta[k]; !ok || k == ConsulSourceKey || k == ConsulK8SNS || k == ConsulK8SService {
+							r.Service.Meta[k] = v
+						}
+					}
+				}
 
 				t.consulMap[key] = append(t.consulMap[key], &r)
 			}
@@ -563,6 +655,54 @@ func (t *ServiceResource) generateRegistrations(key string) {
 	}
 }
 
+// readinessCheck returns the check registered alongside the instances of
+// services that publish not ready addresses. The check is passing for ready
+// addresses and warning otherwise. Ready instances need the check too so that
+// the warning is cleared once the pod becomes ready.
+func readinessCheck(node string, svc *consulapi.AgentService, ready bool) *consulapi.AgentCheck {
+	check := &consulapi.AgentCheck{
+		Node:        node,
+		CheckID:     fmt.Sprintf("%s/%s", svc.ID, ConsulK8SReadinessCheckID),
+		Name:        ConsulK8SReadinessCheckName,
+		ServiceID:   svc.ID,
+		ServiceName: svc.Service,
+		Status:      consulapi.HealthPassing,
+		Output:      "Kubernetes endpoint is ready",
+	}
+	if !ready {
+		check.Status = consulapi.HealthWarning
+		check.Output = "Kubernetes endpoint is not ready but is published because the service sets publishNotReadyAddresses"
+	}
+	return check
+}
+
+// podMeta returns the meta of the pod backing the given endpoint address.
+// This returns nil if the address isn't backed by a pod or the pod has no
+// annotations with the PodMetaAnnotationPrefix.
+//
+// Precondition: lock must be held
+func (t *ServiceResource) podMeta(addr apiv1.EndpointAddress) map[string]string {
+	if addr.TargetRef == nil || addr.TargetRef.Kind != "Pod" {
+		return nil
+	}
+	return t.podMetaMap[addr.TargetRef.Namespace+"/"+addr.TargetRef.Name]
+}
+
+// podAnnotationMeta returns the meta from the annotations of the pod that
+// have the PodMetaAnnotationPrefix, or nil if there are none.
+func (t *ServiceResource) podAnnotationMeta(pod *apiv1.Pod) map[string]string {
+	var meta map[string]string
+	for k, v := range pod.Annotations {
+		if strings.HasPrefix(k, t.PodMetaAnnotationPrefix) && strings.TrimPrefix(k, t.PodMetaAnnotationPrefix) != "" {
+			if meta == nil {
+				meta = make(map[string]string)
+			}
+			meta[strings.TrimPrefix(k, t.PodMetaAnnotationPrefix)] = v
+		}
+	}
+	return meta
+}
+
 // sync calls the Syncer.Sync function from the generated registrations.
 //
 // Precondition: lock must be held
@@ -594,15 +734,23 @@ func (t *ServiceResource) namespace() string {
 // to keep track of changing endpoints for registered services.
 type serviceEndpointsResource struct {
 	Service *ServiceResource
+
+	informer cache.SharedIndexInformer
+	lag      *lagRecorder
 }
 
 func (t *serviceEndpointsResource) Informer() cache.SharedIndexInformer {
-	return cache.NewSharedIndexInformer(
+	t.lag = newLagRecorder("endpoints")
+	t.informer = cache.NewSharedIndexInformer(
 		&cache.ListWatch{
 			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-				return t.Service.Client.CoreV1().
+				list, err := t.Service.Client.CoreV1().
 					Endpoints(t.Service.namespace()).
 					List(options)
+				if err == nil {
+					t.lag.List(list.ResourceVersion)
+				}
+				return list, err
 			},
 
 			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
@@ -615,6 +763,7 @@ func (t *serviceEndpointsResource) Informer() cache.SharedIndexInformer {
 		0,
 		cache.Indexers{},
 	)
+	return t.informer
 }
 
 func (t *serviceEndpointsResource) Upsert(key string, raw interface{}) error {
@@ -628,6 +777,10 @@ func (t *serviceEndpointsResource) Upsert(key string, raw interface{}) error {
 	svc.serviceLock.Lock()
 	defer svc.serviceLock.Unlock()
 
+	if t.informer != nil {
+		defer t.lag.Record(key, t.informer.LastSyncResourceVersion(), endpoints.ResourceVersion)
+	}
+
 	// Check if we care about endpoints for this service
 	if !svc.shouldTrackEndpoints(key) {
 		return nil
@@ -649,6 +802,9 @@ func (t *serviceEndpointsResource) Upsert(key string, raw interface{}) error {
 func (t *serviceEndpointsResource) Delete(key string) error {
 	t.Service.serviceLock.Lock()
 	defer t.Service.serviceLock.Unlock()
+	if t.lag != nil {
+		t.lag.Forget(key)
+	}
 
 	// This is a bit of an optimization. We only want to force a resync
 	// if we were tracking this endpoint to begin with and that endpoint
@@ -665,6 +821,101 @@ func (t *serviceEndpointsResource) Delete(key string) error {
 	return nil
 }
 
+// servicePodsResource implements controller.Resource and starts a
+// background watcher on pods that is used by the ServiceResource to keep
+// the meta of the instances of each pod up to date with its annotations.
+type servicePodsResource struct {
+	Service *ServiceResource
+}
+
+func (t *servicePodsResource) Informer() cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return t.Service.Client.CoreV1().
+					Pods(t.Service.namespace()).
+					List(options)
+			},
+
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return t.Service.Client.CoreV1().
+					Pods(t.Service.namespace()).
+					Watch(options)
+			},
+		},
+		&apiv1.Pod{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+func (t *servicePodsResource) Upsert(key string, raw interface{}) error {
+	svc := t.Service
+	pod, ok := raw.(*apiv1.Pod)
+	if !ok {
+		svc.Log.Warn("upsert got invalid type", "raw", raw)
+		return nil
+	}
+	meta := svc.podAnnotationMeta(pod)
+
+	svc.serviceLock.Lock()
+	defer svc.serviceLock.Unlock()
+
+	// Most pod updates are status changes, which don't change the meta.
+	if reflect.DeepEqual(svc.podMetaMap[key], meta) {
+		return nil
+	}
+	if meta == nil {
+		delete(svc.podMetaMap, key)
+	} else {
+		if svc.podMetaMap == nil {
+			svc.podMetaMap = make(map[string]map[string]string)
+		}
+		svc.podMetaMap[key] = meta
+	}
+
+	// Update the registrations of the services the pod backs and trigger
+	// a sync.
+	synced := false
+	for svcKey, endpoints := range svc.endpointsMap {
+		if !endpointsHavePod(endpoints, pod.Namespace, pod.Name) {
+			continue
+		}
+		svc.generateRegistrations(svcKey)
+		synced = true
+	}
+	if synced {
+		svc.sync()
+	}
+	svc.Log.Debug("upsert pod meta", "key", key)
+	return nil
+}
+
+// Delete forgets the meta of the pod. Its instances are deregistered once
+// it's removed from the endpoints.
+func (t *servicePodsResource) Delete(key string) error {
+	t.Service.serviceLock.Lock()
+	defer t.Service.serviceLock.Unlock()
+	delete(t.Service.podMetaMap, key)
+	return nil
+}
+
+// endpointsHavePod returns true if any address of the endpoints is backed
+// by the given pod.
+func endpointsHavePod(endpoints *apiv1.Endpoints, namespace, name string) bool {
+	for _, subset := range endpoints.Subsets {
+		for _, addrs := range [][]apiv1.EndpointAddress{subset.Addresses, subset.NotReadyAddresses} {
+			for _, addr := range addrs {
+				ref := addr.TargetRef
+				if ref != nil && ref.Kind == "Pod" && ref.Namespace == namespace && ref.Name == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func (t *ServiceResource) addPrefixAndK8SNamespace(name, namespace string) string {
 	if t.ConsulServicePrefix != "" {
 		name = fmt.Sprintf("%s%s", t.ConsulServicePrefix, name)
@@ -676,3 +927,11 @@ func (t *ServiceResource) addPrefixAndK8SNamespace(name, namespace string) strin
 
 	return name
 }
+
+// consulNodeName returns the Consul node that services are registered on.
+func (t *ServiceResource) consulNodeName() string {
+	if t.ConsulNodeName == "" {
+		return DefaultConsulNodeName
+	}
+	return t.ConsulNodeName
+}
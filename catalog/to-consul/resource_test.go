@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/hashicorp/consul-k8s/helper/controller"
+	consulapi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/go-hclog"
 	"github.com/stretchr/testify/require"
@@ -171,6 +172,35 @@ func TestServiceResource_defaultDisableEnable(t *testing.T) {
 	require.Len(actual, 1)
 }
 
+// Test that the services are registered on the configured Consul node.
+func TestServiceResource_consulNodeName(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	client := fake.NewSimpleClientset()
+	syncer := &TestSyncer{}
+
+	// Start the controller
+	closer := controller.TestControllerRun(&ServiceResource{
+		Log:            hclog.Default(),
+		Client:         client,
+		Syncer:         syncer,
+		ConsulNodeName: "k8s-sync-team-a",
+	})
+	defer closer()
+
+	// Insert an LB service
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(lbService("foo", "1.2.3.4"))
+	require.NoError(err)
+	time.Sleep(200 * time.Millisecond)
+
+	// Verify what we got
+	syncer.Lock()
+	defer syncer.Unlock()
+	actual := syncer.Registrations
+	require.Len(actual, 1)
+	require.Equal("k8s-sync-team-a", actual[0].Node)
+}
+
 // Test that system resources are not synced by default.
 func TestServiceResource_system(t *testing.T) {
 	t.Parallel()
@@ -1340,6 +1370,168 @@ func TestServiceResource_clusterIPTargetPortNamed(t *testing.T) {
 	require.NotEqual(actual[0].Service.ID, actual[1].Service.ID)
 }
 
+// Test that annotations on the pods backing a ClusterIP service are added to
+// the meta of their instances when PodMetaAnnotationPrefix is set.
+func TestServiceResource_clusterIPPodMeta(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	client := fake.NewSimpleClientset()
+	syncer := &TestSyncer{}
+
+	// Start the controller
+	closer := controller.TestControllerRun(&ServiceResource{
+		Log:                     hclog.Default(),
+		Client:                  client,
+		Syncer:                  syncer,
+		ClusterIPSync:           true,
+		PodMetaAnnotationPrefix: "consul.hashicorp.com/service-meta-",
+	})
+	defer closer()
+
+	// Insert the pod
+	_, err := client.CoreV1().Pods(metav1.NamespaceDefault).Create(&apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "foo-abc",
+			Annotations: map[string]string{
+				"consul.hashicorp.com/service-meta-sha":            "a1b2c3",
+				"consul.hashicorp.com/service-meta-" + ConsulK8SNS: "other",
+				"unrelated": "value",
+			},
+		},
+	})
+	require.NoError(err)
+
+	// Insert the service
+	svc := clusterIPService("foo")
+	svc.Annotations[annotationServiceMetaPrefix+"team"] = "mesh"
+	_, err = client.CoreV1().Services(metav1.NamespaceDefault).Create(svc)
+	require.NoError(err)
+
+	// Insert the endpoints, only the first of which is backed by a pod
+	_, err = client.CoreV1().Endpoints(metav1.NamespaceDefault).Create(&apiv1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "foo",
+		},
+
+		Subsets: []apiv1.EndpointSubset{
+			{
+				Addresses: []apiv1.EndpointAddress{
+					{
+						IP: "1.1.1.1",
+						TargetRef: &apiv1.ObjectReference{
+							Kind:      "Pod",
+							Name:      "foo-abc",
+							Namespace: metav1.NamespaceDefault,
+						},
+					},
+					{IP: "2.2.2.2"},
+				},
+				Ports: []apiv1.EndpointPort{
+					{Name: "http", Port: 8080},
+				},
+			},
+		},
+	})
+	require.NoError(err)
+
+	// Wait a bit
+	time.Sleep(300 * time.Millisecond)
+
+	// Verify what we got
+	syncer.Lock()
+	actual := syncer.Registrations
+	require.Len(actual, 2)
+	require.Equal("1.1.1.1", actual[0].Service.Address)
+	require.Equal("a1b2c3", actual[0].Service.Meta["sha"])
+	require.Equal("mesh", actual[0].Service.Meta["team"])
+	require.Equal(metav1.NamespaceAll, actual[0].Service.Meta[ConsulK8SNS])
+	require.NotContains(actual[0].Service.Meta, "unrelated")
+	require.Equal("2.2.2.2", actual[1].Service.Address)
+	require.NotContains(actual[1].Service.Meta, "sha")
+	require.Equal("mesh", actual[1].Service.Meta["team"])
+	syncer.Unlock()
+
+	// Changing the annotations of the pod updates the meta without the
+	// endpoints changing.
+	pod, err := client.CoreV1().Pods(metav1.NamespaceDefault).Get("foo-abc", metav1.GetOptions{})
+	require.NoError(err)
+	pod.Annotations["consul.hashicorp.com/service-meta-sha"] = "d4e5f6"
+	_, err = client.CoreV1().Pods(metav1.NamespaceDefault).Update(pod)
+	require.NoError(err)
+	time.Sleep(300 * time.Millisecond)
+
+	syncer.Lock()
+	defer syncer.Unlock()
+	actual = syncer.Registrations
+	require.Len(actual, 2)
+	require.Equal("1.1.1.1", actual[0].Service.Address)
+	require.Equal("d4e5f6", actual[0].Service.Meta["sha"])
+}
+
+// Test that not ready addresses are registered with a warning check when the
+// service publishes not ready addresses.
+func TestServiceResource_clusterIPPublishNotReadyAddresses(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	client := fake.NewSimpleClientset()
+	syncer := &TestSyncer{}
+
+	// Start the controller
+	closer := controller.TestControllerRun(&ServiceResource{
+		Log:           hclog.Default(),
+		Client:        client,
+		Syncer:        syncer,
+		ClusterIPSync: true,
+	})
+	defer closer()
+
+	// Insert the service
+	svc := clusterIPService("foo")
+	svc.Spec.PublishNotReadyAddresses = true
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(svc)
+	require.NoError(err)
+
+	// Insert the endpoints
+	_, err = client.CoreV1().Endpoints(metav1.NamespaceDefault).Create(&apiv1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "foo",
+		},
+
+		Subsets: []apiv1.EndpointSubset{
+			{
+				Addresses: []apiv1.EndpointAddress{
+					{IP: "1.1.1.1"},
+				},
+				NotReadyAddresses: []apiv1.EndpointAddress{
+					{IP: "2.2.2.2"},
+				},
+				Ports: []apiv1.EndpointPort{
+					{Name: "http", Port: 8080},
+				},
+			},
+		},
+	})
+	require.NoError(err)
+
+	// Wait a bit
+	time.Sleep(300 * time.Millisecond)
+
+	// Verify what we got
+	syncer.Lock()
+	defer syncer.Unlock()
+	actual := syncer.Registrations
+	require.Len(actual, 2)
+	require.Equal("1.1.1.1", actual[0].Service.Address)
+	require.NotNil(actual[0].Check)
+	require.Equal(consulapi.HealthPassing, actual[0].Check.Status)
+	require.Equal(actual[0].Service.ID, actual[0].Check.ServiceID)
+	require.Equal("2.2.2.2", actual[1].Service.Address)
+	require.NotNil(actual[1].Check)
+	require.Equal(consulapi.HealthWarning, actual[1].Check.Status)
+	require.Equal(actual[1].Service.ID, actual[1].Check.ServiceID)
+	require.NotEqual(actual[0].Check.CheckID, actual[1].Check.CheckID)
+}
+
 // lbService returns a Kubernetes service of type LoadBalancer.
 func lbService(name, lbIP string) *apiv1.Service {
 	return &apiv1.Service{
@@ -2,12 +2,16 @@ package catalog
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul-k8s/helper/audit"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -59,15 +63,39 @@ type ConsulSyncer struct {
 	SyncPeriod        time.Duration
 	ServicePollPeriod time.Duration
 
+	// ConsulNodeName limits the reaping of the syncer to the services on
+	// this node, so that instances registering on different nodes don't
+	// reap each other's services. If this is blank, services on any node
+	// are reaped.
+	ConsulNodeName string
+
 	// ConsulK8STag is the tag value for services registered.
 	ConsulK8STag string
 
+	// Audit, if set, records the registrations and deregistrations.
+	Audit *audit.Recorder
+
 	lock     sync.Mutex
 	once     sync.Once
 	services map[string]struct{} // set of valid service names
 	nodes    map[string]*consulSyncState
 	deregs   map[string]*api.CatalogDeregistration
 	watchers map[string]context.CancelFunc
+
+	// written is the hash of the registration last written to Consul for
+	// each service instance, keyed by writtenKey. Registrations that haven't changed are not written
+	// again. Entries are removed on errors, on deregistration and when
+	// the service watcher notices the instance was changed in Consul so
+	// that external changes are still overwritten.
+	written map[string]uint64
+
+	// fullSynced is set to 1 once the first full sync has completed.
+	fullSynced uint32
+
+	// loopAlive is the Unix time in nanoseconds at which the sync loop
+	// was started or last completed a full sync. It's 0 until Run is
+	// called.
+	loopAlive int64
 }
 
 // consulSyncState keeps track of the state of syncing nodes/services.
@@ -76,6 +104,13 @@ type consulSyncState struct {
 	Services map[string]*api.CatalogRegistration
 }
 
+// writtenKey returns the key of the service instance with the given ID on
+// the given node in ConsulSyncer.written. Service IDs are only unique per
+// node.
+func writtenKey(node, id string) string {
+	return node + "/" + id
+}
+
 // Sync implements Syncer
 func (s *ConsulSyncer) Sync(rs []*api.CatalogRegistration) {
 	// Grab the lock so we can replace the sync state
@@ -84,9 +119,11 @@ func (s *ConsulSyncer) Sync(rs []*api.CatalogRegistration) {
 
 	s.services = make(map[string]struct{})
 	s.nodes = make(map[string]*consulSyncState)
+	serviceInstances.Reset()
 	for _, r := range rs {
 		// Mark this as a valid service
 		s.services[r.Service.Service] = struct{}{}
+		serviceInstances.WithLabelValues(r.Service.Service).Inc()
 
 		// Initialize the state if we don't have it
 		state, ok := s.nodes[r.Node]
@@ -110,6 +147,7 @@ func (s *ConsulSyncer) Run(ctx context.Context) {
 
 	// Start the background watchers
 	go s.watchReapableServices(ctx)
+	atomic.StoreInt64(&s.loopAlive, time.Now().UnixNano())
 
 	reconcileTimer := time.NewTimer(s.SyncPeriod)
 	defer reconcileTimer.Stop()
@@ -153,6 +191,7 @@ func (s *ConsulSyncer) watchReapableServices(ctx context.Context) {
 			return err
 		}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
 		if err != nil {
+			consulAPIErrors.WithLabelValues("catalog_services").Inc()
 			s.Log.Warn("error querying services, will retry", "err", err)
 			continue
 		}
@@ -187,6 +226,7 @@ func (s *ConsulSyncer) watchReapableServices(ctx context.Context) {
 					s.Log.Info("invalid service found, scheduling for delete",
 						"service-name", name)
 					if err := s.scheduleReapServiceLocked(name); err != nil {
+						consulAPIErrors.WithLabelValues("catalog_service").Inc()
 						s.Log.Info("error querying service for delete",
 							"service-name", name,
 							"err", err)
@@ -220,14 +260,22 @@ func (s *ConsulSyncer) watchService(ctx context.Context, name string) {
 
 		// Wait for service changes
 		var services []*api.CatalogService
+		var checks api.HealthChecks
 		err := backoff.Retry(func() error {
 			var err error
 			services, _, err = s.Client.Catalog().Service(name, s.ConsulK8STag, &api.QueryOptions{
 				AllowStale: true,
 			})
+			if err != nil {
+				return err
+			}
+			checks, _, err = s.Client.Health().Checks(name, &api.QueryOptions{
+				AllowStale: true,
+			})
 			return err
 		}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
 		if err != nil {
+			consulAPIErrors.WithLabelValues("catalog_service").Inc()
 			s.Log.Warn("error querying service, will retry",
 				"service-name", name,
 				"err", err)
@@ -237,13 +285,10 @@ func (s *ConsulSyncer) watchService(ctx context.Context, name string) {
 		// Lock so we can modify the set of actions to take
 		s.lock.Lock()
 
+		s.invalidateDriftedLocked(name, services, checks)
+
 		for _, svc := range services {
-			// If we have a namespace set and the key exactly matches this
-			// namespace, then we skip it.
-			if s.Namespace != "" &&
-				len(svc.ServiceMeta) > 0 &&
-				svc.ServiceMeta[ConsulK8SNS] != "" &&
-				svc.ServiceMeta[ConsulK8SNS] != s.Namespace {
+			if !s.reapable(svc) {
 				continue
 			}
 
@@ -266,6 +311,81 @@ func (s *ConsulSyncer) watchService(ctx context.Context, name string) {
 	}
 }
 
+// invalidateDriftedLocked forgets the written hash of each of our instances
+// of the named service that is missing from, or differs from, the given
+// instances and checks in Consul so that the next sync registers it again.
+//
+// Precondition: lock must be held
+func (s *ConsulSyncer) invalidateDriftedLocked(name string, services []*api.CatalogService, checks api.HealthChecks) {
+	current := make(map[string]*api.CatalogService, len(services))
+	for _, svc := range services {
+		current[writtenKey(svc.Node, svc.ServiceID)] = svc
+	}
+	currentChecks := make(map[string]*api.HealthCheck, len(checks))
+	for _, c := range checks {
+		currentChecks[writtenKey(c.Node, c.CheckID)] = c
+	}
+
+	for node, state := range s.nodes {
+		for id, r := range state.Services {
+			if r.Service.Service != name {
+				continue
+			}
+
+			key := writtenKey(node, id)
+			svc, ok := current[key]
+			if !ok || serviceDrifted(r.Service, svc) {
+				delete(s.written, key)
+				continue
+			}
+			if r.Check != nil && checkDrifted(r.Check, currentChecks[writtenKey(node, r.Check.CheckID)]) {
+				delete(s.written, key)
+			}
+		}
+	}
+}
+
+// serviceDrifted returns true if the instance in Consul no longer matches
+// the service we registered. The order of the tags doesn't matter.
+func serviceDrifted(expected *api.AgentService, svc *api.CatalogService) bool {
+	if expected.Address != svc.ServiceAddress || expected.Port != svc.ServicePort {
+		return true
+	}
+	if len(expected.Tags) != len(svc.ServiceTags) {
+		return true
+	}
+	tags := make(map[string]int, len(svc.ServiceTags))
+	for _, tag := range svc.ServiceTags {
+		tags[tag]++
+	}
+	for _, tag := range expected.Tags {
+		if tags[tag] == 0 {
+			return true
+		}
+		tags[tag]--
+	}
+	if len(expected.Meta) != len(svc.ServiceMeta) {
+		return true
+	}
+	for k, v := range expected.Meta {
+		if svc.ServiceMeta[k] != v {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDrifted returns true if the check in Consul, which is nil if it's
+// missing, no longer matches the check we registered.
+func checkDrifted(expected *api.AgentCheck, c *api.HealthCheck) bool {
+	return c == nil ||
+		expected.Name != c.Name ||
+		expected.Status != c.Status ||
+		expected.Notes != c.Notes ||
+		expected.Output != c.Output ||
+		expected.ServiceID != c.ServiceID
+}
+
 // scheduleReapService finds all the instances of the service with the given
 // name that have the k8s tag and schedules them for removal.
 //
@@ -279,12 +399,7 @@ func (s *ConsulSyncer) scheduleReapServiceLocked(name string) error {
 	}
 
 	for _, svc := range services {
-		// If we have a namespace set and the key exactly matches this
-		// namespace, then we skip it.
-		if s.Namespace != "" &&
-			len(svc.ServiceMeta) > 0 &&
-			svc.ServiceMeta[ConsulK8SNS] != "" &&
-			svc.ServiceMeta[ConsulK8SNS] != s.Namespace {
+		if !s.reapable(svc) {
 			continue
 		}
 
@@ -297,12 +412,32 @@ func (s *ConsulSyncer) scheduleReapServiceLocked(name string) error {
 	return nil
 }
 
+// reapable returns true if the service instance is one that this
+// syncer may reap.
+func (s *ConsulSyncer) reapable(svc *api.CatalogService) bool {
+	// If we have a namespace set and the key exactly matches this
+	// namespace, then we skip it.
+	if s.Namespace != "" &&
+		len(svc.ServiceMeta) > 0 &&
+		svc.ServiceMeta[ConsulK8SNS] != "" &&
+		svc.ServiceMeta[ConsulK8SNS] != s.Namespace {
+		return false
+	}
+
+	// Instances on the nodes of other syncers are theirs to reap.
+	if s.ConsulNodeName != "" && svc.Node != s.ConsulNodeName {
+		return false
+	}
+	return true
+}
+
 // syncFull is called periodically to perform all the write-based API
 // calls to sync the data with Consul. This may also start background
 // watchers for specific services.
 func (s *ConsulSyncer) syncFull(ctx context.Context) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	defer prometheus.NewTimer(syncDuration).ObserveDuration()
 
 	s.Log.Info("registering services")
 
@@ -321,18 +456,14 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 		}
 	}
 
-	// Do all deregistrations first
+	// Do all deregistrations first. These are batched per node to cut
+	// down on the number of writes, e.g. when a Kubernetes node drains.
+	deregs := make(map[string][]*api.CatalogDeregistration)
 	for _, r := range s.deregs {
-		s.Log.Info("deregistering service",
-			"node-name", r.Node,
-			"service-id", r.ServiceID)
-		_, err := s.Client.Catalog().Deregister(r, nil)
-		if err != nil {
-			s.Log.Warn("error deregistering service",
-				"node-name", r.Node,
-				"service-id", r.ServiceID,
-				"err", err)
-		}
+		deregs[r.Node] = append(deregs[r.Node], r)
+	}
+	for node, rs := range deregs {
+		s.deregisterNode(node, rs)
 	}
 
 	// Always clear deregistrations, they'll repopulate if we had errors
@@ -340,22 +471,65 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 
 	// Register all the services. This will overwrite any changes that
 	// may have been made to the registered services.
-	for _, state := range s.nodes {
+	for node, state := range s.nodes {
+		rs := make([]*api.CatalogRegistration, 0, len(state.Services))
 		for _, r := range state.Services {
-			_, err := s.Client.Catalog().Register(r, nil)
-			if err != nil {
-				s.Log.Warn("error registering service",
-					"node-name", r.Node,
-					"service-name", r.Service.Service,
-					"err", err)
-				continue
+			rs = append(rs, r)
+		}
+		s.registerNode(node, rs)
+	}
+
+	now := time.Now()
+	s.recordLastSyncLocked(now)
+	atomic.StoreUint32(&s.fullSynced, 1)
+	atomic.StoreInt64(&s.loopAlive, now.UnixNano())
+}
+
+// HasSynced returns true once the registrations have been fully synced
+// with Consul at least once.
+func (s *ConsulSyncer) HasSynced() bool {
+	return atomic.LoadUint32(&s.fullSynced) == 1
+}
+
+// CheckSyncLoop returns an error if the sync loop has been running for
+// longer than maxAge without completing a full sync, e.g. because it's
+// stuck waiting on the lock. It passes until Run is called.
+func (s *ConsulSyncer) CheckSyncLoop(maxAge time.Duration) error {
+	alive := atomic.LoadInt64(&s.loopAlive)
+	if alive == 0 {
+		return nil
+	}
+	if age := time.Since(time.Unix(0, alive)); age > maxAge {
+		return fmt.Errorf("no full sync completed in %s", age.Round(time.Second))
+	}
+	return nil
+}
+
+// recordLastSyncLocked records the services whose instances are all in
+// sync as of now. An instance is in sync if it was written, or found
+// unchanged, since the errors of failed writes remove its hash.
+//
+// Precondition: lock must be held
+func (s *ConsulSyncer) recordLastSyncLocked(now time.Time) {
+	synced := make(map[string]bool, len(s.services))
+	for node, state := range s.nodes {
+		for id, r := range state.Services {
+			name := r.Service.Service
+			if _, ok := synced[name]; !ok {
+				synced[name] = true
 			}
+			if _, ok := s.written[writtenKey(node, id)]; !ok {
+				synced[name] = false
+			}
+		}
+	}
 
-			s.Log.Debug("registered service instance",
-				"node-name", r.Node,
-				"service-name", r.Service.Service)
+	for name, ok := range synced {
+		if ok {
+			lastSync.Set(name, now)
 		}
 	}
+	lastSync.Retain(s.services)
 }
 
 func (s *ConsulSyncer) init() {
@@ -371,6 +545,9 @@ func (s *ConsulSyncer) init() {
 	if s.watchers == nil {
 		s.watchers = make(map[string]context.CancelFunc)
 	}
+	if s.written == nil {
+		s.written = make(map[string]uint64)
+	}
 	if s.SyncPeriod == 0 {
 		s.SyncPeriod = ConsulSyncPeriod
 	}
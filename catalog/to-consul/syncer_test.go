@@ -2,6 +2,7 @@ package catalog
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/consul/testrpc"
 	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -47,6 +49,316 @@ func TestConsulSyncer_register(t *testing.T) {
 	require.Equal("foo", service.Node)
 	require.Equal("bar", service.ServiceName)
 	require.Equal("127.0.0.1", service.Address)
+
+	// The syncer is synced once the full sync that registered the
+	// service has completed.
+	retry.Run(t, func(r *retry.R) {
+		if !s.HasSynced() {
+			r.Fatal("syncer not synced")
+		}
+	})
+}
+
+// Test that the sync loop check fails once no full sync has completed for
+// longer than the max age.
+func TestConsulSyncer_CheckSyncLoop(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	s := &ConsulSyncer{}
+	require.NoError(s.CheckSyncLoop(time.Minute))
+
+	s.loopAlive = time.Now().Add(-30 * time.Second).UnixNano()
+	require.NoError(s.CheckSyncLoop(time.Minute))
+
+	s.loopAlive = time.Now().Add(-2 * time.Minute).UnixNano()
+	err := s.CheckSyncLoop(time.Minute)
+	require.Error(err)
+	require.Contains(err.Error(), "no full sync completed in 2m0s")
+}
+
+// Test that more registrations than fit in a single transaction are all
+// registered.
+func TestConsulSyncer_registerBatched(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	a := agent.NewTestAgent(t, t.Name(), ``)
+	defer a.Shutdown()
+	testrpc.WaitForTestAgent(t, a.RPC, "dc1")
+	client := a.Client()
+
+	s, closer := testConsulSyncer(t, client)
+	defer closer()
+
+	// Sync
+	var rs []*api.CatalogRegistration
+	for i := 0; i < maxTxnOps*2; i++ {
+		rs = append(rs, testRegistration("foo", fmt.Sprintf("bar-%d", i)))
+	}
+	s.Sync(rs)
+
+	// Read the services back out
+	retry.Run(t, func(r *retry.R) {
+		node, _, err := client.Catalog().Node("foo", nil)
+		if err != nil {
+			r.Fatalf("err: %s", err)
+		}
+		if node == nil || len(node.Services) != len(rs) {
+			r.Fatal("services not found")
+		}
+	})
+
+	// Deregister all of them
+	s.Sync(nil)
+	retry.Run(t, func(r *retry.R) {
+		services, _, err := client.Catalog().Service("bar-0", "", nil)
+		require.NoError(err)
+		if len(services) > 0 {
+			r.Fatal("service should be deregistered")
+		}
+	})
+}
+
+// Test that the instance gauge reflects the registrations being synced.
+func TestConsulSyncer_serviceInstancesMetric(t *testing.T) {
+	require := require.New(t)
+
+	s := &ConsulSyncer{Log: hclog.Default()}
+	s.Sync([]*api.CatalogRegistration{
+		testRegistration("foo", "metrics-a"),
+		testRegistration("bar", "metrics-a"),
+		testRegistration("foo", "metrics-b"),
+	})
+	require.Equal(2.0, testutil.ToFloat64(serviceInstances.WithLabelValues("metrics-a")))
+	require.Equal(1.0, testutil.ToFloat64(serviceInstances.WithLabelValues("metrics-b")))
+
+	// Services that are no longer synced are dropped
+	s.Sync([]*api.CatalogRegistration{
+		testRegistration("foo", "metrics-b"),
+	})
+	require.Equal(0.0, testutil.ToFloat64(serviceInstances.WithLabelValues("metrics-a")))
+	require.Equal(1.0, testutil.ToFloat64(serviceInstances.WithLabelValues("metrics-b")))
+}
+
+// Test that only services whose instances are all written are recorded as
+// in sync.
+func TestConsulSyncer_recordLastSync(t *testing.T) {
+	require := require.New(t)
+
+	s := &ConsulSyncer{Log: hclog.Default()}
+	s.init()
+	s.Sync([]*api.CatalogRegistration{
+		testRegistration("foo", "last-sync-a"),
+		testRegistration("bar", "last-sync-a"),
+		testRegistration("foo", "last-sync-b"),
+	})
+	s.written[writtenKey("foo", serviceID("foo", "last-sync-a"))] = 1
+	s.written[writtenKey("foo", serviceID("foo", "last-sync-b"))] = 1
+
+	now := time.Now()
+	s.lock.Lock()
+	s.recordLastSyncLocked(now)
+	s.lock.Unlock()
+
+	lastSync.lock.Lock()
+	_, okA := lastSync.times["last-sync-a"]
+	syncedB := lastSync.times["last-sync-b"]
+	lastSync.lock.Unlock()
+	require.False(okA, "last-sync-a has an instance that isn't written")
+	require.Equal(now, syncedB)
+
+	// Services that are no longer synced are dropped
+	s.Sync(nil)
+	s.lock.Lock()
+	s.recordLastSyncLocked(now)
+	s.lock.Unlock()
+	lastSync.lock.Lock()
+	_, okB := lastSync.times["last-sync-b"]
+	lastSync.lock.Unlock()
+	require.False(okB)
+}
+
+// Test that only the instances that differ from Consul are invalidated,
+// that the order of tags doesn't matter and that checks are compared.
+func TestConsulSyncer_invalidateDrifted(t *testing.T) {
+	require := require.New(t)
+
+	s := &ConsulSyncer{Log: hclog.Default()}
+	s.init()
+	unchanged := testRegistration("foo", "drift")
+	unchanged.Service.Tags = []string{"a", "b"}
+	checked := testRegistration("bar", "drift")
+	checked.Check = readinessCheck("bar", checked.Service, true)
+	s.Sync([]*api.CatalogRegistration{unchanged, checked})
+	unchangedKey := writtenKey("foo", unchanged.Service.ID)
+	checkedKey := writtenKey("bar", checked.Service.ID)
+
+	services := []*api.CatalogService{
+		{
+			Node:        "foo",
+			ServiceID:   unchanged.Service.ID,
+			ServiceTags: []string{"b", "a"},
+			ServiceMeta: unchanged.Service.Meta,
+		},
+		{
+			Node:        "bar",
+			ServiceID:   checked.Service.ID,
+			ServiceTags: checked.Service.Tags,
+			ServiceMeta: checked.Service.Meta,
+		},
+	}
+	check := &api.HealthCheck{
+		Node:      "bar",
+		CheckID:   checked.Check.CheckID,
+		Name:      checked.Check.Name,
+		Status:    checked.Check.Status,
+		Output:    checked.Check.Output,
+		ServiceID: checked.Check.ServiceID,
+	}
+
+	// Reordered tags and an unchanged check are no drift.
+	s.written[unchangedKey] = 1
+	s.written[checkedKey] = 1
+	s.lock.Lock()
+	s.invalidateDriftedLocked("drift", services, api.HealthChecks{check})
+	s.lock.Unlock()
+	require.Contains(s.written, unchangedKey)
+	require.Contains(s.written, checkedKey)
+
+	// A changed check invalidates only its instance.
+	check.Status = api.HealthCritical
+	s.lock.Lock()
+	s.invalidateDriftedLocked("drift", services, api.HealthChecks{check})
+	s.lock.Unlock()
+	require.Contains(s.written, unchangedKey)
+	require.NotContains(s.written, checkedKey)
+
+	// So does a missing check.
+	s.written[checkedKey] = 1
+	s.lock.Lock()
+	s.invalidateDriftedLocked("drift", services, nil)
+	s.lock.Unlock()
+	require.Contains(s.written, unchangedKey)
+	require.NotContains(s.written, checkedKey)
+}
+
+func TestRecordResourceVersionLag(t *testing.T) {
+	require := require.New(t)
+
+	recordResourceVersionLag("lag-test", "120", "100")
+	require.Equal(20.0, testutil.ToFloat64(resourceVersionLag.WithLabelValues("lag-test")))
+
+	recordResourceVersionLag("lag-test", "120", "120")
+	require.Equal(0.0, testutil.ToFloat64(resourceVersionLag.WithLabelValues("lag-test")))
+
+	// Non-numeric versions are ignored
+	recordResourceVersionLag("lag-test", "abc", "100")
+	require.Equal(0.0, testutil.ToFloat64(resourceVersionLag.WithLabelValues("lag-test")))
+}
+
+// Test that lag is only recorded for objects changed since the last list,
+// and not again when an unchanged object is processed on resync.
+func TestLagRecorder(t *testing.T) {
+	require := require.New(t)
+
+	r := newLagRecorder("lag-recorder-test")
+	gauge := resourceVersionLag.WithLabelValues("lag-recorder-test")
+	r.List("100")
+
+	// Listed objects have the resourceVersion of their last change.
+	r.Record("default/a", "100", "90")
+	require.Equal(0.0, testutil.ToFloat64(gauge))
+
+	// Watch events are recorded.
+	r.Record("default/b", "120", "110")
+	require.Equal(10.0, testutil.ToFloat64(gauge))
+
+	// Unchanged objects on resync are not.
+	r.Record("default/b", "200", "110")
+	r.Record("default/a", "200", "90")
+	require.Equal(10.0, testutil.ToFloat64(gauge))
+
+	// Forgotten objects are recorded again.
+	r.Forget("default/b")
+	r.Record("default/b", "115", "110")
+	require.Equal(5.0, testutil.ToFloat64(gauge))
+}
+
+// Test that registrations that haven't changed since they were last written
+// aren't written again.
+func TestConsulSyncer_registerSkipsUnchanged(t *testing.T) {
+	// Not parallel since it counts the writes with the global metric.
+	require := require.New(t)
+
+	a := agent.NewTestAgent(t, t.Name(), ``)
+	defer a.Shutdown()
+	testrpc.WaitForTestAgent(t, a.RPC, "dc1")
+
+	s := &ConsulSyncer{Client: a.Client(), Log: hclog.Default()}
+	s.init()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	before := testutil.ToFloat64(registrations)
+	s.registerNode("foo", []*api.CatalogRegistration{testRegistration("foo", "unchanged")})
+	require.Equal(before+1, testutil.ToFloat64(registrations))
+
+	// Nothing changed, so nothing is written.
+	s.registerNode("foo", []*api.CatalogRegistration{testRegistration("foo", "unchanged")})
+	require.Equal(before+1, testutil.ToFloat64(registrations))
+
+	// A changed registration is written.
+	changed := testRegistration("foo", "unchanged")
+	changed.Service.Port = 8080
+	s.registerNode("foo", []*api.CatalogRegistration{changed})
+	require.Equal(before+2, testutil.ToFloat64(registrations))
+}
+
+// Test that unchanged registrations which were modified in Consul are
+// still overwritten.
+func TestConsulSyncer_registerOverwritesExternalChange(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	a := agent.NewTestAgent(t, t.Name(), ``)
+	defer a.Shutdown()
+	testrpc.WaitForTestAgent(t, a.RPC, "dc1")
+	client := a.Client()
+
+	s, closer := testConsulSyncer(t, client)
+	defer closer()
+
+	// Sync
+	s.Sync([]*api.CatalogRegistration{
+		testRegistration("foo", "bar"),
+	})
+	retry.Run(t, func(r *retry.R) {
+		services, _, err := client.Catalog().Service("bar", "", nil)
+		if err != nil {
+			r.Fatalf("err: %s", err)
+		}
+		if len(services) == 0 {
+			r.Fatal("service not found")
+		}
+	})
+
+	// Change the service outside of the syncer
+	changed := testRegistration("foo", "bar")
+	changed.Service.Port = 8080
+	_, err := client.Catalog().Register(changed, nil)
+	require.NoError(err)
+
+	// The change should be overwritten
+	retry.Run(t, func(r *retry.R) {
+		services, _, err := client.Catalog().Service("bar", "", nil)
+		if err != nil {
+			r.Fatalf("err: %s", err)
+		}
+		if len(services) != 1 || services[0].ServicePort != 0 {
+			r.Fatal("service not overwritten")
+		}
+	})
 }
 
 // Test that the syncer reaps invalid services
@@ -189,6 +501,60 @@ func TestConsulSyncer_reapServiceOtherNamespace(t *testing.T) {
 	require.Len(services, 1)
 }
 
+// Test that the syncer does not reap services on the node of another syncer.
+func TestConsulSyncer_reapServiceOtherNode(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	a := agent.NewTestAgent(t, t.Name(), ``)
+	defer a.Shutdown()
+	testrpc.WaitForTestAgent(t, a.RPC, "dc1")
+	client := a.Client()
+
+	s := &ConsulSyncer{
+		Client:            client,
+		Log:               hclog.Default(),
+		SyncPeriod:        200 * time.Millisecond,
+		ServicePollPeriod: 50 * time.Millisecond,
+		Namespace:         "default",
+		ConsulNodeName:    "foo",
+		ConsulK8STag:      TestConsulK8STag,
+	}
+	ctx, cancelF := context.WithCancel(context.Background())
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		s.Run(ctx)
+	}()
+	defer func() {
+		cancelF()
+		<-doneCh
+	}()
+
+	// Sync
+	s.Sync([]*api.CatalogRegistration{
+		testRegistration("foo", "bar"),
+	})
+
+	// Create services on another node directly in Consul, one of them
+	// with the same name as ours
+	_, err := client.Catalog().Register(testRegistration("other", "baz"), nil)
+	require.NoError(err)
+	_, err = client.Catalog().Register(testRegistration("other", "bar"), nil)
+	require.NoError(err)
+
+	// Sleep for a bit
+	time.Sleep(500 * time.Millisecond)
+
+	// The services on the other node should exist
+	services, _, err := client.Catalog().Service("baz", "", nil)
+	require.NoError(err)
+	require.Len(services, 1)
+	services, _, err = client.Catalog().Service("bar", "", nil)
+	require.NoError(err)
+	require.Len(services, 2)
+}
+
 // Test that the syncer reaps services with no NS set.
 func TestConsulSyncer_reapServiceSameNamespace(t *testing.T) {
 	t.Parallel()
@@ -0,0 +1,230 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-multierror"
+	"github.com/mitchellh/hashstructure"
+)
+
+// maxTxnOps is the maximum number of operations Consul accepts in a
+// single transaction.
+const maxTxnOps = 64
+
+// registerNode registers the given service instances, which must all be on
+// the given node, in as few transactions as possible. Each transaction
+// also sets the node so that it exists for the service operations.
+//
+// Precondition: lock must be held
+func (s *ConsulSyncer) registerNode(node string, rs []*api.CatalogRegistration) {
+	// Skip the registrations that haven't changed since they were last
+	// written so that resyncs don't cause needless Raft writes.
+	hashes := make(map[string]uint64, len(rs))
+	changed := rs[:0:0]
+	for _, r := range rs {
+		hash, err := hashstructure.Hash(r, nil)
+		if err != nil {
+			// This should never happen, but if it does the registration
+			// is just always written.
+			s.Log.Warn("error hashing registration",
+				"service-id", r.Service.ID,
+				"err", err)
+		} else if written, ok := s.written[writtenKey(node, r.Service.ID)]; ok && written == hash {
+			continue
+		} else {
+			hashes[r.Service.ID] = hash
+		}
+
+		changed = append(changed, r)
+	}
+	rs = changed
+	if len(rs) == 0 {
+		return
+	}
+
+	// Sort so that the batches are stable between syncs.
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Service.ID < rs[j].Service.ID })
+
+	nodeOp := &api.TxnOp{
+		Node: &api.NodeTxnOp{
+			Verb: api.NodeSet,
+			Node: api.Node{
+				ID:              rs[0].ID,
+				Node:            node,
+				Address:         rs[0].Address,
+				Datacenter:      rs[0].Datacenter,
+				TaggedAddresses: rs[0].TaggedAddresses,
+				Meta:            rs[0].NodeMeta,
+			},
+		},
+	}
+
+	var batch []*api.CatalogRegistration
+	ops := api.TxnOps{nodeOp}
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		err := s.txn(ops)
+		for _, r := range batch {
+			s.recordAudit("catalog-register", r.Service.ID, r.Service.Meta[ConsulK8SService], err)
+		}
+		if err != nil {
+			consulAPIErrors.WithLabelValues("register").Inc()
+			s.Log.Warn("error registering services",
+				"node-name", node,
+				"count", len(batch),
+				"err", err)
+			for _, r := range batch {
+				delete(s.written, writtenKey(node, r.Service.ID))
+			}
+		} else {
+			registrations.Add(float64(len(batch)))
+			for _, r := range batch {
+				if hash, ok := hashes[r.Service.ID]; ok {
+					s.written[writtenKey(node, r.Service.ID)] = hash
+				}
+				s.Log.Debug("registered service instance",
+					"node-name", node,
+					"service-name", r.Service.Service)
+			}
+		}
+
+		batch = nil
+		ops = api.TxnOps{nodeOp}
+	}
+
+	for _, r := range rs {
+		regOps := registrationOps(node, r)
+		if len(ops)+len(regOps) > maxTxnOps {
+			flush()
+		}
+
+		ops = append(ops, regOps...)
+		batch = append(batch, r)
+	}
+	flush()
+}
+
+// deregisterNode deregisters the given service instances, which must all be
+// on the given node, in as few transactions as possible.
+//
+// Precondition: lock must be held
+func (s *ConsulSyncer) deregisterNode(node string, deregs []*api.CatalogDeregistration) {
+	for len(deregs) > 0 {
+		n := len(deregs)
+		if n > maxTxnOps {
+			n = maxTxnOps
+		}
+		batch := deregs[:n]
+		deregs = deregs[n:]
+
+		ops := make(api.TxnOps, 0, len(batch))
+		for _, r := range batch {
+			delete(s.written, writtenKey(node, r.ServiceID))
+			s.Log.Info("deregistering service",
+				"node-name", node,
+				"service-id", r.ServiceID)
+			ops = append(ops, &api.TxnOp{
+				Service: &api.ServiceTxnOp{
+					Verb:    api.ServiceDelete,
+					Node:    node,
+					Service: api.AgentService{ID: r.ServiceID},
+				},
+			})
+		}
+
+		err := s.txn(ops)
+		for _, r := range batch {
+			s.recordAudit("catalog-deregister", r.ServiceID, "", err)
+		}
+		if err != nil {
+			consulAPIErrors.WithLabelValues("deregister").Inc()
+			s.Log.Warn("error deregistering services",
+				"node-name", node,
+				"count", len(batch),
+				"err", err)
+			continue
+		}
+		deregistrations.Add(float64(len(batch)))
+	}
+}
+
+// recordAudit records a catalog change in the audit log, if there is one.
+func (s *ConsulSyncer) recordAudit(operation, serviceID, source string, err error) {
+	if source != "" {
+		source = "Service " + source
+	}
+	if auditErr := s.Audit.Record(operation, serviceID, source, err); auditErr != nil {
+		s.Log.Warn("failed to record audit event",
+			"operation", operation,
+			"service-id", serviceID,
+			"err", auditErr)
+	}
+}
+
+// txn applies the given operations in a single transaction. If the
+// transaction is rolled back, the errors of the failed operations are
+// returned.
+func (s *ConsulSyncer) txn(ops api.TxnOps) error {
+	ok, resp, _, err := s.Client.Txn().Txn(ops, nil)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	var result error
+	for _, e := range resp.Errors {
+		result = multierror.Append(result, fmt.Errorf("operation %d: %s", e.OpIndex, e.What))
+	}
+	if result == nil {
+		result = fmt.Errorf("transaction rolled back")
+	}
+	return result
+}
+
+// registrationOps returns the transaction operations that register the
+// service, and check if there is one, of the given registration.
+func registrationOps(node string, r *api.CatalogRegistration) api.TxnOps {
+	// Unlike the catalog register endpoint, transactions don't default
+	// the weights of the service.
+	svc := *r.Service
+	if svc.Weights.Passing == 0 {
+		svc.Weights = api.AgentWeights{Passing: 1, Warning: 1}
+	}
+
+	ops := api.TxnOps{
+		&api.TxnOp{
+			Service: &api.ServiceTxnOp{
+				Verb:    api.ServiceSet,
+				Node:    node,
+				Service: svc,
+			},
+		},
+	}
+
+	if c := r.Check; c != nil {
+		ops = append(ops, &api.TxnOp{
+			Check: &api.CheckTxnOp{
+				Verb: api.CheckSet,
+				Check: api.HealthCheck{
+					Node:        node,
+					CheckID:     c.CheckID,
+					Name:        c.Name,
+					Status:      c.Status,
+					Notes:       c.Notes,
+					Output:      c.Output,
+					ServiceID:   c.ServiceID,
+					ServiceName: c.ServiceName,
+				},
+			},
+		})
+	}
+
+	return ops
+}
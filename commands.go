@@ -4,10 +4,18 @@ import (
 	"os"
 
 	cmdACLInit "github.com/hashicorp/consul-k8s/subcommand/acl-init"
+	cmdBootstrapConfigEntries "github.com/hashicorp/consul-k8s/subcommand/bootstrap-config-entries"
+	cmdConfigureConnectCA "github.com/hashicorp/consul-k8s/subcommand/configure-connect-ca"
+	cmdConfigureDNSForwarding "github.com/hashicorp/consul-k8s/subcommand/configure-dns-forwarding"
 	cmdDeleteCompletedJob "github.com/hashicorp/consul-k8s/subcommand/delete-completed-job"
+	cmdDrainWatcher "github.com/hashicorp/consul-k8s/subcommand/drain-watcher"
 	cmdInjectConnect "github.com/hashicorp/consul-k8s/subcommand/inject-connect"
 	cmdLifecycleSidecar "github.com/hashicorp/consul-k8s/subcommand/lifecycle-sidecar"
+	cmdPreUpgradeCheck "github.com/hashicorp/consul-k8s/subcommand/pre-upgrade-check"
+	cmdRotateACLTokens "github.com/hashicorp/consul-k8s/subcommand/rotate-acl-tokens"
+	cmdRotateGossipKey "github.com/hashicorp/consul-k8s/subcommand/rotate-gossip-key"
 	cmdServerACLInit "github.com/hashicorp/consul-k8s/subcommand/server-acl-init"
+	cmdServerReady "github.com/hashicorp/consul-k8s/subcommand/server-ready"
 	cmdSyncCatalog "github.com/hashicorp/consul-k8s/subcommand/sync-catalog"
 	cmdVersion "github.com/hashicorp/consul-k8s/subcommand/version"
 	"github.com/hashicorp/consul-k8s/version"
@@ -25,6 +33,22 @@ func init() {
 			return &cmdACLInit.Command{UI: ui}, nil
 		},
 
+		"bootstrap-config-entries": func() (cli.Command, error) {
+			return &cmdBootstrapConfigEntries.Command{UI: ui}, nil
+		},
+
+		"configure-connect-ca": func() (cli.Command, error) {
+			return &cmdConfigureConnectCA.Command{UI: ui}, nil
+		},
+
+		"configure-dns-forwarding": func() (cli.Command, error) {
+			return &cmdConfigureDNSForwarding.Command{UI: ui}, nil
+		},
+
+		"drain-watcher": func() (cli.Command, error) {
+			return &cmdDrainWatcher.Command{UI: ui}, nil
+		},
+
 		"inject-connect": func() (cli.Command, error) {
 			return &cmdInjectConnect.Command{UI: ui}, nil
 		},
@@ -33,10 +57,26 @@ func init() {
 			return &cmdLifecycleSidecar.Command{UI: ui}, nil
 		},
 
+		"pre-upgrade-check": func() (cli.Command, error) {
+			return &cmdPreUpgradeCheck.Command{UI: ui}, nil
+		},
+
+		"rotate-acl-tokens": func() (cli.Command, error) {
+			return &cmdRotateACLTokens.Command{UI: ui}, nil
+		},
+
+		"rotate-gossip-key": func() (cli.Command, error) {
+			return &cmdRotateGossipKey.Command{UI: ui}, nil
+		},
+
 		"server-acl-init": func() (cli.Command, error) {
 			return &cmdServerACLInit.Command{UI: ui}, nil
 		},
 
+		"server-ready": func() (cli.Command, error) {
+			return &cmdServerReady.Command{UI: ui}, nil
+		},
+
 		"sync-catalog": func() (cli.Command, error) {
 			return &cmdSyncCatalog.Command{UI: ui}, nil
 		},
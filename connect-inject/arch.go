@@ -0,0 +1,100 @@
+package connectinject
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattbaird/jsonpatch"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// labelArch is the well-known label with the architecture of a node.
+const labelArch = "kubernetes.io/arch"
+
+// archAffinity returns the patches that restrict the pod to nodes with one
+// of the ImageArchitectures, so that it isn't scheduled on a node that
+// can't run the injected containers. The requirement is added to each of
+// the pod's node selector terms, since those are alternatives. An error is
+// returned if the pod's node selector, or one of its node selector terms,
+// requires another architecture.
+func (h *Handler) archAffinity(pod *corev1.Pod) ([]jsonpatch.JsonPatchOperation, error) {
+	if len(h.ImageArchitectures) == 0 {
+		return nil, nil
+	}
+	if arch, ok := pod.Spec.NodeSelector[labelArch]; ok {
+		if containsString(h.ImageArchitectures, arch) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("pod requires nodes with architecture %q, but the injected images are only available for %s",
+			arch, strings.Join(h.ImageArchitectures, ", "))
+	}
+
+	affinity := &corev1.Affinity{}
+	if pod.Spec.Affinity != nil {
+		affinity = pod.Spec.Affinity.DeepCopy()
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	if affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	selector := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	requirement := corev1.NodeSelectorRequirement{
+		Key:      labelArch,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   h.ImageArchitectures,
+	}
+	if len(selector.NodeSelectorTerms) == 0 {
+		selector.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+	for i := range selector.NodeSelectorTerms {
+		term := &selector.NodeSelectorTerms[i]
+		if !h.termAllowsArch(term) {
+			return nil, fmt.Errorf("node affinity term %d of the pod doesn't allow any of the architectures "+
+				"the injected images are available for: %s", i, strings.Join(h.ImageArchitectures, ", "))
+		}
+		term.MatchExpressions = append(term.MatchExpressions, requirement)
+	}
+
+	return []jsonpatch.JsonPatchOperation{{
+		Operation: "add",
+		Path:      "/spec/affinity",
+		Value:     affinity,
+	}}, nil
+}
+
+// termAllowsArch returns whether the node selector term allows nodes with
+// at least one of the ImageArchitectures.
+func (h *Handler) termAllowsArch(term *corev1.NodeSelectorTerm) bool {
+	for _, arch := range h.ImageArchitectures {
+		allowed := true
+		for _, req := range term.MatchExpressions {
+			if req.Key != labelArch {
+				continue
+			}
+			switch req.Operator {
+			case corev1.NodeSelectorOpIn:
+				allowed = allowed && containsString(req.Values, arch)
+			case corev1.NodeSelectorOpNotIn:
+				allowed = allowed && !containsString(req.Values, arch)
+			case corev1.NodeSelectorOpDoesNotExist:
+				allowed = false
+			}
+		}
+		if allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString returns whether values contains s.
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
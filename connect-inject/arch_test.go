@@ -0,0 +1,169 @@
+package connectinject
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestHandlerArchAffinity(t *testing.T) {
+	archRequirement := corev1.NodeSelectorRequirement{
+		Key:      labelArch,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{"amd64", "arm64"},
+	}
+	armRequirement := corev1.NodeSelectorRequirement{
+		Key:      labelArch,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{"arm64"},
+	}
+	zoneRequirement := corev1.NodeSelectorRequirement{
+		Key:      "topology.kubernetes.io/zone",
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{"a"},
+	}
+
+	cases := []struct {
+		Name     string
+		Archs    []string
+		Pod      corev1.PodSpec
+		Affinity *corev1.Affinity // expected, nil if there's no patch
+		Err      string
+	}{
+		{
+			"no architectures",
+			nil,
+			corev1.PodSpec{},
+			nil,
+			"",
+		},
+
+		{
+			"no affinity",
+			[]string{"amd64", "arm64"},
+			corev1.PodSpec{},
+			&corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{archRequirement}},
+					},
+				},
+			}},
+			"",
+		},
+
+		{
+			"existing terms",
+			[]string{"amd64", "arm64"},
+			corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{zoneRequirement}},
+						{},
+					},
+				},
+			}}},
+			&corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{zoneRequirement, archRequirement}},
+						{MatchExpressions: []corev1.NodeSelectorRequirement{archRequirement}},
+					},
+				},
+			}},
+			"",
+		},
+
+		{
+			"node selector with supported architecture",
+			[]string{"amd64", "arm64"},
+			corev1.PodSpec{NodeSelector: map[string]string{labelArch: "arm64"}},
+			nil,
+			"",
+		},
+
+		{
+			"node selector with unsupported architecture",
+			[]string{"amd64"},
+			corev1.PodSpec{NodeSelector: map[string]string{labelArch: "arm64"}},
+			nil,
+			`pod requires nodes with architecture "arm64", but the injected images are only available for amd64`,
+		},
+
+		{
+			"term with supported architecture",
+			[]string{"amd64", "arm64"},
+			corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{armRequirement}},
+					},
+				},
+			}}},
+			&corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{armRequirement, archRequirement}},
+					},
+				},
+			}},
+			"",
+		},
+
+		{
+			"term with unsupported architecture",
+			[]string{"amd64"},
+			corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{zoneRequirement}},
+						{MatchExpressions: []corev1.NodeSelectorRequirement{armRequirement}},
+					},
+				},
+			}}},
+			nil,
+			`node affinity term 1 of the pod doesn't allow any of the architectures the injected images are available for: amd64`,
+		},
+
+		{
+			"term excluding architectures",
+			[]string{"amd64"},
+			corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{{
+							Key:      labelArch,
+							Operator: corev1.NodeSelectorOpNotIn,
+							Values:   []string{"amd64"},
+						}}},
+					},
+				},
+			}}},
+			nil,
+			`node affinity term 0 of the pod doesn't allow any of the architectures the injected images are available for: amd64`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			require := require.New(t)
+
+			h := Handler{ImageArchitectures: tt.Archs}
+			pod := &corev1.Pod{Spec: tt.Pod}
+			patches, err := h.archAffinity(pod)
+			if tt.Err != "" {
+				require.EqualError(err, tt.Err)
+				return
+			}
+			require.NoError(err)
+			if tt.Affinity == nil {
+				require.Empty(patches)
+				return
+			}
+			require.Len(patches, 1)
+			require.Equal("/spec/affinity", patches[0].Path)
+			require.Equal(tt.Affinity, patches[0].Value)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package connectinject
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"text/template"
@@ -28,6 +29,15 @@ type initContainerCommandData struct {
 	// The PEM-encoded CA certificate to use when
 	// communicating with Consul clients
 	ConsulCACert string
+
+	// LoginMaxAttempts is how many times logging in with the auth method
+	// is attempted before the init container fails.
+	LoginMaxAttempts int
+
+	// EnvoyDogstatsdURL and EnvoyStatsTags configure the DogStatsD sink
+	// of Envoy. EnvoyStatsTags is a JSON array.
+	EnvoyDogstatsdURL string
+	EnvoyStatsTags    string
 }
 
 type initContainerCommandUpstreamData struct {
@@ -37,6 +47,26 @@ type initContainerCommandUpstreamData struct {
 	Query      string
 }
 
+// ValidateDogstatsdURL returns an error if url isn't a DogStatsD URL
+// that Envoy supports, or can't be written into the HCL config as is.
+func ValidateDogstatsdURL(url string) error {
+	if !strings.HasPrefix(url, "udp://") && !strings.HasPrefix(url, "unix://") {
+		return errors.New("must start with udp:// or unix://")
+	}
+	if strings.ContainsAny(url, "\"\\\n") {
+		return errors.New("must not contain quotes, backslashes or newlines")
+	}
+	return nil
+}
+
+// ValidateStatsTag returns an error if tag isn't in the form name=value.
+func ValidateStatsTag(tag string) error {
+	if !strings.Contains(tag, "=") {
+		return fmt.Errorf("%q must be in the form name=value", tag)
+	}
+	return nil
+}
+
 // containerInit returns the init container spec for registering the Consul
 // service, setting up the Envoy bootstrap, etc.
 func (h *Handler) containerInit(pod *corev1.Pod) (corev1.Container, error) {
@@ -57,6 +87,10 @@ func (h *Handler) containerInit(pod *corev1.Pod) (corev1.Container, error) {
 		AuthMethod:           h.AuthMethod,
 		WriteServiceDefaults: writeServiceDefaults,
 		ConsulCACert:         h.ConsulCACert,
+		LoginMaxAttempts:     h.LoginMaxAttempts,
+	}
+	if data.LoginMaxAttempts <= 0 {
+		data.LoginMaxAttempts = DefaultLoginMaxAttempts
 	}
 	if data.ServiceName == "" {
 		// Assertion, since we call defaultAnnotations above and do
@@ -100,6 +134,36 @@ func (h *Handler) containerInit(pod *corev1.Pod) (corev1.Container, error) {
 		}
 	}
 
+	// The annotations are validated like the injector's flags since
+	// they're written into the HCL config.
+	data.EnvoyDogstatsdURL = h.EnvoyDogstatsdURL
+	if raw, ok := pod.Annotations[annotationEnvoyDogstatsdURL]; ok {
+		data.EnvoyDogstatsdURL = raw
+		if raw == "none" {
+			data.EnvoyDogstatsdURL = ""
+		} else if err := ValidateDogstatsdURL(raw); err != nil {
+			return corev1.Container{}, fmt.Errorf("annotation %s %s", annotationEnvoyDogstatsdURL, err)
+		}
+	}
+	statsTags := append([]string(nil), h.EnvoyStatsTags...)
+	if raw, ok := pod.Annotations[annotationEnvoyStatsTags]; ok && raw != "" {
+		for _, tag := range strings.Split(raw, ",") {
+			tag = strings.TrimSpace(tag)
+			if err := ValidateStatsTag(tag); err != nil {
+				return corev1.Container{}, fmt.Errorf("annotation %s: %s", annotationEnvoyStatsTags, err)
+			}
+			statsTags = append(statsTags, tag)
+		}
+	}
+	if len(statsTags) > 0 {
+		jsonTags, err := json.Marshal(statsTags)
+		if err != nil {
+			h.Log.Error("Error json marshaling stats tags", "Error", err, "Tags", statsTags)
+		} else {
+			data.EnvoyStatsTags = string(jsonTags)
+		}
+	}
+
 	// If upstreams are specified, configure those
 	if raw, ok := pod.Annotations[annotationUpstreams]; ok && raw != "" {
 		for _, raw := range strings.Split(raw, ",") {
@@ -243,6 +307,16 @@ services {
     local_service_address = "127.0.0.1"
     local_service_port = {{ .ServicePort }}
     {{- end }}
+    {{- if or .EnvoyDogstatsdURL .EnvoyStatsTags }}
+    config {
+      {{- if .EnvoyDogstatsdURL }}
+      envoy_dogstatsd_url = "{{ .EnvoyDogstatsdURL }}"
+      {{- end }}
+      {{- if .EnvoyStatsTags }}
+      envoy_stats_tags = {{ .EnvoyStatsTags }}
+      {{- end }}
+    }
+    {{- end }}
     {{- range .Upstreams }}
     upstreams {
       {{- if .Name }}
@@ -301,10 +375,32 @@ protocol = "{{ .ServiceProtocol }}"
 EOF
 {{- end }}
 {{- if .AuthMethod }}
-/bin/consul login -method="{{ .AuthMethod }}" \
+{{- /* Log in with jittered exponential backoff so that a Consul outage
+       isn't made worse by every pending pod. A denied login can't succeed
+       on retry so it fails immediately. */}}
+login_start=$(date +%s)
+login_attempt=1
+login_delay=1
+until /bin/consul login -method="{{ .AuthMethod }}" \
   -bearer-token-file="/var/run/secrets/kubernetes.io/serviceaccount/token" \
   -token-sink-file="/consul/connect-inject/acl-token" \
-  -meta="pod=${POD_NAMESPACE}/${POD_NAME}"
+  -meta="pod=${POD_NAMESPACE}/${POD_NAME}" 2>/consul/connect-inject/login-error; do
+  cat /consul/connect-inject/login-error >&2
+  if grep -q "Permission denied" /consul/connect-inject/login-error; then
+    echo "consul login denied after ${login_attempt} attempt(s), not retrying" >&2
+    exit 1
+  fi
+  if [ "${login_attempt}" -ge {{ .LoginMaxAttempts }} ]; then
+    echo "consul login failed after ${login_attempt} attempt(s)" >&2
+    exit 1
+  fi
+  sleep $((login_delay + RANDOM % login_delay))
+  login_attempt=$((login_attempt + 1))
+  if [ "${login_delay}" -lt 16 ]; then
+    login_delay=$((login_delay * 2))
+  fi
+done
+echo "consul login succeeded after ${login_attempt} attempt(s) in $(($(date +%s) - login_start))s"
 {{- /* The acl token file needs to be read by the lifecycle-sidecar which runs
        as non-root user consul-k8s. */}}
 chmod 444 /consul/connect-inject/acl-token
@@ -620,10 +620,29 @@ func TestHandlerContainerInit_authMethod(t *testing.T) {
 	require.NoError(err)
 	actual := strings.Join(container.Command, " ")
 	require.Contains(actual, `
-/bin/consul login -method="release-name-consul-k8s-auth-method" \
+login_start=$(date +%s)
+login_attempt=1
+login_delay=1
+until /bin/consul login -method="release-name-consul-k8s-auth-method" \
   -bearer-token-file="/var/run/secrets/kubernetes.io/serviceaccount/token" \
   -token-sink-file="/consul/connect-inject/acl-token" \
-  -meta="pod=${POD_NAMESPACE}/${POD_NAME}"
+  -meta="pod=${POD_NAMESPACE}/${POD_NAME}" 2>/consul/connect-inject/login-error; do
+  cat /consul/connect-inject/login-error >&2
+  if grep -q "Permission denied" /consul/connect-inject/login-error; then
+    echo "consul login denied after ${login_attempt} attempt(s), not retrying" >&2
+    exit 1
+  fi
+  if [ "${login_attempt}" -ge 5 ]; then
+    echo "consul login failed after ${login_attempt} attempt(s)" >&2
+    exit 1
+  fi
+  sleep $((login_delay + RANDOM % login_delay))
+  login_attempt=$((login_attempt + 1))
+  if [ "${login_delay}" -lt 16 ]; then
+    login_delay=$((login_delay * 2))
+  fi
+done
+echo "consul login succeeded after ${login_attempt} attempt(s) in $(($(date +%s) - login_start))s"
 chmod 444 /consul/connect-inject/acl-token
 
 /bin/consul services register \
@@ -637,6 +656,150 @@ chmod 444 /consul/connect-inject/acl-token
   -bootstrap > /consul/connect-inject/envoy-bootstrap.yaml`)
 }
 
+// Test that the number of login attempts can be configured.
+func TestHandlerContainerInit_loginMaxAttempts(t *testing.T) {
+	require := require.New(t)
+	h := Handler{
+		AuthMethod:       "release-name-consul-k8s-auth-method",
+		LoginMaxAttempts: 3,
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationService: "foo",
+			},
+		},
+
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+				},
+			},
+		},
+	}
+	container, err := h.containerInit(pod)
+	require.NoError(err)
+	actual := strings.Join(container.Command, " ")
+	require.Contains(actual, `if [ "${login_attempt}" -ge 3 ]; then`)
+}
+
+func TestHandlerContainerInit_envoyDogstatsd(t *testing.T) {
+	cases := map[string]struct {
+		Handler     Handler
+		Annotations map[string]string
+		Expected    string
+	}{
+		"no sink": {
+			Handler:  Handler{},
+			Expected: "",
+		},
+		"default url": {
+			Handler: Handler{EnvoyDogstatsdURL: "udp://${HOST_IP}:8125"},
+			Expected: `
+    config {
+      envoy_dogstatsd_url = "udp://${HOST_IP}:8125"
+    }`,
+		},
+		"default url and tags with annotation tags": {
+			Handler: Handler{
+				EnvoyDogstatsdURL: "udp://${HOST_IP}:8125",
+				EnvoyStatsTags:    []string{"cluster=prod"},
+			},
+			Annotations: map[string]string{
+				annotationEnvoyStatsTags: "team=a, env=b",
+			},
+			Expected: `
+    config {
+      envoy_dogstatsd_url = "udp://${HOST_IP}:8125"
+      envoy_stats_tags = ["cluster=prod","team=a","env=b"]
+    }`,
+		},
+		"annotation url": {
+			Handler: Handler{EnvoyDogstatsdURL: "udp://${HOST_IP}:8125"},
+			Annotations: map[string]string{
+				annotationEnvoyDogstatsdURL: "unix:///var/run/datadog/dsd.socket",
+			},
+			Expected: `
+    config {
+      envoy_dogstatsd_url = "unix:///var/run/datadog/dsd.socket"
+    }`,
+		},
+		"annotation disables url": {
+			Handler: Handler{EnvoyDogstatsdURL: "udp://${HOST_IP}:8125"},
+			Annotations: map[string]string{
+				annotationEnvoyDogstatsdURL: "none",
+			},
+			Expected: "",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			annotations := map[string]string{annotationService: "web"}
+			for k, v := range c.Annotations {
+				annotations[k] = v
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "web"}},
+				},
+			}
+			container, err := c.Handler.containerInit(pod)
+			require.NoError(err)
+			actual := strings.Join(container.Command, " ")
+			if c.Expected == "" {
+				require.NotContains(actual, "config {")
+			} else {
+				require.Contains(actual, `destination_service_id = "${SERVICE_ID}"`+c.Expected)
+			}
+		})
+	}
+}
+
+// Test that invalid DogStatsD annotations are rejected instead of being
+// written into the config.
+func TestHandlerContainerInit_envoyDogstatsdInvalid(t *testing.T) {
+	cases := map[string]struct {
+		Annotations map[string]string
+		ExpErr      string
+	}{
+		"http url": {
+			Annotations: map[string]string{annotationEnvoyDogstatsdURL: "http://localhost:8125"},
+			ExpErr:      "must start with udp:// or unix://",
+		},
+		"quoted url": {
+			Annotations: map[string]string{annotationEnvoyDogstatsdURL: `udp://localhost:8125"`},
+			ExpErr:      "must not contain quotes, backslashes or newlines",
+		},
+		"tag without value": {
+			Annotations: map[string]string{annotationEnvoyStatsTags: "team=a,env"},
+			ExpErr:      `"env" must be in the form name=value`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			annotations := map[string]string{annotationService: "web"}
+			for k, v := range c.Annotations {
+				annotations[k] = v
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "web"}},
+				},
+			}
+			var h Handler
+			_, err := h.containerInit(pod)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), c.ExpErr)
+		})
+	}
+}
+
 func TestHandlerContainerInit_authMethodAndCentralConfig(t *testing.T) {
 	require := require.New(t)
 	h := Handler{
@@ -676,10 +839,29 @@ kind = "service-defaults"
 name = "foo"
 protocol = "grpc"
 EOF
-/bin/consul login -method="release-name-consul-k8s-auth-method" \
+login_start=$(date +%s)
+login_attempt=1
+login_delay=1
+until /bin/consul login -method="release-name-consul-k8s-auth-method" \
   -bearer-token-file="/var/run/secrets/kubernetes.io/serviceaccount/token" \
   -token-sink-file="/consul/connect-inject/acl-token" \
-  -meta="pod=${POD_NAMESPACE}/${POD_NAME}"
+  -meta="pod=${POD_NAMESPACE}/${POD_NAME}" 2>/consul/connect-inject/login-error; do
+  cat /consul/connect-inject/login-error >&2
+  if grep -q "Permission denied" /consul/connect-inject/login-error; then
+    echo "consul login denied after ${login_attempt} attempt(s), not retrying" >&2
+    exit 1
+  fi
+  if [ "${login_attempt}" -ge 5 ]; then
+    echo "consul login failed after ${login_attempt} attempt(s)" >&2
+    exit 1
+  fi
+  sleep $((login_delay + RANDOM % login_delay))
+  login_attempt=$((login_attempt + 1))
+  if [ "${login_delay}" -lt 16 ]; then
+    login_delay=$((login_delay * 2))
+  fi
+done
+echo "consul login succeeded after ${login_attempt} attempt(s) in $(($(date +%s) - login_start))s"
 chmod 444 /consul/connect-inject/acl-token
 /bin/consul config write -cas -modify-index 0 \
   -token-file="/consul/connect-inject/acl-token" \
@@ -0,0 +1,274 @@
+package connectinject
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// drainReason is the reason given to Consul when services are put
+	// into maintenance mode because their node is cordoned. Consul sets it
+	// as the notes of the maintenance check.
+	drainReason = "Kubernetes node is cordoned"
+
+	// serviceMaintenancePrefix is the prefix of the ID of the check that
+	// Consul registers for a service in maintenance mode.
+	serviceMaintenancePrefix = "_service_maintenance:"
+
+	// podNodeNameIndex is the name of the index of the pod informer by
+	// spec.nodeName.
+	podNodeNameIndex = "nodeName"
+)
+
+// NodeDrainResource puts the services of the injected pods on cordoned
+// nodes into maintenance mode, so that they're removed from load
+// balancing before the pods are evicted, e.g. by the cluster autoscaler.
+// They're taken out of maintenance mode if the node is uncordoned, which
+// is checked on the agents rather than remembered, so that it also
+// happens for nodes uncordoned while the resource wasn't running.
+//
+// NodeDrainResource implements controller.Resource and watches nodes.
+// The agents of a node are only contacted when it's cordoned or
+// uncordoned, and again every ResyncPeriod, rather than on every update
+// of its status. Maintenance mode is set again then for a cordoned node,
+// since the lifecycle sidecar re-registers the services.
+//
+// NodeDrainResource implements controller.Backgrounder to watch the pods,
+// which are looked up by node in the cache of the informer.
+type NodeDrainResource struct {
+	Client kubernetes.Interface
+	Log    hclog.Logger
+
+	// ConsulClient returns a client of the Consul agent on the host with
+	// the given IP, which is where the services of the pods on that host
+	// are registered.
+	ConsulClient func(hostIP string) (*api.Client, error)
+
+	// ResyncPeriod is how often the agents of a node are contacted again
+	// if it wasn't cordoned or uncordoned since, which should be the
+	// resync period of the controller. If zero, they're only contacted
+	// when it is.
+	ResyncPeriod time.Duration
+
+	podsOnce sync.Once
+	pods     cache.SharedIndexInformer
+
+	// nodes is the state of each node the last time its agents were
+	// contacted. It's empty after a restart, so the agents of every node
+	// are contacted on the first upsert.
+	lock  sync.Mutex
+	nodes map[string]drainState
+}
+
+// drainState is the state of a node the last time its agents were
+// contacted.
+type drainState struct {
+	unschedulable bool
+	updated       time.Time
+}
+
+// Informer implements the controller.Resource interface.
+func (r *NodeDrainResource) Informer() cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return r.Client.CoreV1().Nodes().List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return r.Client.CoreV1().Nodes().Watch(options)
+			},
+		},
+		&corev1.Node{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+// Run implements the controller.Backgrounder interface. It runs the pod
+// informer.
+func (r *NodeDrainResource) Run(stopCh <-chan struct{}) {
+	r.podInformer().Run(stopCh)
+}
+
+// podInformer returns the informer of the pods, indexed by node.
+func (r *NodeDrainResource) podInformer() cache.SharedIndexInformer {
+	r.podsOnce.Do(func() {
+		r.pods = cache.NewSharedIndexInformer(
+			&cache.ListWatch{
+				ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+					return r.Client.CoreV1().Pods(metav1.NamespaceAll).List(options)
+				},
+				WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+					return r.Client.CoreV1().Pods(metav1.NamespaceAll).Watch(options)
+				},
+			},
+			&corev1.Pod{},
+			0,
+			cache.Indexers{
+				podNodeNameIndex: func(obj interface{}) ([]string, error) {
+					pod, ok := obj.(*corev1.Pod)
+					if !ok || pod.Spec.NodeName == "" {
+						return nil, nil
+					}
+					return []string{pod.Spec.NodeName}, nil
+				},
+			},
+		)
+	})
+	return r.pods
+}
+
+// Upsert implements the controller.Resource interface.
+func (r *NodeDrainResource) Upsert(key string, raw interface{}) error {
+	node, ok := raw.(*corev1.Node)
+	if !ok {
+		r.Log.Warn("upsert got invalid type", "raw", raw)
+		return nil
+	}
+
+	// Most updates of a node are status heartbeats, which don't need the
+	// agents to be contacted. The resync of the controller is compared
+	// against half the period, since it isn't exactly one period after the
+	// last time the agents were contacted.
+	now := time.Now()
+	r.lock.Lock()
+	last, seen := r.nodes[node.Name]
+	r.lock.Unlock()
+	if seen && last.unschedulable == node.Spec.Unschedulable &&
+		(r.ResyncPeriod <= 0 || now.Sub(last.updated) < r.ResyncPeriod/2) {
+		return nil
+	}
+
+	if node.Spec.Unschedulable {
+		if err := r.enableMaintenance(node.Name); err != nil {
+			return err
+		}
+		if !seen || !last.unschedulable {
+			r.Log.Info("node is cordoned, put its Connect services into maintenance mode", "node", node.Name)
+		}
+	} else {
+		// The agents are checked for maintenance mode set by us rather
+		// than only for nodes seen cordoned, since the node might have
+		// been uncordoned while we weren't running.
+		cleared, err := r.clearMaintenance(node.Name)
+		if cleared > 0 {
+			r.Log.Info("node is uncordoned, took its Connect services out of maintenance mode",
+				"node", node.Name, "services", cleared)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.nodes == nil {
+		r.nodes = make(map[string]drainState)
+	}
+	r.nodes[node.Name] = drainState{unschedulable: node.Spec.Unschedulable, updated: now}
+	return nil
+}
+
+// Delete implements the controller.Resource interface. The pods of a
+// deleted node are gone too, so there's nothing to take out of
+// maintenance mode.
+func (r *NodeDrainResource) Delete(key string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.nodes, key)
+	return nil
+}
+
+// enableMaintenance enables maintenance mode for the services and proxies
+// of the injected pods on the given node.
+func (r *NodeDrainResource) enableMaintenance(nodeName string) error {
+	return r.forEachService(nodeName, func(client *api.Client, id string) error {
+		err := client.Agent().EnableServiceMaintenance(id, drainReason)
+		// The services might not be registered yet, or any longer.
+		if err != nil && !strings.Contains(err.Error(), "Unknown service") {
+			return err
+		}
+		return nil
+	})
+}
+
+// clearMaintenance disables maintenance mode for the services and proxies
+// of the injected pods on the given node that were put into maintenance
+// mode because the node was cordoned. Maintenance mode enabled for other
+// reasons, e.g. by an operator, is left as it is. It returns the number
+// of services taken out of maintenance mode.
+func (r *NodeDrainResource) clearMaintenance(nodeName string) (int, error) {
+	// The checks are listed once per agent.
+	agentChecks := make(map[*api.Client]map[string]*api.AgentCheck)
+	cleared := 0
+	err := r.forEachService(nodeName, func(client *api.Client, id string) error {
+		checks, ok := agentChecks[client]
+		if !ok {
+			var err error
+			checks, err = client.Agent().Checks()
+			if err != nil {
+				return err
+			}
+			agentChecks[client] = checks
+		}
+
+		check, ok := checks[serviceMaintenancePrefix+id]
+		if !ok || check.Notes != drainReason {
+			return nil
+		}
+		if err := client.Agent().DisableServiceMaintenance(id); err != nil {
+			return err
+		}
+		cleared++
+		return nil
+	})
+	return cleared, err
+}
+
+// forEachService calls f with the client of the Consul agent and the ID
+// of each service and proxy of the injected pods on the given node.
+func (r *NodeDrainResource) forEachService(nodeName string, f func(client *api.Client, id string) error) error {
+	pods := r.podInformer()
+	if !pods.HasSynced() {
+		return errors.New("pods haven't been synced yet")
+	}
+	objs, err := pods.GetIndexer().ByIndex(podNodeNameIndex, nodeName)
+	if err != nil {
+		return err
+	}
+
+	var result error
+	for _, obj := range objs {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok || pod.Status.HostIP == "" || pod.Annotations[annotationStatus] != "injected" {
+			continue
+		}
+		client, err := r.ConsulClient(pod.Status.HostIP)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+
+		// These are the IDs the init container registers the services with.
+		serviceID := fmt.Sprintf("%s-%s", pod.Name, pod.Annotations[annotationService])
+		for _, id := range []string{serviceID, serviceID + "-sidecar-proxy"} {
+			if err := f(client, id); err != nil {
+				result = multierror.Append(result, fmt.Errorf("service %q: %s", id, err))
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,148 @@
+package connectinject
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/agent"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/testrpc"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestNodeDrainResource(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	a := agent.NewTestAgent(t, t.Name(), "")
+	defer a.Shutdown()
+	testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+	// The proxy isn't registered, which is ignored.
+	require.NoError(a.Client().Agent().ServiceRegister(&api.AgentServiceRegistration{
+		ID:   "web-1-web",
+		Name: "web",
+	}))
+
+	client := fake.NewSimpleClientset(
+		testDrainPod("web-1", "node-1", "injected"),
+		testDrainPod("other-1", "node-1", ""),
+	)
+	var contacted int32
+	r := &NodeDrainResource{
+		Client: client,
+		Log:    hclog.NewNullLogger(),
+		ConsulClient: func(hostIP string) (*api.Client, error) {
+			require.Equal("10.0.0.1", hostIP)
+			atomic.AddInt32(&contacted, 1)
+			return a.Client(), nil
+		},
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	startDrainResource(t, r, stopCh)
+	maintenance := func() bool {
+		checks, err := a.Client().Agent().Checks()
+		require.NoError(err)
+		_, ok := checks["_service_maintenance:web-1-web"]
+		return ok
+	}
+
+	// A schedulable node is left alone.
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	require.NoError(r.Upsert("node-1", node))
+	require.False(maintenance())
+
+	// Cordoning the node puts the services into maintenance mode.
+	node.Spec.Unschedulable = true
+	require.NoError(r.Upsert("node-1", node))
+	require.True(maintenance())
+
+	// Updates that don't cordon or uncordon the node, e.g. of its status,
+	// don't contact the agents.
+	calls := atomic.LoadInt32(&contacted)
+	require.NoError(r.Upsert("node-1", node))
+	require.Equal(calls, atomic.LoadInt32(&contacted))
+
+	// Uncordoning it takes them out again.
+	node.Spec.Unschedulable = false
+	require.NoError(r.Upsert("node-1", node))
+	require.False(maintenance())
+
+	// The services are also taken out of maintenance mode if the node was
+	// uncordoned while another resource, e.g. before a restart, drained it.
+	node.Spec.Unschedulable = true
+	require.NoError(r.Upsert("node-1", node))
+	require.True(maintenance())
+	restarted := &NodeDrainResource{
+		Client:       client,
+		Log:          hclog.NewNullLogger(),
+		ConsulClient: r.ConsulClient,
+	}
+	startDrainResource(t, restarted, stopCh)
+	node.Spec.Unschedulable = false
+	require.NoError(restarted.Upsert("node-1", node))
+	require.False(maintenance())
+
+	// Maintenance mode enabled for another reason is left as it is, also
+	// when the agents are contacted again on a resync.
+	require.NoError(a.Client().Agent().EnableServiceMaintenance("web-1-web", "operator"))
+	resynced := &NodeDrainResource{
+		Client:       client,
+		Log:          hclog.NewNullLogger(),
+		ConsulClient: r.ConsulClient,
+		ResyncPeriod: time.Nanosecond,
+	}
+	startDrainResource(t, resynced, stopCh)
+	require.NoError(resynced.Upsert("node-1", node))
+	calls = atomic.LoadInt32(&contacted)
+	require.NoError(resynced.Upsert("node-1", node))
+	require.NotEqual(calls, atomic.LoadInt32(&contacted))
+	require.True(maintenance())
+}
+
+// Test that the services on a node aren't looked up before the pods have
+// been synced, so that the node is retried rather than skipped.
+func TestNodeDrainResource_notSynced(t *testing.T) {
+	t.Parallel()
+
+	r := &NodeDrainResource{
+		Client: fake.NewSimpleClientset(testDrainPod("web-1", "node-1", "injected")),
+		Log:    hclog.NewNullLogger(),
+		ConsulClient: func(hostIP string) (*api.Client, error) {
+			t.Fatal("agent contacted")
+			return nil, nil
+		},
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	node.Spec.Unschedulable = true
+	require.Error(t, r.Upsert("node-1", node))
+}
+
+// startDrainResource runs the pod informer of r until stopCh is closed and
+// waits for it to sync.
+func startDrainResource(t *testing.T, r *NodeDrainResource, stopCh chan struct{}) {
+	go r.Run(stopCh)
+	require.True(t, cache.WaitForCacheSync(stopCh, r.podInformer().HasSynced))
+}
+
+func testDrainPod(name, nodeName, status string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Annotations: map[string]string{
+				annotationStatus:  status,
+				annotationService: "web",
+			},
+		},
+		Spec:   corev1.PodSpec{NodeName: nodeName},
+		Status: corev1.PodStatus{HostIP: "10.0.0.1"},
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/mattbaird/jsonpatch"
@@ -16,11 +17,16 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	corelisters "k8s.io/client-go/listers/core/v1"
 )
 
 const (
 	DefaultConsulImage = "consul:1.5.0"
 	DefaultEnvoyImage  = "envoyproxy/envoy-alpine:v1.9.1"
+
+	// DefaultLoginMaxAttempts is how many times the init container tries
+	// to log in with the auth method if LoginMaxAttempts isn't set.
+	DefaultLoginMaxAttempts = 5
 )
 
 const (
@@ -75,6 +81,16 @@ const (
 	// consul-k8s lifecycle-sidecar command. This flag controls how often the
 	// service is synced (i.e. re-registered) with the local agent.
 	annotationSyncPeriod = "consul.hashicorp.com/connect-sync-period"
+
+	// annotationEnvoyDogstatsdURL is the DogStatsD URL that Envoy sends
+	// its stats to, e.g. udp://${HOST_IP}:8125. It overrides the default
+	// set on the injector. Setting it to "none" disables the sink.
+	annotationEnvoyDogstatsdURL = "consul.hashicorp.com/envoy-dogstatsd-url"
+
+	// annotationEnvoyStatsTags is a comma-separated list of name=value
+	// tags that Envoy adds to all of its stats. They're added to the tags
+	// set on the injector.
+	annotationEnvoyStatsTags = "consul.hashicorp.com/envoy-stats-tags"
 )
 
 var (
@@ -110,6 +126,11 @@ type Handler struct {
 	// use for identity with connectInjection if ACLs are enabled
 	AuthMethod string
 
+	// LoginMaxAttempts is how many times the init container tries to log
+	// in with the auth method before failing. Defaults to
+	// DefaultLoginMaxAttempts.
+	LoginMaxAttempts int
+
 	// WriteServiceDefaults controls whether injection should write a
 	// service-defaults config entry for each service.
 	// Requires an additional `protocol` parameter.
@@ -119,11 +140,34 @@ type Handler struct {
 	// registrations. It will be overridden by a specific annotation.
 	DefaultProtocol string
 
+	// EnvoyDogstatsdURL is the default DogStatsD URL that Envoy sends its
+	// stats to. ${HOST_IP} is expanded to the IP of the pod's node so that
+	// stats can be sent to a node-local agent. If this is empty, no
+	// DogStatsD sink is configured unless a pod sets the annotation.
+	EnvoyDogstatsdURL string
+
+	// EnvoyStatsTags are name=value tags that Envoy adds to all of its
+	// stats.
+	EnvoyStatsTags []string
+
 	// The PEM-encoded CA certificate string
 	// to use when communicating with Consul clients over HTTPS.
 	// If not set, will use HTTP.
 	ConsulCACert string
 
+	// ImageArchitectures are the node architectures, e.g. amd64 and arm64,
+	// that the injected images are available for. If set, injected pods
+	// are restricted to nodes with one of them by node affinity.
+	ImageArchitectures []string
+
+	// LimitRanges and ResourceQuotas look up the LimitRanges and
+	// ResourceQuotas of the namespace, which the pod with the injected
+	// containers is checked against. They should be backed by informers,
+	// since they're used on every admission. If one is nil, the pod isn't
+	// checked against those.
+	LimitRanges    corelisters.LimitRangeLister
+	ResourceQuotas corelisters.ResourceQuotaLister
+
 	// Log
 	Log hclog.Logger
 }
@@ -134,6 +178,11 @@ type Handler struct {
 func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
 	h.Log.Info("Request received", "Method", r.Method, "URL", r.URL)
 
+	// Requests are errored unless a response is built.
+	start := time.Now()
+	outcome, namespace := outcomeErrored, ""
+	defer func() { observeAdmission(outcome, namespace, start) }()
+
 	if ct := r.Header.Get("Content-Type"); ct != "application/json" {
 		msg := fmt.Sprintf("Invalid content-type: %q", ct)
 		http.Error(w, msg, http.StatusBadRequest)
@@ -165,6 +214,7 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
 		admResp.Response = admissionError(err)
 	} else {
 		admResp.Response = h.Mutate(admReq.Request)
+		namespace = admReq.Request.Namespace
 	}
 
 	resp, err := json.Marshal(&admResp)
@@ -175,6 +225,7 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	outcome = admissionOutcome(admResp.Response)
 	if _, err := w.Write(resp); err != nil {
 		h.Log.Error("Error writing response", "Error", err)
 	}
@@ -272,6 +323,33 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 		}
 	}
 	connectContainer := h.lifecycleSidecar(&pod)
+
+	// Check the pod with the injected containers against the LimitRanges
+	// and ResourceQuotas of the namespace, so that it's rejected with an
+	// error naming them instead of failing later.
+	if h.LimitRanges != nil || h.ResourceQuotas != nil {
+		err := h.checkResources(&pod, req.Namespace,
+			[]corev1.Container{container}, []corev1.Container{esContainer, connectContainer})
+		if err != nil {
+			return &v1beta1.AdmissionResponse{
+				Result: &metav1.Status{
+					Message: fmt.Sprintf("Error validating resources of injected pod: %s", err),
+				},
+			}
+		}
+	}
+
+	// Keep the pod off nodes that the injected images can't run on.
+	archPatches, err := h.archAffinity(&pod)
+	if err != nil {
+		return &v1beta1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: fmt.Sprintf("Error restricting pod to image architectures: %s", err),
+			},
+		}
+	}
+	patches = append(patches, archPatches...)
+
 	patches = append(patches, addContainer(
 		pod.Spec.Containers,
 		[]corev1.Container{esContainer, connectContainer},
@@ -1,6 +1,7 @@
 package connectinject
 
 import (
+	"bytes"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/mattbaird/jsonpatch"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 	"k8s.io/api/admission/v1beta1"
 	corev1 "k8s.io/api/core/v1"
@@ -336,6 +338,23 @@ func TestHandlerHandle(t *testing.T) {
 				},
 			},
 		},
+
+		{
+			"invalid dogstatsd url annotation",
+			Handler{Log: hclog.Default().Named("handler")},
+			v1beta1.AdmissionRequest{
+				Object: encodeRaw(t, &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{
+							annotationEnvoyDogstatsdURL: "http://localhost:8125",
+						},
+					},
+					Spec: basicSpec,
+				}),
+			},
+			"must start with udp:// or unix://",
+			nil,
+		},
 	}
 
 	for _, tt := range cases {
@@ -388,6 +407,69 @@ func TestHandlerHandle_noBody(t *testing.T) {
 	require.Contains(t, rec.Body.String(), "body")
 }
 
+// Test that the duration of requests is recorded by outcome and namespace.
+func TestHandlerHandle_metrics(t *testing.T) {
+	require := require.New(t)
+
+	review := v1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "AdmissionReview",
+			APIVersion: "admission.k8s.io/v1beta1",
+		},
+		Request: &v1beta1.AdmissionRequest{
+			Namespace: "metrics-test",
+			Object: encodeRaw(t, &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "web"}},
+				},
+			}),
+		},
+	}
+	body, err := json.Marshal(&review)
+	require.NoError(err)
+
+	// Pods aren't injected without the annotation, so the request is
+	// skipped.
+	h := Handler{Log: hclog.Default().Named("handler"), RequireAnnotation: true}
+	req, err := http.NewRequest("POST", "/", bytes.NewReader(body))
+	require.NoError(err)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.Handle(rec, req)
+	require.Equal(http.StatusOK, rec.Code)
+	require.Equal(uint64(1), admissionCount(t, outcomeSkipped, "metrics-test"))
+
+	// A request that can't be read is errored.
+	before := admissionCount(t, outcomeErrored, "")
+	req, err = http.NewRequest("POST", "/", nil)
+	require.NoError(err)
+	req.Header.Set("Content-Type", "text/plain")
+	h.Handle(httptest.NewRecorder(), req)
+	require.Equal(before+1, admissionCount(t, outcomeErrored, ""))
+}
+
+// admissionCount returns the number of admission requests recorded with
+// the given outcome and namespace.
+func admissionCount(t *testing.T, outcome, namespace string) uint64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "consul_k8s_connect_inject_admission_duration_seconds" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["outcome"] == outcome && labels["namespace"] == namespace {
+				return m.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
 func TestHandlerDefaultAnnotations(t *testing.T) {
 	cases := []struct {
 		Name     string
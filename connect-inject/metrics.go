@@ -0,0 +1,48 @@
+package connectinject
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/admission/v1beta1"
+)
+
+// Outcomes of admission requests.
+const (
+	outcomeMutated = "mutated"
+	outcomeSkipped = "skipped"
+	outcomeErrored = "errored"
+)
+
+// admissionDuration tracks how long admission requests take by outcome
+// and namespace. The buckets are finer than the defaults at the low end
+// since most requests take a few milliseconds.
+var admissionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "consul_k8s",
+	Subsystem: "connect_inject",
+	Name:      "admission_duration_seconds",
+	Help:      "Duration of admission requests by outcome and namespace.",
+	Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+}, []string{"outcome", "namespace"})
+
+func init() {
+	prometheus.MustRegister(admissionDuration)
+}
+
+// admissionOutcome returns the outcome of the given admission response.
+func admissionOutcome(resp *v1beta1.AdmissionResponse) string {
+	switch {
+	case resp == nil || !resp.Allowed:
+		return outcomeErrored
+	case len(resp.Patch) > 0:
+		return outcomeMutated
+	default:
+		return outcomeSkipped
+	}
+}
+
+// observeAdmission records the duration of an admission request that
+// started at the given time.
+func observeAdmission(outcome, namespace string, start time.Time) {
+	admissionDuration.WithLabelValues(outcome, namespace).Observe(time.Since(start).Seconds())
+}
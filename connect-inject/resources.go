@@ -0,0 +1,197 @@
+package connectinject
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// checkResources returns an error if adding the injected containers to
+// the pod makes it violate a LimitRange or exceed a ResourceQuota of the
+// namespace that it wouldn't have otherwise. Without this, the pod is
+// rejected after injection with an error that doesn't say that injection
+// caused it.
+//
+// LimitRange defaults aren't applied to the injected containers, since
+// the LimitRanger admission plugin runs before the webhook.
+func (h *Handler) checkResources(pod *corev1.Pod, namespace string, initContainers, containers []corev1.Container) error {
+	injected := *pod
+	injected.Spec.InitContainers = append(append([]corev1.Container{}, pod.Spec.InitContainers...), initContainers...)
+	injected.Spec.Containers = append(append([]corev1.Container{}, pod.Spec.Containers...), containers...)
+	added := append(append([]corev1.Container{}, initContainers...), containers...)
+
+	if h.LimitRanges != nil {
+		limitRanges, err := h.LimitRanges.LimitRanges(namespace).List(labels.Everything())
+		if err != nil {
+			return fmt.Errorf("error listing LimitRanges: %s", err)
+		}
+		for _, lr := range limitRanges {
+			if err := checkLimitRange(lr, pod, &injected, added); err != nil {
+				return err
+			}
+		}
+	}
+
+	if h.ResourceQuotas != nil {
+		quotas, err := h.ResourceQuotas.ResourceQuotas(namespace).List(labels.Everything())
+		if err != nil {
+			return fmt.Errorf("error listing ResourceQuotas: %s", err)
+		}
+		for _, quota := range quotas {
+			if err := checkQuota(quota, pod, &injected, added); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkLimitRange checks the container and pod constraints of the
+// LimitRange. Only the injected containers are checked against the
+// container constraints, and the pod constraints are only checked if the
+// pod met them before injection.
+func checkLimitRange(lr *corev1.LimitRange, pod, injected *corev1.Pod, added []corev1.Container) error {
+	for _, item := range lr.Spec.Limits {
+		switch item.Type {
+		case corev1.LimitTypeContainer:
+			for _, c := range added {
+				for name, min := range item.Min {
+					req, ok := c.Resources.Requests[name]
+					if !ok {
+						return fmt.Errorf("LimitRange %q requires a %s request of at least %s per container, "+
+							"but injected container %q has none", lr.Name, name, min.String(), c.Name)
+					}
+					if req.Cmp(min) < 0 {
+						return fmt.Errorf("LimitRange %q requires a %s request of at least %s per container, "+
+							"but injected container %q requests %s", lr.Name, name, min.String(), c.Name, req.String())
+					}
+				}
+				for name, max := range item.Max {
+					limit, ok := c.Resources.Limits[name]
+					if !ok {
+						return fmt.Errorf("LimitRange %q requires a %s limit of at most %s per container, "+
+							"but injected container %q has none", lr.Name, name, max.String(), c.Name)
+					}
+					if limit.Cmp(max) > 0 {
+						return fmt.Errorf("LimitRange %q requires a %s limit of at most %s per container, "+
+							"but injected container %q has a limit of %s", lr.Name, name, max.String(), c.Name, limit.String())
+					}
+				}
+			}
+
+		case corev1.LimitTypePod:
+			for name, max := range item.Max {
+				for _, c := range added {
+					if _, ok := c.Resources.Limits[name]; !ok {
+						return fmt.Errorf("LimitRange %q requires a %s limit on every container of the pod, "+
+							"but injected container %q has none", lr.Name, name, c.Name)
+					}
+				}
+				before, ok := podLimit(pod, name)
+				if !ok || before.Cmp(max) > 0 {
+					continue
+				}
+				if after, _ := podLimit(injected, name); after.Cmp(max) > 0 {
+					return fmt.Errorf("LimitRange %q allows a %s limit of at most %s per pod, "+
+						"but the pod's limit would be %s with the injected containers", lr.Name, name, max.String(), after.String())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkQuota checks that the injected containers set the resources that
+// the ResourceQuota tracks, and that the quota has enough headroom left for
+// the pod with them if it had enough for the pod without. Quotas with
+// scopes aren't checked, since which pods they apply to isn't evaluated.
+func checkQuota(quota *corev1.ResourceQuota, pod, injected *corev1.Pod, added []corev1.Container) error {
+	if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+		return nil
+	}
+	for name, hard := range quota.Spec.Hard {
+		resourceName, limits, ok := quotaResource(name)
+		if !ok {
+			continue
+		}
+		for _, c := range added {
+			list := c.Resources.Requests
+			if limits {
+				list = c.Resources.Limits
+			}
+			if _, ok := list[resourceName]; !ok {
+				return fmt.Errorf("ResourceQuota %q tracks %s, which must be set on every container, "+
+					"but injected container %q doesn't set it", quota.Name, name, c.Name)
+			}
+		}
+
+		used := quota.Status.Used[name]
+		remaining := hard.DeepCopy()
+		remaining.Sub(used)
+		before := podUsage(pod, resourceName, limits)
+		after := podUsage(injected, resourceName, limits)
+		if before.Cmp(remaining) <= 0 && after.Cmp(remaining) > 0 {
+			return fmt.Errorf("ResourceQuota %q would be exceeded by the injected containers: "+
+				"the pod needs %s of %s, but only %s of %s remains", quota.Name, after.String(), name,
+				remaining.String(), hard.String())
+		}
+	}
+	return nil
+}
+
+// quotaResource returns the compute resource that the quota resource name
+// tracks, and whether it tracks limits rather than requests. It returns
+// false for resources other than compute resources, e.g. object counts.
+func quotaResource(name corev1.ResourceName) (corev1.ResourceName, bool, bool) {
+	s, limits := string(name), false
+	if strings.HasPrefix(s, "limits.") {
+		s, limits = strings.TrimPrefix(s, "limits."), true
+	} else {
+		s = strings.TrimPrefix(s, "requests.")
+	}
+
+	switch r := corev1.ResourceName(s); r {
+	case corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage:
+		return r, limits, true
+	}
+	return "", false, false
+}
+
+// podUsage returns the effective request, or limit, of the pod for the
+// resource, which is the larger of the sum over its containers and the
+// largest value of any init container, since those run one at a time.
+func podUsage(pod *corev1.Pod, name corev1.ResourceName, limits bool) resource.Quantity {
+	get := func(c corev1.Container) resource.Quantity {
+		if limits {
+			return c.Resources.Limits[name]
+		}
+		return c.Resources.Requests[name]
+	}
+
+	var total resource.Quantity
+	for _, c := range pod.Spec.Containers {
+		total.Add(get(c))
+	}
+	for _, c := range pod.Spec.InitContainers {
+		if q := get(c); q.Cmp(total) > 0 {
+			total = q
+		}
+	}
+	return total
+}
+
+// podLimit returns the effective limit of the pod for the resource, and
+// false if any of its containers doesn't set one.
+func podLimit(pod *corev1.Pod, name corev1.ResourceName) (resource.Quantity, bool) {
+	for _, cs := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range cs {
+			if _, ok := c.Resources.Limits[name]; !ok {
+				return resource.Quantity{}, false
+			}
+		}
+	}
+	return podUsage(pod, name, true), true
+}
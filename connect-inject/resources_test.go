@@ -0,0 +1,164 @@
+package connectinject
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHandlerMutate_resources(t *testing.T) {
+	cpu := func(request, limit string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(request)},
+			Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(limit)},
+		}
+	}
+
+	cases := []struct {
+		Name    string
+		Objects []runtime.Object
+		Err     string
+	}{
+		{
+			"no constraints",
+			nil,
+			"",
+		},
+
+		{
+			"container minimum",
+			[]runtime.Object{&corev1.LimitRange{
+				ObjectMeta: metav1.ObjectMeta{Name: "min", Namespace: "default"},
+				Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+					Type: corev1.LimitTypeContainer,
+					Min:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m")},
+				}}},
+			}},
+			`LimitRange "min" requires a cpu request of at least 10m per container, but injected container "consul-connect-inject-init" has none`,
+		},
+
+		{
+			"pod maximum without limits on the pod",
+			[]runtime.Object{&corev1.LimitRange{
+				ObjectMeta: metav1.ObjectMeta{Name: "max", Namespace: "default"},
+				Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+					Type: corev1.LimitTypePod,
+					Max:  corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+				}}},
+			}},
+			`LimitRange "max" requires a memory limit on every container of the pod, but injected container "consul-connect-inject-init" has none`,
+		},
+
+		{
+			"quota on requests",
+			[]runtime.Object{&corev1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "default"},
+				Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+					corev1.ResourceRequestsCPU: resource.MustParse("1"),
+				}},
+			}},
+			`ResourceQuota "compute" tracks requests.cpu, which must be set on every container, but injected container "consul-connect-inject-init" doesn't set it`,
+		},
+
+		{
+			"quota on object counts",
+			[]runtime.Object{&corev1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: "count", Namespace: "default"},
+				Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+					corev1.ResourcePods: resource.MustParse("10"),
+				}},
+			}},
+			"",
+		},
+
+		{
+			"scoped quota",
+			[]runtime.Object{&corev1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "default"},
+				Spec: corev1.ResourceQuotaSpec{
+					Hard:   corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("1")},
+					Scopes: []corev1.ResourceQuotaScope{corev1.ResourceQuotaScopeBestEffort},
+				},
+			}},
+			"",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.Name, func(t *testing.T) {
+			require := require.New(t)
+
+			factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(tt.Objects...), 0)
+			h := Handler{
+				LimitRanges:    factory.Core().V1().LimitRanges().Lister(),
+				ResourceQuotas: factory.Core().V1().ResourceQuotas().Lister(),
+				Log:            hclog.Default().Named("handler"),
+			}
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			factory.Start(stopCh)
+			factory.WaitForCacheSync(stopCh)
+			resp := h.Mutate(&v1beta1.AdmissionRequest{
+				Namespace: "default",
+				Object: encodeRaw(t, &corev1.Pod{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "web", Resources: cpu("100m", "200m")}},
+					},
+				}),
+			})
+			if tt.Err == "" {
+				require.True(resp.Allowed, resp.Result)
+				return
+			}
+			require.False(resp.Allowed)
+			require.Contains(resp.Result.Message, tt.Err)
+		})
+	}
+}
+
+func TestCheckQuota_headroom(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	cpu := func(v string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(v)},
+		}
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		Containers: []corev1.Container{{Name: "web", Resources: cpu("300m")}},
+	}}
+	added := []corev1.Container{{Name: "envoy-sidecar", Resources: cpu("100m")}}
+	injected := pod.DeepCopy()
+	injected.Spec.Containers = append(injected.Spec.Containers, added...)
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "compute"},
+		Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+			corev1.ResourceRequestsCPU: resource.MustParse("1"),
+		}},
+		Status: corev1.ResourceQuotaStatus{Used: corev1.ResourceList{
+			corev1.ResourceRequestsCPU: resource.MustParse("650m"),
+		}},
+	}
+
+	// The pod fits without the sidecar but not with it.
+	require.EqualError(checkQuota(quota, pod, injected, added),
+		`ResourceQuota "compute" would be exceeded by the injected containers: the pod needs 400m of requests.cpu, but only 350m of 1 remains`)
+
+	// If the pod doesn't fit even without the sidecar, that's left to the
+	// quota admission to report.
+	quota.Status.Used[corev1.ResourceRequestsCPU] = resource.MustParse("800m")
+	require.NoError(checkQuota(quota, pod, injected, added))
+
+	// The pod fits with the sidecar.
+	quota.Status.Used[corev1.ResourceRequestsCPU] = resource.MustParse("500m")
+	require.NoError(checkQuota(quota, pod, injected, added))
+}
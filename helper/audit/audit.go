@@ -0,0 +1,142 @@
+// Package audit records the changes that components make to Consul so that
+// they can be reviewed later.
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/version"
+)
+
+// Event is a single change made to Consul.
+type Event struct {
+	Time time.Time `json:"time"`
+
+	// Component is the consul-k8s command that made the change, e.g.
+	// sync-catalog.
+	Component string `json:"component"`
+
+	// UserAgent identifies the consul-k8s version that made the change.
+	UserAgent string `json:"user_agent"`
+
+	// Operation is what was changed, e.g. catalog-register.
+	Operation string `json:"operation"`
+
+	// Target is the Consul object that was changed, e.g. a service ID or
+	// policy name.
+	Target string `json:"target"`
+
+	// Source is the Kubernetes object the change was made for, if any.
+	Source string `json:"source,omitempty"`
+
+	// Error is set if the change failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Sink is where events are recorded.
+type Sink interface {
+	Record(Event) error
+}
+
+// Recorder fills in the common fields of events and records them to a
+// sink. A nil Recorder, or one without a sink, records nothing.
+type Recorder struct {
+	Component string
+	Sink      Sink
+}
+
+// Record records that the operation was done on the target for the given
+// source. err is the error of the operation, if it failed. An error is
+// returned if the event couldn't be recorded.
+func (r *Recorder) Record(operation, target, source string, err error) error {
+	if r == nil || r.Sink == nil {
+		return nil
+	}
+
+	e := Event{
+		Time:      time.Now().UTC(),
+		Component: r.Component,
+		UserAgent: "consul-k8s/" + strings.Fields(version.GetHumanVersion())[0],
+		Operation: operation,
+		Target:    target,
+		Source:    source,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return r.Sink.Record(e)
+}
+
+// NewSink returns the sink for the given destination, which is either
+// "stdout" or an http(s) URL. It returns nil if the destination is empty.
+func NewSink(dest string) (Sink, error) {
+	switch {
+	case dest == "":
+		return nil, nil
+	case dest == "stdout":
+		return &WriterSink{W: os.Stdout}, nil
+	case strings.HasPrefix(dest, "http://"), strings.HasPrefix(dest, "https://"):
+		return &HTTPSink{URL: dest}, nil
+	default:
+		return nil, fmt.Errorf("audit log destination must be \"stdout\" or an http(s) URL, got %q", dest)
+	}
+}
+
+// WriterSink writes each event as a line of JSON.
+type WriterSink struct {
+	W io.Writer
+
+	lock sync.Mutex
+}
+
+// Record implements Sink.
+func (s *WriterSink) Record(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.W.Write(append(b, '\n'))
+	return err
+}
+
+// HTTPSink POSTs each event as JSON to a URL.
+type HTTPSink struct {
+	URL string
+
+	// Client is the client used to send the events. If nil, a client
+	// with a 10 second timeout is used.
+	Client *http.Client
+}
+
+// Record implements Sink.
+func (s *HTTPSink) Record(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Post(s.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected response code from audit sink: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder_WriterSink(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	r := &Recorder{Component: "sync-catalog", Sink: &WriterSink{W: &buf}}
+	require.NoError(r.Record("catalog-register", "foo-1", "default/foo", nil))
+	require.NoError(r.Record("catalog-deregister", "foo-2", "", errors.New("boom")))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(lines, 2)
+
+	var e Event
+	require.NoError(json.Unmarshal(lines[0], &e))
+	require.Equal("sync-catalog", e.Component)
+	require.Equal("catalog-register", e.Operation)
+	require.Equal("foo-1", e.Target)
+	require.Equal("default/foo", e.Source)
+	require.Empty(e.Error)
+	require.Contains(e.UserAgent, "consul-k8s/")
+
+	require.NoError(json.Unmarshal(lines[1], &e))
+	require.Equal("boom", e.Error)
+}
+
+func TestRecorder_HTTPSink(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	var received []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(err)
+		var e Event
+		require.NoError(json.Unmarshal(body, &e))
+		received = append(received, e)
+		if e.Target == "rejected" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	r := &Recorder{Component: "server-acl-init", Sink: &HTTPSink{URL: server.URL}}
+	require.NoError(r.Record("policy-create", "client-token", "", nil))
+	require.Error(r.Record("policy-create", "rejected", "", nil))
+	require.Len(received, 2)
+	require.Equal("client-token", received[0].Target)
+}
+
+func TestRecorder_nil(t *testing.T) {
+	t.Parallel()
+	var r *Recorder
+	require.NoError(t, r.Record("policy-create", "client-token", "", nil))
+	require.NoError(t, (&Recorder{}).Record("policy-create", "client-token", "", nil))
+}
+
+func TestNewSink(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	sink, err := NewSink("")
+	require.NoError(err)
+	require.Nil(sink)
+
+	sink, err = NewSink("stdout")
+	require.NoError(err)
+	require.IsType(&WriterSink{}, sink)
+
+	sink, err = NewSink("https://audit.example.com/events")
+	require.NoError(err)
+	require.IsType(&HTTPSink{}, sink)
+
+	_, err = NewSink("/var/log/audit")
+	require.EqualError(err, `audit log destination must be "stdout" or an http(s) URL, got "/var/log/audit"`)
+}
@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// droppedEvents counts the events that were dropped because the buffer of
+// a BufferedSink was full, or because they weren't recorded before it was
+// closed.
+var droppedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "consul_k8s",
+	Subsystem: "audit",
+	Name:      "dropped_events_total",
+	Help:      "Number of audit events dropped because the audit sink couldn't keep up.",
+})
+
+func init() {
+	prometheus.MustRegister(droppedEvents)
+}
+
+// ErrBufferFull is returned by BufferedSink.Record when the event was
+// dropped.
+var ErrBufferFull = errors.New("audit buffer is full, event dropped")
+
+// BufferedSink records events to another sink from a background goroutine
+// so that Record never waits on the other sink, e.g. on an HTTP request
+// while a lock is held. If the buffer is full, events are dropped and
+// counted rather than blocking.
+type BufferedSink struct {
+	sink   Sink
+	log    hclog.Logger
+	events chan Event
+	doneCh chan struct{}
+
+	// stopCh is closed when Close times out, after which the remaining
+	// events are dropped.
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	lock   sync.Mutex
+	closed bool
+}
+
+// NewBufferedSink returns a sink that buffers up to size events and
+// records them to sink in the background. Errors recording them are
+// logged. Close must be called to stop it.
+func NewBufferedSink(sink Sink, size int, logger hclog.Logger) *BufferedSink {
+	s := &BufferedSink{
+		sink:   sink,
+		log:    logger,
+		events: make(chan Event, size),
+		doneCh: make(chan struct{}),
+		stopCh: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Record implements Sink. It returns ErrBufferFull if the event was
+// dropped.
+func (s *BufferedSink) Record(e Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return errors.New("audit sink is closed")
+	}
+
+	select {
+	case s.events <- e:
+		return nil
+	default:
+		droppedEvents.Inc()
+		return ErrBufferFull
+	}
+}
+
+// Close stops accepting events and waits up to timeout for the buffered
+// events to be recorded. The events that aren't recorded by then are
+// dropped and counted, so that a slow sink can't hold up shutdown.
+func (s *BufferedSink) Close(timeout time.Duration) {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+	s.lock.Unlock()
+
+	select {
+	case <-s.doneCh:
+		return
+	case <-time.After(timeout):
+	}
+
+	// The event being recorded, if any, is abandoned.
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	dropped := 0
+	for range s.events {
+		dropped++
+	}
+	droppedEvents.Add(float64(dropped))
+	s.log.Warn("timed out recording audit events, dropped the remaining events",
+		"count", dropped)
+}
+
+func (s *BufferedSink) run() {
+	defer close(s.doneCh)
+	for e := range s.events {
+		select {
+		case <-s.stopCh:
+			droppedEvents.Inc()
+			continue
+		default:
+		}
+
+		if err := s.sink.Record(e); err != nil {
+			s.log.Warn("failed to record audit event",
+				"operation", e.Operation,
+				"target", e.Target,
+				"err", err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingSink records events once it's unblocked. startedCh receives a
+// value each time it starts recording an event.
+type blockingSink struct {
+	startedCh chan struct{}
+	unblockCh chan struct{}
+
+	lock   sync.Mutex
+	events []Event
+}
+
+func (s *blockingSink) Record(e Event) error {
+	s.startedCh <- struct{}{}
+	<-s.unblockCh
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+// Test that events are recorded in the background, and that they are
+// dropped instead of blocking when the buffer is full.
+func TestBufferedSink(t *testing.T) {
+	require := require.New(t)
+
+	inner := &blockingSink{
+		startedCh: make(chan struct{}, 3),
+		unblockCh: make(chan struct{}),
+	}
+	s := NewBufferedSink(inner, 2, hclog.NewNullLogger())
+	dropped := testutil.ToFloat64(droppedEvents)
+
+	// The first event is taken by the background goroutine, which blocks
+	// on the sink, and the next two fill the buffer.
+	require.NoError(s.Record(Event{Target: "a"}))
+	<-inner.startedCh
+	require.NoError(s.Record(Event{Target: "b"}))
+	require.NoError(s.Record(Event{Target: "c"}))
+	require.Equal(ErrBufferFull, s.Record(Event{Target: "d"}))
+	require.Equal(dropped+1, testutil.ToFloat64(droppedEvents))
+
+	// The buffered events are recorded on close.
+	close(inner.unblockCh)
+	s.Close(time.Minute)
+	require.Len(inner.events, 3)
+	require.Equal("a", inner.events[0].Target)
+	require.Equal("c", inner.events[2].Target)
+	require.Error(s.Record(Event{Target: "e"}))
+}
+
+// Test that Close gives up on a sink that doesn't keep up and counts the
+// events that weren't recorded as dropped.
+func TestBufferedSink_closeTimeout(t *testing.T) {
+	require := require.New(t)
+
+	inner := &blockingSink{
+		startedCh: make(chan struct{}, 3),
+		unblockCh: make(chan struct{}),
+	}
+	defer close(inner.unblockCh)
+	s := NewBufferedSink(inner, 2, hclog.NewNullLogger())
+	dropped := testutil.ToFloat64(droppedEvents)
+
+	require.NoError(s.Record(Event{Target: "a"}))
+	<-inner.startedCh
+	require.NoError(s.Record(Event{Target: "b"}))
+	require.NoError(s.Record(Event{Target: "c"}))
+
+	// The sink is still blocked on the first event.
+	s.Close(10 * time.Millisecond)
+	require.Equal(dropped+2, testutil.ToFloat64(droppedEvents))
+	inner.lock.Lock()
+	defer inner.lock.Unlock()
+	require.Empty(inner.events)
+}
@@ -0,0 +1,94 @@
+package cert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"k8s.io/client-go/rest"
+)
+
+// CertManagerCertificate is a cert-manager Certificate that issues the
+// certificate of a webhook to a Secret, which SecretSource can then read.
+type CertManagerCertificate struct {
+	Namespace  string
+	Name       string
+	SecretName string
+	DNSNames   []string
+
+	// IssuerKind is Issuer or ClusterIssuer.
+	IssuerKind string
+	IssuerName string
+}
+
+// Ensure creates the Certificate with the given Kubernetes client config
+// if it doesn't exist. An existing Certificate isn't changed so that it
+// can be managed by other tools once created.
+//
+// The request is made directly rather than through a generated client
+// since cert-manager's types aren't a dependency.
+func (c *CertManagerCertificate) Ensure(config *rest.Config) error {
+	transport, err := rest.TransportFor(config)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: transport}
+	collection := fmt.Sprintf("%s/apis/cert-manager.io/v1/namespaces/%s/certificates",
+		strings.TrimSuffix(config.Host, "/"), c.Namespace)
+
+	resp, err := client.Get(collection + "/" + c.Name)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+	default:
+		return fmt.Errorf("getting Certificate %q: unexpected response code: %d", c.Name, resp.StatusCode)
+	}
+
+	body, err := json.Marshal(c.object())
+	if err != nil {
+		return err
+	}
+	resp, err = client.Post(collection, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusConflict:
+		// A conflict means another replica created it first.
+		return nil
+	default:
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("creating Certificate %q: unexpected response code: %d (%s)",
+			c.Name, resp.StatusCode, msg)
+	}
+}
+
+// object returns the Certificate as a Kubernetes object.
+func (c *CertManagerCertificate) object() map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata": map[string]interface{}{
+			"name":      c.Name,
+			"namespace": c.Namespace,
+		},
+		"spec": map[string]interface{}{
+			"secretName": c.SecretName,
+			"dnsNames":   c.DNSNames,
+			"issuerRef": map[string]interface{}{
+				"group": "cert-manager.io",
+				"kind":  c.IssuerKind,
+				"name":  c.IssuerName,
+			},
+		},
+	}
+}
@@ -0,0 +1,98 @@
+package cert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/rest"
+)
+
+func TestCertManagerCertificate_Ensure(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		GetStatus  int
+		PostStatus int
+		ExpCreate  bool
+		ExpErr     string
+	}{
+		"exists": {
+			GetStatus: http.StatusOK,
+		},
+		"created": {
+			GetStatus:  http.StatusNotFound,
+			PostStatus: http.StatusCreated,
+			ExpCreate:  true,
+		},
+		"created by another replica": {
+			GetStatus:  http.StatusNotFound,
+			PostStatus: http.StatusConflict,
+			ExpCreate:  true,
+		},
+		"forbidden": {
+			GetStatus: http.StatusForbidden,
+			ExpErr:    `getting Certificate "injector": unexpected response code: 403`,
+		},
+		"create fails": {
+			GetStatus:  http.StatusNotFound,
+			PostStatus: http.StatusUnprocessableEntity,
+			ExpCreate:  true,
+			ExpErr:     `creating Certificate "injector": unexpected response code: 422`,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			const path = "/apis/cert-manager.io/v1/namespaces/consul/certificates"
+			var created map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == "GET" && r.URL.Path == path+"/injector":
+					w.WriteHeader(c.GetStatus)
+				case r.Method == "POST" && r.URL.Path == path:
+					require.NoError(json.NewDecoder(r.Body).Decode(&created))
+					w.WriteHeader(c.PostStatus)
+				default:
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			}))
+			defer server.Close()
+
+			cert := &CertManagerCertificate{
+				Namespace:  "consul",
+				Name:       "injector",
+				SecretName: "injector-cert",
+				DNSNames:   []string{"injector.consul.svc"},
+				IssuerKind: "ClusterIssuer",
+				IssuerName: "ca",
+			}
+			err := cert.Ensure(&rest.Config{Host: server.URL})
+			if c.ExpErr != "" {
+				require.Error(err)
+				require.Contains(err.Error(), c.ExpErr)
+			} else {
+				require.NoError(err)
+			}
+
+			if !c.ExpCreate {
+				require.Nil(created)
+				return
+			}
+			require.Equal("Certificate", created["kind"])
+			spec := created["spec"].(map[string]interface{})
+			require.Equal("injector-cert", spec["secretName"])
+			require.Equal([]interface{}{"injector.consul.svc"}, spec["dnsNames"])
+			require.Equal(map[string]interface{}{
+				"group": "cert-manager.io",
+				"kind":  "ClusterIssuer",
+				"name":  "ca",
+			}, spec["issuerRef"])
+		})
+	}
+}
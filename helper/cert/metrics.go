@@ -0,0 +1,51 @@
+package cert
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// expiry is the time at which each managed certificate expires, as a Unix
+// timestamp so that alerts can compare it with time().
+var expiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "consul_k8s",
+	Name:      "certificate_expiry_seconds",
+	Help:      "Unix time at which each managed certificate expires, by purpose and source.",
+}, []string{"purpose", "source"})
+
+func init() {
+	prometheus.MustRegister(expiry)
+}
+
+// RecordExpiry records the expiry of the PEM-encoded certificates used for
+// the given purpose, e.g. "webhook" or "webhook-ca". The source is where
+// the certificates come from, e.g. a Secret name, a file path, or
+// "generated" if they're generated in-process. If there are
+// several certificates, such as in a CA bundle, the earliest expiry is
+// recorded. Nothing is recorded if there are no certificates.
+func RecordExpiry(purpose, source string, pemValue []byte) {
+	var earliest time.Time
+	for rest := pemValue; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if earliest.IsZero() || cert.NotAfter.Before(earliest) {
+			earliest = cert.NotAfter
+		}
+	}
+	if earliest.IsZero() {
+		return
+	}
+	expiry.WithLabelValues(purpose, source).Set(float64(earliest.Unix()))
+}
@@ -0,0 +1,33 @@
+package cert
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordExpiry(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	bundle := testBundle(t)
+	leaf, err := parseCert(bundle.Cert)
+	require.NoError(err)
+	ca, err := parseCert(bundle.CACert)
+	require.NoError(err)
+
+	RecordExpiry("test-webhook", "webhook-cert", bundle.Cert)
+	require.Equal(float64(leaf.NotAfter.Unix()),
+		testutil.ToFloat64(expiry.WithLabelValues("test-webhook", "webhook-cert")))
+
+	// The earliest expiry of a bundle is recorded.
+	RecordExpiry("test-bundle", "generated", append(append([]byte{}, bundle.CACert...), bundle.Cert...))
+	require.True(leaf.NotAfter.Before(ca.NotAfter))
+	require.Equal(float64(leaf.NotAfter.Unix()),
+		testutil.ToFloat64(expiry.WithLabelValues("test-bundle", "generated")))
+
+	// Nothing is recorded without certificates.
+	RecordExpiry("test-empty", "generated", []byte("not a cert"))
+	require.Equal(0.0, testutil.ToFloat64(expiry.WithLabelValues("test-empty", "generated")))
+}
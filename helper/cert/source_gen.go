@@ -7,11 +7,13 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
+	"hash/fnv"
 	"math/big"
 	"net"
 	"strings"
@@ -39,10 +41,32 @@ type GenSource struct {
 	// is about 10% of Expiry.
 	ExpiryWithin time.Duration
 
+	// KeyType is the type of the generated keys, either "ec" or "rsa", and
+	// KeyBits is their size. These default to EC keys of 256 bits for EC
+	// and 2048 bits for RSA. See ValidateKey for the supported sizes.
+	KeyType string
+	KeyBits int
+
+	// StaggerKey, if set, deterministically delays renewal by up to half
+	// of ExpiryWithin based on its hash. With e.g. the pod name as the key,
+	// replicas started at the same time don't all renew at the same time.
+	StaggerKey string
+
+	// CACert and CAKey, if set, are the PEM-encoded certificate and key of
+	// an existing CA that signs the leaf certificates instead of a
+	// generated self-signed CA. The signing certificate may be an
+	// intermediate, in which case CACert can be followed by the rest of
+	// its chain. The intermediates are served with the leaf and the CA
+	// bundle is the roots of the chain, or the whole chain if it has no
+	// self-signed root.
+	CACert []byte
+	CAKey  []byte
+
 	mu             sync.Mutex
 	caCert         []byte
 	caCertTemplate *x509.Certificate
 	caSigner       crypto.Signer
+	intermediates  []byte
 }
 
 // Certificate implements Source
@@ -51,9 +75,13 @@ func (s *GenSource) Certificate(ctx context.Context, last *Bundle) (Bundle, erro
 	defer s.mu.Unlock()
 	var result Bundle
 
-	// If we have no CA, generate it for the first time.
+	// If we have no CA, load or generate it for the first time.
 	if len(s.caCert) == 0 {
-		if err := s.generateCA(); err != nil {
+		generate := s.generateCA
+		if len(s.CACert) > 0 {
+			generate = s.loadCA
+		}
+		if err := generate(); err != nil {
 			return result, err
 		}
 	}
@@ -70,7 +98,7 @@ func (s *GenSource) Certificate(ctx context.Context, last *Bundle) (Bundle, erro
 			return result, err
 		}
 
-		waitTime := cert.NotAfter.Sub(time.Now()) - s.expiryWithin()
+		waitTime := cert.NotAfter.Sub(time.Now()) - s.expiryWithin() + s.stagger()
 		if waitTime < 0 {
 			waitTime = 1 * time.Millisecond
 		}
@@ -114,6 +142,19 @@ func (s *GenSource) expiryWithin() time.Duration {
 	return time.Duration(float64(s.expiry()) * 0.10)
 }
 
+// stagger returns how long renewal is delayed by, which is less than half
+// of expiryWithin so that the leaf is still renewed before it expires.
+func (s *GenSource) stagger() time.Duration {
+	max := uint64(s.expiryWithin() / 2)
+	if s.StaggerKey == "" || max == 0 {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(s.StaggerKey))
+	return time.Duration(h.Sum64() % max)
+}
+
 func (s *GenSource) generateCert() (string, string, error) {
 	// Create the private key we'll use for this leaf cert.
 	signer, keyPEM, err := s.privateKey()
@@ -156,9 +197,73 @@ func (s *GenSource) generateCert() (string, string, error) {
 		return "", "", err
 	}
 
+	// Serve the intermediates so that clients can verify the leaf with
+	// just the roots.
+	buf.Write(s.intermediates)
+
 	return buf.String(), keyPEM, nil
 }
 
+// loadCA loads the CA from CACert and CAKey.
+func (s *GenSource) loadCA() error {
+	var chain []*x509.Certificate
+	rest := s.CACert
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("error parsing CA certificate: %s", err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return fmt.Errorf("no PEM-encoded CA certificate found")
+	}
+	if !chain[0].IsCA {
+		return fmt.Errorf("CA certificate %q is not a CA", chain[0].Subject.CommonName)
+	}
+
+	signer, err := parseSigner(s.CAKey)
+	if err != nil {
+		return err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(pub, chain[0].RawSubjectPublicKeyInfo) {
+		return fmt.Errorf("CA key does not match the CA certificate")
+	}
+
+	var roots, intermediates bytes.Buffer
+	for _, cert := range chain {
+		block := &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}
+		if isSelfSigned(cert) {
+			pem.Encode(&roots, block)
+		} else {
+			pem.Encode(&intermediates, block)
+		}
+	}
+
+	// Without a root, the chain itself is trusted.
+	if roots.Len() == 0 {
+		roots.Write(intermediates.Bytes())
+	}
+
+	s.caCert = roots.Bytes()
+	s.caCertTemplate = chain[0]
+	s.caSigner = signer
+	s.intermediates = intermediates.Bytes()
+	return nil
+}
+
 func (s *GenSource) generateCA() error {
 	// Create the private key we'll use for this CA cert.
 	signer, _, err := s.privateKey()
@@ -210,26 +315,103 @@ func (s *GenSource) generateCA() error {
 	return nil
 }
 
-// privateKey returns a new ECDSA-based private key. Both a crypto.Signer
-// and the key in PEM format are returned.
+// ValidateKey returns an error if keys of the given type and size can't
+// be generated. A size of 0 is the default size of the type.
+func ValidateKey(keyType string, bits int) error {
+	switch keyType {
+	case "", "ec":
+		if bits != 0 && bits != 256 && bits != 384 {
+			return fmt.Errorf("EC keys must be 256 or 384 bits, got %d", bits)
+		}
+	case "rsa":
+		if bits != 0 && (bits < 2048 || bits%1024 != 0) {
+			return fmt.Errorf("RSA keys must be a multiple of 1024 bits of at least 2048, got %d", bits)
+		}
+	default:
+		return fmt.Errorf("key type must be \"ec\" or \"rsa\", got %q", keyType)
+	}
+	return nil
+}
+
+// privateKey returns a new private key of the configured type and size.
+// Both a crypto.Signer and the key in PEM format are returned.
 func (s *GenSource) privateKey() (crypto.Signer, string, error) {
-	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
+	if err := ValidateKey(s.KeyType, s.KeyBits); err != nil {
 		return nil, "", err
 	}
 
-	bs, err := x509.MarshalECPrivateKey(pk)
-	if err != nil {
-		return nil, "", err
+	var signer crypto.Signer
+	var block *pem.Block
+	if s.KeyType == "rsa" {
+		bits := s.KeyBits
+		if bits == 0 {
+			bits = 2048
+		}
+		pk, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, "", err
+		}
+		signer = pk
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pk)}
+	} else {
+		curve := elliptic.P256()
+		if s.KeyBits == 384 {
+			curve = elliptic.P384()
+		}
+		pk, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, "", err
+		}
+		bs, err := x509.MarshalECPrivateKey(pk)
+		if err != nil {
+			return nil, "", err
+		}
+		signer = pk
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: bs}
 	}
 
 	var buf bytes.Buffer
-	err = pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: bs})
-	if err != nil {
+	if err := pem.Encode(&buf, block); err != nil {
 		return nil, "", err
 	}
 
-	return pk, buf.String(), nil
+	return signer, buf.String(), nil
+}
+
+// parseSigner parses a PEM-encoded ECDSA or RSA private key in either its
+// own format or PKCS #8.
+func parseSigner(pemValue []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemValue)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM-encoded CA key found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported CA key type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA key: %s", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CA key type %T", key)
+	}
+	return signer, nil
+}
+
+// isSelfSigned returns true if the certificate is a self-signed root.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
 }
 
 // serialNumber generates a new random serial number.
@@ -238,10 +420,10 @@ func serialNumber() (*big.Int, error) {
 }
 
 // keyId returns a x509 KeyId from the given signing key. The key must be
-// an *ecdsa.PublicKey currently, but may support more types in the future.
+// an *ecdsa.PublicKey or an *rsa.PublicKey.
 func keyId(raw interface{}) ([]byte, error) {
 	switch raw.(type) {
-	case *ecdsa.PublicKey:
+	case *ecdsa.PublicKey, *rsa.PublicKey:
 	default:
 		return nil, fmt.Errorf("invalid key type: %T", raw)
 	}
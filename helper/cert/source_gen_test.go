@@ -2,6 +2,15 @@ package cert
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -65,6 +74,162 @@ func TestGenSource_expiry(t *testing.T) {
 	testBundleVerify(t, &bundle)
 }
 
+// Test that keys of each supported type and size are generated and that
+// the certificates are valid.
+func TestGenSource_keyTypes(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		KeyType string
+		KeyBits int
+		ExpKey  func(*testing.T, crypto.PublicKey)
+	}{
+		"default": {
+			ExpKey: func(t *testing.T, key crypto.PublicKey) {
+				require.Equal(t, elliptic.P256(), key.(*ecdsa.PublicKey).Curve)
+			},
+		},
+		"ec 384": {
+			KeyType: "ec",
+			KeyBits: 384,
+			ExpKey: func(t *testing.T, key crypto.PublicKey) {
+				require.Equal(t, elliptic.P384(), key.(*ecdsa.PublicKey).Curve)
+			},
+		},
+		"rsa 3072": {
+			KeyType: "rsa",
+			KeyBits: 3072,
+			ExpKey: func(t *testing.T, key crypto.PublicKey) {
+				require.Equal(t, 3072, key.(*rsa.PublicKey).N.BitLen())
+			},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			source := testGenSource()
+			source.KeyType = c.KeyType
+			source.KeyBits = c.KeyBits
+			bundle, err := source.Certificate(context.Background(), nil)
+			require.NoError(t, err)
+
+			for _, pemValue := range [][]byte{bundle.Cert, bundle.CACert} {
+				cert, err := parseCert(pemValue)
+				require.NoError(t, err)
+				c.ExpKey(t, cert.PublicKey)
+			}
+			_, err = tls.X509KeyPair(bundle.Cert, bundle.Key)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestValidateKey(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	require.NoError(ValidateKey("", 0))
+	require.NoError(ValidateKey("ec", 384))
+	require.NoError(ValidateKey("rsa", 0))
+	require.NoError(ValidateKey("rsa", 4096))
+	require.EqualError(ValidateKey("ec", 521), "EC keys must be 256 or 384 bits, got 521")
+	require.EqualError(ValidateKey("rsa", 1024),
+		"RSA keys must be a multiple of 1024 bits of at least 2048, got 1024")
+	require.EqualError(ValidateKey("dsa", 0), `key type must be "ec" or "rsa", got "dsa"`)
+}
+
+// Test that the renewal stagger is deterministic and within half of the
+// renewal window.
+func TestGenSource_stagger(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	source := testGenSource()
+	source.Expiry = 30 * 24 * time.Hour
+	source.ExpiryWithin = 24 * time.Hour
+	require.Equal(time.Duration(0), source.stagger())
+
+	seen := make(map[time.Duration]bool)
+	for _, key := range []string{"injector-0", "injector-1", "injector-2"} {
+		source.StaggerKey = key
+		stagger := source.stagger()
+		require.Equal(stagger, source.stagger())
+		require.True(stagger >= 0 && stagger < 12*time.Hour, "stagger: %s", stagger)
+		seen[stagger] = true
+	}
+	require.Len(seen, 3)
+}
+
+// Test that leaf certs are signed by an external intermediate CA, served
+// with the intermediate, and verifiable with the CA bundle.
+func TestGenSource_externalCA(t *testing.T) {
+	t.Parallel()
+
+	root, rootKey := testCA(t, "Root", nil, nil)
+	intermediate, intermediateKey := testCA(t, "Intermediate", root, rootKey)
+
+	cases := map[string]struct {
+		CACert    []byte
+		ExpBundle []byte
+		ExpChain  int
+	}{
+		"intermediate with root": {
+			CACert:    append(testCertPEM(intermediate), testCertPEM(root)...),
+			ExpBundle: testCertPEM(root),
+			ExpChain:  2,
+		},
+		"intermediate only": {
+			CACert:    testCertPEM(intermediate),
+			ExpBundle: testCertPEM(intermediate),
+			ExpChain:  2,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			source := testGenSource()
+			source.CACert = c.CACert
+			source.CAKey = testKeyPEM(t, intermediateKey)
+			bundle, err := source.Certificate(context.Background(), nil)
+			require.NoError(err)
+			require.Equal(c.ExpBundle, bundle.CACert)
+
+			certs := testParseCerts(t, bundle.Cert)
+			require.Len(certs, c.ExpChain)
+			pool := x509.NewCertPool()
+			require.True(pool.AppendCertsFromPEM(bundle.CACert))
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err = certs[0].Verify(x509.VerifyOptions{
+				DNSName:       "localhost",
+				Roots:         pool,
+				Intermediates: intermediates,
+			})
+			require.NoError(err)
+		})
+	}
+}
+
+// Test that a CA key that doesn't match the CA cert is an error.
+func TestGenSource_externalCAKeyMismatch(t *testing.T) {
+	t.Parallel()
+
+	root, _ := testCA(t, "Root", nil, nil)
+	_, otherKey := testCA(t, "Other", nil, nil)
+
+	source := testGenSource()
+	source.CACert = testCertPEM(root)
+	source.CAKey = testKeyPEM(t, otherKey)
+	_, err := source.Certificate(context.Background(), nil)
+	require.EqualError(t, err, "CA key does not match the CA certificate")
+}
+
 func testGenSource() *GenSource {
 	return &GenSource{
 		Name:  "Test",
@@ -116,3 +281,54 @@ func testBundleVerify(t *testing.T, bundle *Bundle) {
 	t.Log(string(output))
 	require.NoError(err)
 }
+
+// testCA creates a CA certificate signed by the given parent, or a
+// self-signed one if parent is nil.
+func testCA(t *testing.T, name string, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	sn, err := serialNumber()
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          sn,
+		Subject:               pkix.Name{CommonName: name},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	bs, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(bs)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func testCertPEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+func testKeyPEM(t *testing.T, key crypto.Signer) []byte {
+	bs, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: bs})
+}
+
+func testParseCerts(t *testing.T, pemValue []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, pemValue = pem.Decode(pemValue)
+		if block == nil {
+			return certs
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+		certs = append(certs, cert)
+	}
+}
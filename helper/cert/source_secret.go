@@ -0,0 +1,76 @@
+package cert
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SecretSource sources certificates from a kubernetes.io/tls Secret, such
+// as one that cert-manager issues a Certificate to. The Secret is watched
+// so that renewed certificates are picked up right away.
+type SecretSource struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+// Certificate implements Source
+func (s *SecretSource) Certificate(ctx context.Context, last *Bundle) (Bundle, error) {
+	selector := fields.OneTermEqualSelector("metadata.name", s.Name).String()
+	for {
+		// List rather than get so that the watch below starts at the
+		// version that was read and no change is missed.
+		list, err := s.Client.CoreV1().Secrets(s.Namespace).List(metav1.ListOptions{
+			FieldSelector: selector,
+		})
+		if err != nil {
+			return Bundle{}, err
+		}
+
+		// If the Secret doesn't exist yet or hasn't been issued a
+		// certificate, wait for it.
+		for i := range list.Items {
+			if list.Items[i].Name != s.Name {
+				continue
+			}
+			bundle := secretBundle(&list.Items[i])
+			if len(bundle.Cert) > 0 && len(bundle.Key) > 0 && (last == nil || !last.Equal(&bundle)) {
+				return bundle, nil
+			}
+		}
+
+		w, err := s.Client.CoreV1().Secrets(s.Namespace).Watch(metav1.ListOptions{
+			FieldSelector:   selector,
+			ResourceVersion: list.ResourceVersion,
+		})
+		if err != nil {
+			return Bundle{}, err
+		}
+		select {
+		case _, ok := <-w.ResultChan():
+			w.Stop()
+			if !ok {
+				// The watch timed out, so just start it again.
+				continue
+			}
+
+		case <-ctx.Done():
+			w.Stop()
+			return Bundle{}, ctx.Err()
+		}
+	}
+}
+
+// secretBundle returns the bundle in the given Secret. The CA certificate
+// is set by cert-manager for CA and self-signed issuers.
+func secretBundle(secret *corev1.Secret) Bundle {
+	return Bundle{
+		Cert:   secret.Data[corev1.TLSCertKey],
+		Key:    secret.Data[corev1.TLSPrivateKeyKey],
+		CACert: secret.Data["ca.crt"],
+	}
+}
@@ -0,0 +1,94 @@
+package cert
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test that the source waits for the Secret to be issued a certificate
+// and then returns the new bundle each time it changes.
+func TestSecretSource(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client := fake.NewSimpleClientset()
+	source := &SecretSource{Client: client, Namespace: "default", Name: "webhook-cert"}
+	bundle := testBundle(t)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-cert"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       bundle.Cert,
+			corev1.TLSPrivateKeyKey: bundle.Key,
+			"ca.crt":                bundle.CACert,
+		},
+	}
+
+	// Another Secret in the namespace is ignored.
+	_, err := client.CoreV1().Secrets("default").Create(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "other"},
+		Data:       secret.Data,
+	})
+	require.NoError(err)
+
+	nextCh := make(chan Bundle, 1)
+	next := func(last *Bundle) {
+		go func() {
+			next, err := source.Certificate(context.Background(), last)
+			require.NoError(err)
+			nextCh <- next
+		}()
+	}
+
+	next(nil)
+	select {
+	case <-nextCh:
+		t.Fatal("should not have received a bundle before the Secret exists")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	_, err = client.CoreV1().Secrets("default").Create(secret)
+	require.NoError(err)
+	var first Bundle
+	select {
+	case first = <-nextCh:
+		testBundleVerify(t, &first)
+	case <-time.After(time.Second):
+		t.Fatal("should have received the bundle")
+	}
+
+	// Renewal.
+	next(&first)
+	time.Sleep(100 * time.Millisecond)
+	renewed := testBundle(t)
+	secret.Data = map[string][]byte{
+		corev1.TLSCertKey:       renewed.Cert,
+		corev1.TLSPrivateKeyKey: renewed.Key,
+		"ca.crt":                renewed.CACert,
+	}
+	_, err = client.CoreV1().Secrets("default").Update(secret)
+	require.NoError(err)
+	select {
+	case second := <-nextCh:
+		require.Equal(*renewed, second)
+	case <-time.After(time.Second):
+		t.Fatal("should have received the renewed bundle")
+	}
+}
+
+// Test that a cancelled context returns right away.
+func TestSecretSource_cancel(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	source := &SecretSource{Client: fake.NewSimpleClientset(), Namespace: "default", Name: "webhook-cert"}
+	_, err := source.Certificate(ctx, nil)
+	require.Equal(t, context.Canceled, err)
+}
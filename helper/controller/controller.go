@@ -9,12 +9,21 @@ import (
 	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"golang.org/x/time/rate"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 )
 
+const (
+	// DefaultRetryBaseDelay and DefaultRetryMaxDelay are the bounds of the
+	// per-item exponential backoff of client-go's default rate limiter.
+	DefaultRetryBaseDelay = 5 * time.Millisecond
+	DefaultRetryMaxDelay  = 1000 * time.Second
+)
+
 // Controller is a generic cache.Controller implementation that watches
 // Kubernetes for changes to specific set of resources and calls the configured
 // callbacks as data changes.
@@ -22,7 +31,48 @@ type Controller struct {
 	Log      hclog.Logger
 	Resource Resource
 
+	// Name is the value of the controller label of the metrics. If this
+	// is empty, "default" is used.
+	Name string
+
+	// Workers is the number of items that are processed concurrently.
+	// If this is zero, a single worker is used.
+	Workers int
+
+	// RetryBaseDelay and RetryMaxDelay bound the exponential backoff of
+	// items that failed processing. If these are zero, the defaults are
+	// used.
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+
+	// ShardByNamespace queues items per namespace and dispatches them
+	// round-robin across namespaces, so that a burst of changes in one
+	// namespace doesn't starve the others. NamespacePriorities is how many
+	// items of a namespace are dispatched in each of its turns; namespaces
+	// that aren't listed get one.
+	ShardByNamespace    bool
+	NamespacePriorities map[string]int
+
+	// ResyncPeriod is how often all known items are queued again even if
+	// they haven't changed. If this is zero, items are only processed when
+	// they change.
+	ResyncPeriod time.Duration
+
+	// SlowThreshold is how long processing an item can take before it's
+	// reported as slow. StuckThreshold is how long an item can keep
+	// failing, including across resyncs, before it's reported as stuck.
+	// If these are zero, items aren't checked. If StuckThreshold is set,
+	// items that are out of retries are still retried, at the max retry
+	// delay or the threshold, whichever is shorter.
+	SlowThreshold  time.Duration
+	StuckThreshold time.Duration
+
+	// Recorder, if set, records a warning event on the object of each
+	// item that is reported as slow or stuck.
+	Recorder record.EventRecorder
+
 	informer cache.SharedIndexInformer
+	watchdog watchdog
 }
 
 // Run starts the Controller and blocks until stopCh is closed.
@@ -38,7 +88,12 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 
 	// Create a queue for storing items to process from the informer.
 	var queueOnce sync.Once
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	var queue workqueue.RateLimitingInterface
+	if c.ShardByNamespace {
+		queue = newFairQueue(c.rateLimiter(), c.NamespacePriorities)
+	} else {
+		queue = workqueue.NewRateLimitingQueue(c.rateLimiter())
+	}
 	shutdown := func() { queue.ShutDown() }
 	defer queueOnce.Do(shutdown)
 
@@ -53,6 +108,7 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 			c.Log.Debug("queue", "op", "add", "key", key)
 			if err == nil {
 				queue.Add(key)
+				c.recordDepth(queue)
 			}
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
@@ -60,6 +116,7 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 			c.Log.Debug("queue", "op", "update", "key", key)
 			if err == nil {
 				queue.Add(key)
+				c.recordDepth(queue)
 			}
 		},
 		DeleteFunc: func(obj interface{}) {
@@ -67,6 +124,7 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 			c.Log.Debug("queue", "op", "delete", "key", key)
 			if err == nil {
 				queue.Add(key)
+				c.recordDepth(queue)
 			}
 		},
 	})
@@ -118,12 +176,37 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 	}
 	c.Log.Info("initial cache sync complete")
 
+	// Periodically queue all the known items if we're resyncing
+	if c.ResyncPeriod > 0 {
+		go wait.Until(func() {
+			keys := informer.GetStore().ListKeys()
+			c.Log.Debug("resyncing", "count", len(keys))
+			for _, key := range keys {
+				queue.Add(key)
+			}
+			c.recordDepth(queue)
+		}, c.ResyncPeriod, stopCh)
+	}
+
+	workers := c.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
 	// run the runWorker method every second with a stop channel
-	wait.Until(func() {
-		for c.processSingle(queue, informer) {
-			// Process
-		}
-	}, time.Second, stopCh)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(func() {
+				for c.processSingle(queue, informer) {
+					// Process
+				}
+			}, time.Second, stopCh)
+		}()
+	}
+	wg.Wait()
 }
 
 // HasSynced implements cache.Controller
@@ -144,6 +227,52 @@ func (c *Controller) LastSyncResourceVersion() string {
 	return c.informer.LastSyncResourceVersion()
 }
 
+// rateLimiter returns the rate limiter for the queue. This is client-go's
+// default controller rate limiter with the configured retry delays.
+func (c *Controller) rateLimiter() workqueue.RateLimiter {
+	base := c.RetryBaseDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+	max := c.RetryMaxDelay
+	if max <= 0 {
+		max = DefaultRetryMaxDelay
+	}
+
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(base, max),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+// stuckRetryDelay returns how long to wait before retrying an item that
+// is out of retries when items are checked for being stuck. It's the max
+// retry delay, but no longer than the stuck threshold so that the item is
+// reported soon after it becomes stuck.
+func (c *Controller) stuckRetryDelay() time.Duration {
+	delay := c.RetryMaxDelay
+	if delay <= 0 {
+		delay = DefaultRetryMaxDelay
+	}
+	if delay > c.StuckThreshold {
+		delay = c.StuckThreshold
+	}
+	return delay
+}
+
+// name returns the value of the controller label of the metrics.
+func (c *Controller) name() string {
+	if c.Name == "" {
+		return "default"
+	}
+	return c.Name
+}
+
+// recordDepth sets the queue depth metric to the length of the queue.
+func (c *Controller) recordDepth(queue workqueue.RateLimitingInterface) {
+	queueDepth.WithLabelValues(c.name()).Set(float64(queue.Len()))
+}
+
 func (c *Controller) processSingle(
 	queue workqueue.RateLimitingInterface,
 	informer cache.SharedIndexInformer,
@@ -154,6 +283,7 @@ func (c *Controller) processSingle(
 		return false
 	}
 	defer queue.Done(key)
+	c.recordDepth(queue)
 
 	// The key should be a string. If it isn't, just ignore it.
 	keyRaw, ok := key.(string)
@@ -169,24 +299,48 @@ func (c *Controller) processSingle(
 	if err == nil {
 		c.Log.Debug("processing object", "key", keyRaw, "exists", exists)
 		c.Log.Trace("processing object", "object", item)
+		start := time.Now()
 		if !exists {
 			err = c.Resource.Delete(keyRaw)
 		} else {
 			err = c.Resource.Upsert(keyRaw, item)
 		}
+		d := time.Since(start)
+		reconcileDuration.WithLabelValues(c.name()).Observe(d.Seconds())
+		c.checkSlow(keyRaw, item, d)
 
 		if err == nil {
 			queue.Forget(key)
 		}
 	}
 
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	reconciles.WithLabelValues(c.name(), result).Inc()
+	c.checkStuck(keyRaw, item, err, time.Now())
+
 	if err != nil {
 		if queue.NumRequeues(key) < 5 {
 			c.Log.Error("failed processing item, retrying", "key", keyRaw, "error", err)
 			queue.AddRateLimited(key)
+		} else if exists && c.StuckThreshold > 0 {
+			// Items that are checked for being stuck keep being retried,
+			// so that they're reported even if there are no resyncs.
+			delay := c.stuckRetryDelay()
+			c.Log.Error("failed processing item, retrying later",
+				"key", keyRaw, "delay", delay, "error", err)
+			queue.AddAfter(key, delay)
+			utilruntime.HandleError(err)
 		} else {
 			c.Log.Error("failed processing item, no more retries", "key", keyRaw, "error", err)
 			queue.Forget(key)
+			// Existing items are retried on the next resync, so they're
+			// only forgotten by the watchdog if they were deleted.
+			if !exists {
+				c.forgetStuck(keyRaw)
+			}
 			utilruntime.HandleError(err)
 		}
 	}
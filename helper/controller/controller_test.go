@@ -1,10 +1,13 @@
 package controller
 
 import (
+	"errors"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -166,6 +169,133 @@ func TestController_backgrounder(t *testing.T) {
 	require.False(bgresource.Running(), "running")
 }
 
+// Test that all items are processed again on resync.
+func TestController_resync(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client := fake.NewSimpleClientset()
+	var lock sync.Mutex
+	upserts := make(map[string]int)
+	resource := NewResource(testInformer(client),
+		func(key string, v interface{}) error {
+			lock.Lock()
+			defer lock.Unlock()
+			upserts[key]++
+			return nil
+		},
+		func(key string) error { return nil },
+	)
+
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(testService("foo"))
+	require.NoError(err)
+
+	// Start the controller
+	c := &Controller{
+		Log:          hclog.Default(),
+		Resource:     resource,
+		Workers:      2,
+		ResyncPeriod: 50 * time.Millisecond,
+	}
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		c.Run(stopCh)
+	}()
+
+	// Wait some period of time
+	time.Sleep(300 * time.Millisecond)
+	close(stopCh)
+	<-doneCh
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.True(upserts["default/foo"] > 1, "upserts: %d", upserts["default/foo"])
+}
+
+// Test that processed items are counted by result.
+func TestController_metrics(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client := fake.NewSimpleClientset()
+	resource := NewResource(testInformer(client),
+		func(key string, v interface{}) error {
+			if key == "default/bar" {
+				return errors.New("upsert failed")
+			}
+			return nil
+		},
+		func(key string) error { return nil },
+	)
+
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(testService("foo"))
+	require.NoError(err)
+	_, err = client.CoreV1().Services(metav1.NamespaceDefault).Create(testService("bar"))
+	require.NoError(err)
+
+	// Start the controller
+	c := &Controller{
+		Log:      hclog.Default(),
+		Resource: resource,
+		Name:     "metrics-test",
+	}
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		c.Run(stopCh)
+	}()
+
+	// Wait some period of time
+	time.Sleep(200 * time.Millisecond)
+	close(stopCh)
+	<-doneCh
+
+	require.Equal(1.0, testutil.ToFloat64(reconciles.WithLabelValues("metrics-test", "success")))
+	require.True(testutil.ToFloat64(reconciles.WithLabelValues("metrics-test", "error")) >= 1)
+}
+
+// Test that an item that keeps failing is retried and reported as stuck
+// even without resyncs.
+func TestController_stuckWithoutResync(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client := fake.NewSimpleClientset()
+	resource := NewResource(testInformer(client),
+		func(key string, v interface{}) error { return errors.New("upsert failed") },
+		func(key string) error { return nil },
+	)
+
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(testService("foo"))
+	require.NoError(err)
+
+	// Start the controller
+	c := &Controller{
+		Log:            hclog.Default(),
+		Resource:       resource,
+		Name:           "stuck-test",
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  10 * time.Millisecond,
+		StuckThreshold: 100 * time.Millisecond,
+	}
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		c.Run(stopCh)
+	}()
+
+	// Wait some period of time
+	time.Sleep(500 * time.Millisecond)
+	close(stopCh)
+	<-doneCh
+
+	require.Equal(1.0, testutil.ToFloat64(stuckItems.WithLabelValues("stuck-test")))
+}
+
 // testBackgrounder implements Backgrounder and has a simple func to check
 // if its running.
 type testBackgrounder struct {
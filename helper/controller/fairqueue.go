@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fairQueue is a workqueue.RateLimitingInterface that queues items per
+// namespace and dispatches them round-robin across the namespaces that
+// have queued items, so that a burst of changes in one namespace doesn't
+// delay the others. Each turn, a namespace gets as many items dispatched
+// as its priority.
+//
+// Like client-go's queue, an item is only queued once at a time, and an
+// item that is added while it's being processed is queued again once it's
+// done, so that it's never processed concurrently.
+type fairQueue struct {
+	limiter    workqueue.RateLimiter
+	priorities map[string]int
+
+	cond         *sync.Cond
+	shards       map[string][]interface{} // queued items per namespace
+	active       []string                 // namespaces with queued items, in dispatch order
+	next         int                      // index in active of the namespace whose turn it is
+	served       int                      // items dispatched in the current turn
+	len          int
+	dirty        map[interface{}]struct{}
+	processing   map[interface{}]struct{}
+	shuttingDown bool
+}
+
+func newFairQueue(limiter workqueue.RateLimiter, priorities map[string]int) *fairQueue {
+	return &fairQueue{
+		limiter:    limiter,
+		priorities: priorities,
+		cond:       sync.NewCond(&sync.Mutex{}),
+		shards:     make(map[string][]interface{}),
+		dirty:      make(map[interface{}]struct{}),
+		processing: make(map[interface{}]struct{}),
+	}
+}
+
+// Add implements workqueue.Interface.
+func (q *fairQueue) Add(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if _, ok := q.dirty[item]; ok {
+		return
+	}
+	q.dirty[item] = struct{}{}
+	if _, ok := q.processing[item]; ok {
+		return
+	}
+	q.push(item)
+}
+
+// Len implements workqueue.Interface.
+func (q *fairQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.len
+}
+
+// Get implements workqueue.Interface. It blocks until an item is queued
+// or the queue is shut down.
+func (q *fairQueue) Get() (interface{}, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for q.len == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if q.len == 0 {
+		return nil, true
+	}
+
+	item := q.pop()
+	q.processing[item] = struct{}{}
+	delete(q.dirty, item)
+	return item, false
+}
+
+// Done implements workqueue.Interface.
+func (q *fairQueue) Done(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	delete(q.processing, item)
+	if _, ok := q.dirty[item]; ok {
+		q.push(item)
+	}
+}
+
+// ShutDown implements workqueue.Interface.
+func (q *fairQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+// ShuttingDown implements workqueue.Interface.
+func (q *fairQueue) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.shuttingDown
+}
+
+// AddAfter implements workqueue.DelayingInterface.
+func (q *fairQueue) AddAfter(item interface{}, duration time.Duration) {
+	if q.ShuttingDown() {
+		return
+	}
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+	time.AfterFunc(duration, func() { q.Add(item) })
+}
+
+// AddRateLimited implements workqueue.RateLimitingInterface.
+func (q *fairQueue) AddRateLimited(item interface{}) {
+	q.AddAfter(item, q.limiter.When(item))
+}
+
+// Forget implements workqueue.RateLimitingInterface.
+func (q *fairQueue) Forget(item interface{}) {
+	q.limiter.Forget(item)
+}
+
+// NumRequeues implements workqueue.RateLimitingInterface.
+func (q *fairQueue) NumRequeues(item interface{}) int {
+	return q.limiter.NumRequeues(item)
+}
+
+// push queues the item in the shard of its namespace. A namespace that
+// had no queued items gets its turn after the other active ones.
+func (q *fairQueue) push(item interface{}) {
+	ns := itemNamespace(item)
+	if len(q.shards[ns]) == 0 {
+		q.active = append(q.active, ns)
+	}
+	q.shards[ns] = append(q.shards[ns], item)
+	q.len++
+	q.cond.Signal()
+}
+
+// pop removes and returns the next item of the namespace whose turn it
+// is, and moves on to the next namespace once this one has had as many
+// items dispatched as its priority or has none left.
+func (q *fairQueue) pop() interface{} {
+	ns := q.active[q.next]
+	item := q.shards[ns][0]
+	q.shards[ns] = q.shards[ns][1:]
+	q.len--
+	q.served++
+
+	if len(q.shards[ns]) == 0 {
+		delete(q.shards, ns)
+		q.active = append(q.active[:q.next], q.active[q.next+1:]...)
+		q.served = 0
+	} else if q.served >= q.priority(ns) {
+		q.next++
+		q.served = 0
+	}
+	if q.next >= len(q.active) {
+		q.next = 0
+	}
+	return item
+}
+
+// priority returns how many items of the namespace are dispatched per
+// turn, which is 1 unless it's configured.
+func (q *fairQueue) priority(ns string) int {
+	if p := q.priorities[ns]; p > 1 {
+		return p
+	}
+	return 1
+}
+
+// itemNamespace returns the namespace of a namespace/name key. Keys of
+// cluster-scoped objects and items that aren't keys share the empty
+// namespace.
+func itemNamespace(item interface{}) string {
+	key, ok := item.(string)
+	if !ok {
+		return ""
+	}
+	if i := strings.Index(key, "/"); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
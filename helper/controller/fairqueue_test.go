@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestFairQueue_impl(t *testing.T) {
+	var _ workqueue.RateLimitingInterface = &fairQueue{}
+}
+
+// Test that namespaces take turns according to their priorities.
+func TestFairQueue_dispatch(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	q := newFairQueue(workqueue.DefaultControllerRateLimiter(), map[string]int{"kube-system": 2})
+	for _, key := range []string{"noisy/a", "noisy/b", "noisy/c", "noisy/d", "kube-system/a", "kube-system/b", "kube-system/c", "node"} {
+		q.Add(key)
+	}
+	require.Equal(8, q.Len())
+
+	var got []string
+	for q.Len() > 0 {
+		item, quit := q.Get()
+		require.False(quit)
+		got = append(got, item.(string))
+		q.Done(item)
+	}
+	require.Equal([]string{
+		"noisy/a",
+		"kube-system/a", "kube-system/b",
+		"node",
+		"noisy/b",
+		"kube-system/c",
+		"noisy/c",
+		"noisy/d",
+	}, got)
+}
+
+// Test that items are deduplicated and never processed concurrently.
+func TestFairQueue_dirty(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	q := newFairQueue(workqueue.DefaultControllerRateLimiter(), nil)
+	q.Add("default/a")
+	q.Add("default/a")
+	require.Equal(1, q.Len())
+
+	item, _ := q.Get()
+	q.Add("default/a")
+	require.Equal(0, q.Len(), "item being processed should not be queued")
+	q.Done(item)
+	require.Equal(1, q.Len(), "item added while processing should be queued when done")
+}
+
+func TestFairQueue_shutDown(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	q := newFairQueue(workqueue.DefaultControllerRateLimiter(), nil)
+	quitCh := make(chan bool, 1)
+	go func() {
+		_, quit := q.Get()
+		quitCh <- quit
+	}()
+
+	q.ShutDown()
+	select {
+	case quit := <-quitCh:
+		require.True(quit)
+	case <-time.After(time.Second):
+		t.Fatal("Get should return when the queue is shut down")
+	}
+	q.Add("default/a")
+	require.Equal(0, q.Len())
+}
+
+func TestFairQueue_addAfter(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	q := newFairQueue(workqueue.DefaultControllerRateLimiter(), nil)
+	q.AddAfter("default/a", 10*time.Millisecond)
+	require.Equal(0, q.Len())
+	item, quit := q.Get()
+	require.False(quit)
+	require.Equal("default/a", item)
+}
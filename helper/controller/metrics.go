@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "consul_k8s"
+	metricsSubsystem = "controller"
+)
+
+var (
+	// reconciles counts the processed items of each controller by result,
+	// either "success" or "error".
+	reconciles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "reconcile_total",
+		Help:      "Number of items processed by each controller.",
+	}, []string{"controller", "result"})
+
+	// reconcileDuration tracks how long processing an item takes.
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of processing an item by each controller.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"controller"})
+
+	// queueDepth is the number of items waiting to be processed.
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "workqueue_depth",
+		Help:      "Number of items in the work queue of each controller.",
+	}, []string{"controller"})
+
+	// slowReconciles counts the items that took longer than the slow
+	// threshold to process.
+	slowReconciles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "slow_reconciles_total",
+		Help:      "Number of items whose processing took longer than the slow threshold.",
+	}, []string{"controller"})
+
+	// stuckItems is the number of items that have been failing for
+	// longer than the stuck threshold.
+	stuckItems = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "stuck_items",
+		Help:      "Number of items that have been failing for longer than the stuck threshold.",
+	}, []string{"controller"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		reconciles,
+		reconcileDuration,
+		queueDepth,
+		slowReconciles,
+		stuckItems,
+	)
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Reasons of the events recorded for slow and stuck items.
+const (
+	EventReasonSlowReconcile = "SlowReconcile"
+	EventReasonStuck         = "ReconcileStuck"
+)
+
+// watchdog keeps track of the items that keep failing so that the ones
+// failing for longer than the stuck threshold can be reported once.
+type watchdog struct {
+	lock         sync.Mutex
+	failingSince map[string]time.Time
+	stuck        map[string]struct{}
+}
+
+// checkSlow reports the item with the given key if processing it took
+// longer than the slow threshold.
+func (c *Controller) checkSlow(key string, item interface{}, d time.Duration) {
+	if c.SlowThreshold <= 0 || d < c.SlowThreshold {
+		return
+	}
+
+	slowReconciles.WithLabelValues(c.name()).Inc()
+	c.Log.Warn("slow processing of item", "key", key, "duration", d)
+	c.event(item, EventReasonSlowReconcile,
+		"Processing took %s, longer than the threshold of %s", d, c.SlowThreshold)
+}
+
+// checkStuck records the result of processing the item with the given key
+// at the given time. An item that has been failing for longer than the
+// stuck threshold is reported the first time it's seen to be, and is no
+// longer stuck once it's processed successfully.
+func (c *Controller) checkStuck(key string, item interface{}, err error, now time.Time) {
+	if c.StuckThreshold <= 0 {
+		return
+	}
+
+	w := &c.watchdog
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.failingSince == nil {
+		w.failingSince = make(map[string]time.Time)
+		w.stuck = make(map[string]struct{})
+	}
+	defer func() {
+		stuckItems.WithLabelValues(c.name()).Set(float64(len(w.stuck)))
+	}()
+
+	if err == nil {
+		delete(w.failingSince, key)
+		delete(w.stuck, key)
+		return
+	}
+
+	since, ok := w.failingSince[key]
+	if !ok {
+		w.failingSince[key] = now
+		return
+	}
+	if _, ok := w.stuck[key]; ok || now.Sub(since) < c.StuckThreshold {
+		return
+	}
+
+	w.stuck[key] = struct{}{}
+	c.Log.Error("item is stuck", "key", key, "failing-since", since, "error", err)
+	c.event(item, EventReasonStuck,
+		"Processing has been failing since %s: %s", since.UTC().Format(time.RFC3339), err)
+}
+
+// forgetStuck stops tracking the item with the given key. It's called when
+// the controller gives up on a deleted item, since nothing queues that
+// item again, so it would otherwise be tracked forever.
+func (c *Controller) forgetStuck(key string) {
+	w := &c.watchdog
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.failingSince == nil {
+		return
+	}
+
+	delete(w.failingSince, key)
+	delete(w.stuck, key)
+	stuckItems.WithLabelValues(c.name()).Set(float64(len(w.stuck)))
+}
+
+// event records a warning event on the given item if there is an event
+// recorder and the item is a Kubernetes object. Deleted items have no
+// object to record the event on.
+func (c *Controller) event(item interface{}, reason, messageFmt string, args ...interface{}) {
+	if c.Recorder == nil {
+		return
+	}
+	obj, ok := item.(runtime.Object)
+	if !ok {
+		return
+	}
+	c.Recorder.Eventf(obj, apiv1.EventTypeWarning, reason, messageFmt, args...)
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestController_checkSlow(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		Log:           hclog.Default(),
+		Name:          "slow-test",
+		SlowThreshold: time.Second,
+		Recorder:      recorder,
+	}
+
+	c.checkSlow("default/foo", testService("foo"), 500*time.Millisecond)
+	require.Len(recorder.Events, 0)
+
+	c.checkSlow("default/foo", testService("foo"), 2*time.Second)
+	require.Len(recorder.Events, 1)
+	require.Contains(<-recorder.Events, "Warning SlowReconcile Processing took 2s")
+	require.Equal(1.0, testutil.ToFloat64(slowReconciles.WithLabelValues("slow-test")))
+
+	// Deleted items have no object to record an event on.
+	c.checkSlow("default/bar", nil, 2*time.Second)
+	require.Len(recorder.Events, 0)
+	require.Equal(2.0, testutil.ToFloat64(slowReconciles.WithLabelValues("slow-test")))
+}
+
+func TestController_checkStuck(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{
+		Log:            hclog.Default(),
+		Name:           "stuck-test",
+		StuckThreshold: time.Minute,
+		Recorder:       recorder,
+	}
+	stuck := func() float64 { return testutil.ToFloat64(stuckItems.WithLabelValues("stuck-test")) }
+
+	start := time.Now()
+	err := errors.New("upsert failed")
+	c.checkStuck("default/foo", testService("foo"), err, start)
+	c.checkStuck("default/foo", testService("foo"), err, start.Add(30*time.Second))
+	require.Len(recorder.Events, 0)
+	require.Equal(0.0, stuck())
+
+	// It's reported once it's been failing for longer than the threshold,
+	// but only once.
+	c.checkStuck("default/foo", testService("foo"), err, start.Add(2*time.Minute))
+	c.checkStuck("default/foo", testService("foo"), err, start.Add(3*time.Minute))
+	require.Len(recorder.Events, 1)
+	require.Contains(<-recorder.Events, "Warning ReconcileStuck")
+	require.Equal(1.0, stuck())
+
+	// Success clears it, so it's reported again if it gets stuck again.
+	c.checkStuck("default/foo", testService("foo"), nil, start.Add(4*time.Minute))
+	require.Equal(0.0, stuck())
+	c.checkStuck("default/foo", testService("foo"), err, start.Add(5*time.Minute))
+	c.checkStuck("default/foo", testService("foo"), err, start.Add(6*time.Minute))
+	require.Len(recorder.Events, 1)
+	require.Equal(1.0, stuck())
+}
+
+// Test that items forgotten by the controller are no longer tracked.
+func TestController_forgetStuck(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	c := &Controller{
+		Log:            hclog.Default(),
+		Name:           "forget-test",
+		StuckThreshold: time.Minute,
+	}
+	stuck := func() float64 { return testutil.ToFloat64(stuckItems.WithLabelValues("forget-test")) }
+
+	// Forgetting before anything is tracked is a no-op.
+	c.forgetStuck("default/foo")
+
+	start := time.Now()
+	err := errors.New("delete failed")
+	c.checkStuck("default/foo", nil, err, start)
+	c.checkStuck("default/foo", nil, err, start.Add(2*time.Minute))
+	c.checkStuck("default/bar", nil, err, start)
+	require.Equal(1.0, stuck())
+
+	c.forgetStuck("default/foo")
+	c.forgetStuck("default/bar")
+	require.Equal(0.0, stuck())
+	require.Empty(c.watchdog.failingSince)
+	require.Empty(c.watchdog.stuck)
+}
@@ -0,0 +1,112 @@
+// Package health serves the readiness endpoint of long-running commands.
+// Readiness is made up of a named check for each subsystem so that it's
+// clear which part of a command is unhealthy.
+package health
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// CheckFunc returns an error if the subsystem it checks isn't ready.
+type CheckFunc func() error
+
+// Synced returns a check that fails until hasSynced returns true, e.g.
+// until an informer has synced.
+func Synced(hasSynced func() bool) CheckFunc {
+	return func() error {
+		if !hasSynced() {
+			return errors.New("not synced yet")
+		}
+		return nil
+	}
+}
+
+// Checker is an http.Handler that runs its checks in the order they were
+// added. It responds with 204 if all of them pass and with 500 and the
+// failed checks otherwise. If the verbose query parameter is set, the
+// status of every check is written, e.g.:
+//
+//	[+]consul ok
+//	[-]to-consul-controller failed: not synced yet
+type Checker struct {
+	lock   sync.Mutex
+	names  []string
+	checks map[string]CheckFunc
+}
+
+// Add adds a check with the given name. A check added with the name of
+// an existing check replaces it.
+func (c *Checker) Add(name string, check CheckFunc) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.checks == nil {
+		c.checks = make(map[string]CheckFunc)
+	}
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+// Select returns a new checker with the checks of the given names, in the
+// given order, e.g. to serve a subset of the checks for liveness. It
+// returns an error if there is no check with one of the names.
+func (c *Checker) Select(names []string) (*Checker, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	selected := &Checker{}
+	for _, name := range names {
+		check, ok := c.checks[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown check %q", name)
+		}
+		selected.Add(name, check)
+	}
+	return selected, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (c *Checker) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	_, verbose := req.URL.Query()["verbose"]
+
+	c.lock.Lock()
+	names := append([]string(nil), c.names...)
+	checks := make(map[string]CheckFunc, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.lock.Unlock()
+
+	var body string
+	failed := false
+	for _, name := range names {
+		if err := checks[name](); err != nil {
+			failed = true
+			body += fmt.Sprintf("[-]%s failed: %s\n", name, err)
+		} else if verbose {
+			body += fmt.Sprintf("[+]%s ok\n", name)
+		}
+	}
+
+	switch {
+	case failed:
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.WriteHeader(http.StatusInternalServerError)
+	case verbose:
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.WriteHeader(http.StatusOK)
+	default:
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	fmt.Fprint(rw, body)
+}
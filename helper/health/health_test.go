@@ -0,0 +1,107 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker_ServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	ok := func() error { return nil }
+	cases := map[string]struct {
+		Checks    map[string]CheckFunc
+		Query     string
+		ExpStatus int
+		ExpBody   string
+	}{
+		"no checks": {
+			ExpStatus: http.StatusNoContent,
+		},
+		"all pass": {
+			Checks:    map[string]CheckFunc{"consul": ok, "controller": ok},
+			ExpStatus: http.StatusNoContent,
+		},
+		"all pass verbose": {
+			Checks:    map[string]CheckFunc{"consul": ok, "controller": ok},
+			Query:     "?verbose",
+			ExpStatus: http.StatusOK,
+			ExpBody:   "[+]consul ok\n[+]controller ok\n",
+		},
+		"one fails": {
+			Checks: map[string]CheckFunc{
+				"consul":     ok,
+				"controller": Synced(func() bool { return false }),
+			},
+			ExpStatus: http.StatusInternalServerError,
+			ExpBody:   "[-]controller failed: not synced yet\n",
+		},
+		"one fails verbose": {
+			Checks: map[string]CheckFunc{
+				"consul":     func() error { return errors.New("no leader") },
+				"controller": Synced(func() bool { return true }),
+			},
+			Query:     "?verbose=1",
+			ExpStatus: http.StatusInternalServerError,
+			ExpBody:   "[-]consul failed: no leader\n[+]controller ok\n",
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			var checker Checker
+			// Add in a fixed order since the output is in the order added.
+			for _, name := range []string{"consul", "controller"} {
+				if check, ok := c.Checks[name]; ok {
+					checker.Add(name, check)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			checker.ServeHTTP(rec, httptest.NewRequest("GET", "/health/ready"+c.Query, nil))
+			require.Equal(c.ExpStatus, rec.Code)
+			require.Equal(c.ExpBody, rec.Body.String())
+		})
+	}
+}
+
+func TestChecker_AddReplaces(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	var checker Checker
+	checker.Add("consul", func() error { return errors.New("unreachable") })
+	checker.Add("consul", func() error { return nil })
+
+	rec := httptest.NewRecorder()
+	checker.ServeHTTP(rec, httptest.NewRequest("GET", "/health/ready?verbose", nil))
+	require.Equal(http.StatusOK, rec.Code)
+	require.Equal("[+]consul ok\n", rec.Body.String())
+}
+
+func TestChecker_Select(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	var checker Checker
+	checker.Add("consul", func() error { return nil })
+	checker.Add("controller", func() error { return errors.New("not synced yet") })
+	checker.Add("acl-token", func() error { return nil })
+
+	selected, err := checker.Select([]string{"acl-token", "consul"})
+	require.NoError(err)
+	rec := httptest.NewRecorder()
+	selected.ServeHTTP(rec, httptest.NewRequest("GET", "/health/live?verbose", nil))
+	require.Equal(http.StatusOK, rec.Code)
+	require.Equal("[+]acl-token ok\n[+]consul ok\n", rec.Body.String())
+
+	_, err = checker.Select([]string{"consul", "leader"})
+	require.EqualError(err, `unknown check "leader"`)
+}
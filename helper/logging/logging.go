@@ -0,0 +1,145 @@
+// Package logging creates the loggers of long-running commands so that
+// the level of each of them can be changed at runtime.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Loggers creates a separate logger for each subsystem of a command. The
+// level of each logger can be changed through ServeHTTP without affecting
+// the others.
+type Loggers struct {
+	// Level is the initial level of the loggers.
+	Level hclog.Level
+
+	// JSON, if true, makes the loggers write JSON instead of text.
+	JSON bool
+
+	// Output is where the loggers write to. Defaults to stderr.
+	Output io.Writer
+
+	lock    sync.Mutex
+	loggers map[string]hclog.Logger
+	levels  map[string]hclog.Level
+}
+
+// Named returns the logger of the subsystem with the given name, creating
+// it if it doesn't exist yet.
+func (l *Loggers) Named(name string) hclog.Logger {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if logger, ok := l.loggers[name]; ok {
+		return logger
+	}
+	if l.loggers == nil {
+		l.loggers = make(map[string]hclog.Logger)
+		l.levels = make(map[string]hclog.Level)
+	}
+
+	output := l.Output
+	if output == nil {
+		output = os.Stderr
+	}
+	logger := hclog.New(&hclog.LoggerOptions{
+		Name:       name,
+		Level:      l.Level,
+		Output:     output,
+		JSONFormat: l.JSON,
+	})
+	l.loggers[name] = logger
+	l.levels[name] = l.Level
+	return logger
+}
+
+// SetLevel sets the level of the loggers whose name starts with the given
+// prefix, or of all loggers if the prefix is empty. It returns the names of
+// the loggers that were changed.
+func (l *Loggers) SetLevel(prefix string, level hclog.Level) []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var names []string
+	for name, logger := range l.loggers {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		logger.SetLevel(level)
+		l.levels[name] = level
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Levels returns the current level of each logger by name.
+func (l *Loggers) Levels() map[string]string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	levels := make(map[string]string, len(l.levels))
+	for name, level := range l.levels {
+		levels[name] = levelString(level)
+	}
+	return levels
+}
+
+// ServeHTTP returns the level of each logger on GET. On PUT it sets the
+// level given by the "level" query parameter on the loggers whose name
+// starts with the "logger" query parameter, or on all loggers if it isn't
+// set.
+func (l *Loggers) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		levelStr := req.URL.Query().Get("level")
+		level := hclog.LevelFromString(levelStr)
+		if level == hclog.NoLevel {
+			http.Error(rw, fmt.Sprintf("unknown log level: %q", levelStr), http.StatusBadRequest)
+			return
+		}
+		prefix := req.URL.Query().Get("logger")
+		if names := l.SetLevel(prefix, level); len(names) == 0 {
+			http.Error(rw, fmt.Sprintf("no loggers named %q", prefix), http.StatusNotFound)
+			return
+		}
+	default:
+		rw.Header().Set("Allow", "GET, PUT")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(l.Levels()); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// levelString returns the name of the level as accepted by
+// hclog.LevelFromString.
+func levelString(level hclog.Level) string {
+	switch level {
+	case hclog.Trace:
+		return "trace"
+	case hclog.Debug:
+		return "debug"
+	case hclog.Info:
+		return "info"
+	case hclog.Warn:
+		return "warn"
+	case hclog.Error:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggers_SetLevel(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	loggers := &Loggers{Level: hclog.Info, Output: &buf}
+	sink := loggers.Named("to-consul/sink")
+	source := loggers.Named("to-consul/source")
+	toK8S := loggers.Named("to-k8s/sink")
+	require.Equal(sink, loggers.Named("to-consul/sink"))
+
+	names := loggers.SetLevel("to-consul", hclog.Debug)
+	require.Equal([]string{"to-consul/sink", "to-consul/source"}, names)
+	require.True(sink.IsDebug())
+	require.True(source.IsDebug())
+	require.False(toK8S.IsDebug())
+
+	require.Equal(map[string]string{
+		"to-consul/sink":   "debug",
+		"to-consul/source": "debug",
+		"to-k8s/sink":      "info",
+	}, loggers.Levels())
+}
+
+func TestLoggers_JSON(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	loggers := &Loggers{Level: hclog.Info, Output: &buf, JSON: true}
+	loggers.Named("sink").Info("hello", "key", "value")
+
+	var line map[string]interface{}
+	require.NoError(json.Unmarshal(buf.Bytes(), &line))
+	require.Equal("hello", line["@message"])
+	require.Equal("sink", line["@module"])
+	require.Equal("value", line["key"])
+}
+
+func TestLoggers_ServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		Method    string
+		Query     string
+		ExpStatus int
+		ExpLevels map[string]string
+	}{
+		"get": {
+			Method:    http.MethodGet,
+			ExpStatus: http.StatusOK,
+			ExpLevels: map[string]string{"a/sink": "info", "b/sink": "info"},
+		},
+		"set all": {
+			Method:    http.MethodPut,
+			Query:     "?level=trace",
+			ExpStatus: http.StatusOK,
+			ExpLevels: map[string]string{"a/sink": "trace", "b/sink": "trace"},
+		},
+		"set one": {
+			Method:    http.MethodPut,
+			Query:     "?level=debug&logger=a",
+			ExpStatus: http.StatusOK,
+			ExpLevels: map[string]string{"a/sink": "debug", "b/sink": "info"},
+		},
+		"unknown level": {
+			Method:    http.MethodPut,
+			Query:     "?level=loud",
+			ExpStatus: http.StatusBadRequest,
+		},
+		"unknown logger": {
+			Method:    http.MethodPut,
+			Query:     "?level=debug&logger=c",
+			ExpStatus: http.StatusNotFound,
+		},
+		"bad method": {
+			Method:    http.MethodPost,
+			ExpStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			loggers := &Loggers{Level: hclog.Info, Output: &bytes.Buffer{}}
+			loggers.Named("a/sink")
+			loggers.Named("b/sink")
+
+			rec := httptest.NewRecorder()
+			loggers.ServeHTTP(rec, httptest.NewRequest(c.Method, "/debug/log-level"+c.Query, nil))
+			require.Equal(c.ExpStatus, rec.Code, rec.Body.String())
+			if c.ExpLevels != nil {
+				var levels map[string]string
+				require.NoError(json.Unmarshal(rec.Body.Bytes(), &levels))
+				require.Equal(c.ExpLevels, levels)
+			}
+		})
+	}
+}
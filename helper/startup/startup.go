@@ -0,0 +1,145 @@
+// Package startup runs the startup of long-running commands as a sequence
+// of phases, e.g. waiting for Consul before starting the informers that
+// write to it. Each phase has its own timeout and the progress of the
+// sequence can be served as a health check, so that a dependency that's
+// slow to start shows up as not ready rather than as a failing process.
+package startup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Phase is a step of the startup.
+type Phase struct {
+	// Name is the name of the phase shown in the health check.
+	Name string
+
+	// Timeout bounds how long the phase may take. If it's zero, the phase
+	// is only bounded by the context of the sequence.
+	Timeout time.Duration
+
+	// Run runs the phase. It must return once ctx is done.
+	Run func(ctx context.Context) error
+}
+
+// Sequence runs phases in order, stopping at the first one that fails.
+type Sequence struct {
+	phases []Phase
+	done   map[string]chan struct{}
+
+	lock    sync.Mutex
+	current string
+	since   time.Time
+	err     error
+}
+
+// NewSequence returns a sequence of the given phases, which must have
+// unique names.
+func NewSequence(phases ...Phase) *Sequence {
+	done := make(map[string]chan struct{}, len(phases))
+	for _, p := range phases {
+		done[p.Name] = make(chan struct{})
+	}
+	return &Sequence{phases: phases, done: done}
+}
+
+// Run runs the phases in order. It returns the error of the first phase
+// that fails or times out.
+func (s *Sequence) Run(ctx context.Context) error {
+	for _, p := range s.phases {
+		s.lock.Lock()
+		s.current = p.Name
+		s.since = time.Now()
+		s.lock.Unlock()
+
+		phaseCtx, cancel := ctx, context.CancelFunc(func() {})
+		if p.Timeout > 0 {
+			phaseCtx, cancel = context.WithTimeout(ctx, p.Timeout)
+		}
+		err := p.Run(phaseCtx)
+		cancel()
+		if err != nil {
+			err = fmt.Errorf("startup phase %q failed: %s", p.Name, err)
+			s.lock.Lock()
+			s.err = err
+			s.lock.Unlock()
+			return err
+		}
+		close(s.done[p.Name])
+	}
+
+	s.lock.Lock()
+	s.current = ""
+	s.lock.Unlock()
+	return nil
+}
+
+// Done returns a channel that's closed once the named phase has completed
+// successfully. It panics if there is no phase with the name.
+func (s *Sequence) Done(name string) <-chan struct{} {
+	ch, ok := s.done[name]
+	if !ok {
+		panic(fmt.Sprintf("startup: unknown phase %q", name))
+	}
+	return ch
+}
+
+// Check returns an error naming the current phase until all the phases
+// have completed. It can be added to a health.Checker.
+func (s *Sequence) Check() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if s.current != "" {
+		return fmt.Errorf("waiting for startup phase %q for %s",
+			s.current, time.Since(s.since).Round(time.Second))
+	}
+	if len(s.phases) > 0 {
+		select {
+		case <-s.done[s.phases[len(s.phases)-1].Name]:
+		default:
+			return errors.New("startup has not begun")
+		}
+	}
+	return nil
+}
+
+// Poll calls cond every interval until it returns nil or ctx is done. If
+// ctx is done first, the last error of cond is returned.
+func Poll(ctx context.Context, interval time.Duration, cond func() error) error {
+	for {
+		err := cond()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return fmt.Errorf("%s, last error: %s", ctx.Err(), err)
+		}
+	}
+}
+
+// WaitForConsul returns once Consul has a leader, checking every interval
+// until ctx is done.
+func WaitForConsul(ctx context.Context, client *api.Client, interval time.Duration) error {
+	return Poll(ctx, interval, func() error {
+		leader, err := client.Status().Leader()
+		if err != nil {
+			return err
+		}
+		if leader == "" {
+			return errors.New("no cluster leader")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,80 @@
+package startup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/agent"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/consul/testrpc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequence_Run(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	seq := NewSequence(
+		Phase{Name: "consul", Run: func(ctx context.Context) error {
+			<-release
+			return nil
+		}},
+		Phase{Name: "informers", Run: func(ctx context.Context) error { return nil }},
+	)
+	require.EqualError(t, seq.Check(), "startup has not begun")
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- seq.Run(context.Background()) }()
+
+	// The health check reports the phase that's being waited for.
+	retry.Run(t, func(r *retry.R) {
+		require.EqualError(r, seq.Check(), `waiting for startup phase "consul" for 0s`)
+	})
+	select {
+	case <-seq.Done("consul"):
+		t.Fatal("consul phase should not be done")
+	default:
+	}
+
+	close(release)
+	require.NoError(t, <-errCh)
+	require.NoError(t, seq.Check())
+	<-seq.Done("consul")
+	<-seq.Done("informers")
+}
+
+func TestSequence_RunTimeout(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	ran := false
+	seq := NewSequence(
+		Phase{Name: "consul", Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+			return Poll(ctx, time.Millisecond, func() error { return errors.New("no cluster leader") })
+		}},
+		Phase{Name: "informers", Run: func(ctx context.Context) error {
+			ran = true
+			return nil
+		}},
+	)
+
+	err := seq.Run(context.Background())
+	require.EqualError(err,
+		`startup phase "consul" failed: context deadline exceeded, last error: no cluster leader`)
+	require.Equal(err, seq.Check())
+	require.False(ran)
+}
+
+func TestWaitForConsul(t *testing.T) {
+	t.Parallel()
+
+	a := agent.NewTestAgent(t, t.Name(), "")
+	defer a.Shutdown()
+	testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, WaitForConsul(ctx, a.Client(), 100*time.Millisecond))
+}
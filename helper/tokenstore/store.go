@@ -0,0 +1,15 @@
+// Package tokenstore stores the ACL tokens created for Consul components
+// so that the components can read them.
+package tokenstore
+
+// Store stores ACL tokens by name. The name is the name of the
+// Kubernetes Secret the token is stored in when using Kubernetes, e.g.
+// <release-name>-consul-client-acl-token.
+type Store interface {
+	// Get returns the token with the given name. If there is no such
+	// token, an empty string and no error is returned.
+	Get(name string) (string, error)
+
+	// Put stores the token with the given name.
+	Put(name, token string) error
+}
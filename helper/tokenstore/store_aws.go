@@ -0,0 +1,44 @@
+package tokenstore
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+// AWSSecretsManagerStore is a Store that stores each token as the string
+// value of an AWS Secrets Manager secret named <Prefix><name>.
+type AWSSecretsManagerStore struct {
+	Client secretsmanageriface.SecretsManagerAPI
+	Prefix string
+}
+
+// Get implements Store
+func (s *AWSSecretsManagerStore) Get(name string) (string, error) {
+	out, err := s.Client.GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(s.Prefix + name),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+			return "", nil
+		}
+		return "", err
+	}
+	return aws.StringValue(out.SecretString), nil
+}
+
+// Put implements Store
+func (s *AWSSecretsManagerStore) Put(name, token string) error {
+	_, err := s.Client.CreateSecret(&secretsmanager.CreateSecretInput{
+		Name:         aws.String(s.Prefix + name),
+		SecretString: aws.String(token),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceExistsException {
+		_, err = s.Client.PutSecretValue(&secretsmanager.PutSecretValueInput{
+			SecretId:     aws.String(s.Prefix + name),
+			SecretString: aws.String(token),
+		})
+	}
+	return err
+}
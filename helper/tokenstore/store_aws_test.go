@@ -0,0 +1,77 @@
+package tokenstore
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAWSSecretsManagerStore_impl(t *testing.T) {
+	var _ Store = &AWSSecretsManagerStore{}
+}
+
+func TestAWSSecretsManagerStore(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client := &testSecretsManager{secrets: make(map[string]string)}
+	s := &AWSSecretsManagerStore{Client: client, Prefix: "consul/"}
+
+	// Missing tokens aren't an error
+	token, err := s.Get("foo")
+	require.NoError(err)
+	require.Empty(token)
+
+	require.NoError(s.Put("foo", "secret"))
+	token, err = s.Get("foo")
+	require.NoError(err)
+	require.Equal("secret", token)
+	require.Equal("secret", client.secrets["consul/foo"])
+
+	// Existing secrets are updated
+	require.NoError(s.Put("foo", "other"))
+	token, err = s.Get("foo")
+	require.NoError(err)
+	require.Equal("other", token)
+}
+
+// testSecretsManager is an in-memory fake of the Secrets Manager API
+// methods used by the store.
+type testSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	lock    sync.Mutex
+	secrets map[string]string
+}
+
+func (m *testSecretsManager) GetSecretValue(in *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	v, ok := m.secrets[aws.StringValue(in.SecretId)]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "not found", nil)
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(v)}, nil
+}
+
+func (m *testSecretsManager) CreateSecret(in *secretsmanager.CreateSecretInput) (*secretsmanager.CreateSecretOutput, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.secrets[aws.StringValue(in.Name)]; ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceExistsException, "exists", nil)
+	}
+	m.secrets[aws.StringValue(in.Name)] = aws.StringValue(in.SecretString)
+	return &secretsmanager.CreateSecretOutput{}, nil
+}
+
+func (m *testSecretsManager) PutSecretValue(in *secretsmanager.PutSecretValueInput) (*secretsmanager.PutSecretValueOutput, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.secrets[aws.StringValue(in.SecretId)] = aws.StringValue(in.SecretString)
+	return &secretsmanager.PutSecretValueOutput{}, nil
+}
@@ -0,0 +1,60 @@
+package tokenstore
+
+import (
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KubernetesStore is a Store that stores each token under the "token" key
+// of a Kubernetes Secret.
+type KubernetesStore struct {
+	Client    kubernetes.Interface
+	Namespace string
+}
+
+// Get implements Store
+func (s *KubernetesStore) Get(name string) (string, error) {
+	secret, err := s.Client.CoreV1().Secrets(s.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	token, ok := secret.Data["token"]
+	if !ok {
+		return "", fmt.Errorf("secret %q does not have data key 'token'", name)
+	}
+	return string(token), nil
+}
+
+// Put implements Store. An existing Secret's token is replaced.
+func (s *KubernetesStore) Put(name, token string) error {
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Data: map[string][]byte{
+			"token": []byte(token),
+		},
+	}
+	_, err := s.Client.CoreV1().Secrets(s.Namespace).Create(secret)
+	if !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	secret, err = s.Client.CoreV1().Secrets(s.Namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data["token"] = []byte(token)
+	_, err = s.Client.CoreV1().Secrets(s.Namespace).Update(secret)
+	return err
+}
@@ -0,0 +1,57 @@
+package tokenstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKubernetesStore_impl(t *testing.T) {
+	var _ Store = &KubernetesStore{}
+}
+
+func TestKubernetesStore(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client := fake.NewSimpleClientset()
+	s := &KubernetesStore{Client: client, Namespace: "default"}
+
+	// Missing tokens aren't an error
+	token, err := s.Get("foo")
+	require.NoError(err)
+	require.Empty(token)
+
+	require.NoError(s.Put("foo", "secret"))
+	token, err = s.Get("foo")
+	require.NoError(err)
+	require.Equal("secret", token)
+
+	secret, err := client.CoreV1().Secrets("default").Get("foo", metav1.GetOptions{})
+	require.NoError(err)
+	require.Equal("secret", string(secret.Data["token"]))
+
+	// An existing token is replaced
+	require.NoError(s.Put("foo", "new-secret"))
+	token, err = s.Get("foo")
+	require.NoError(err)
+	require.Equal("new-secret", token)
+}
+
+func TestKubernetesStore_noTokenKey(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client := fake.NewSimpleClientset()
+	_, err := client.CoreV1().Secrets("default").Create(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+	})
+	require.NoError(err)
+
+	s := &KubernetesStore{Client: client, Namespace: "default"}
+	_, err = s.Get("foo")
+	require.Error(err)
+}
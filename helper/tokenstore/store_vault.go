@@ -0,0 +1,108 @@
+package tokenstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// DefaultServiceAccountTokenPath is the path the Kubernetes service account
+// JWT is mounted at in pods.
+const DefaultServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// VaultStore is a Store that stores each token under the "token" key of a
+// secret in a Vault KV version 2 secrets engine. The secret of a token is
+// at <Prefix>/<name> within the engine mounted at Mount.
+type VaultStore struct {
+	Client *vaultapi.Client
+	Mount  string
+	Prefix string
+}
+
+// Get implements Store
+func (s *VaultStore) Get(name string) (string, error) {
+	secret, err := s.Client.Logical().Read(s.path(name))
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Data["data"] == nil {
+		return "", nil
+	}
+
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("secret %q has unexpected data", s.path(name))
+	}
+	token, ok := data["token"].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %q does not have data key 'token'", s.path(name))
+	}
+	return token, nil
+}
+
+// Put implements Store
+func (s *VaultStore) Put(name, token string) error {
+	_, err := s.Client.Logical().Write(s.path(name), map[string]interface{}{
+		"data": map[string]interface{}{
+			"token": token,
+		},
+	})
+	return err
+}
+
+// path returns the API path of the secret of the named token.
+func (s *VaultStore) path(name string) string {
+	return path.Join(s.Mount, "data", s.Prefix, name)
+}
+
+// VaultKubernetesLogin logs in to Vault with the Kubernetes auth method
+// mounted at authPath using the service account JWT at jwtPath. On
+// success the client uses the resulting Vault token.
+func VaultKubernetesLogin(client *vaultapi.Client, authPath, role, jwtPath string) error {
+	jwt, err := ioutil.ReadFile(jwtPath)
+	if err != nil {
+		return fmt.Errorf("reading service account token: %s", err)
+	}
+
+	return vaultLogin(client, authPath, map[string]interface{}{
+		"role": role,
+		"jwt":  string(jwt),
+	})
+}
+
+// VaultAppRoleLogin logs in to Vault with the AppRole auth method mounted
+// at authPath using the role ID and secret ID in the given files. On
+// success the client uses the resulting Vault token.
+func VaultAppRoleLogin(client *vaultapi.Client, authPath, roleIDPath, secretIDPath string) error {
+	roleID, err := ioutil.ReadFile(roleIDPath)
+	if err != nil {
+		return fmt.Errorf("reading role ID: %s", err)
+	}
+	secretID, err := ioutil.ReadFile(secretIDPath)
+	if err != nil {
+		return fmt.Errorf("reading secret ID: %s", err)
+	}
+
+	return vaultLogin(client, authPath, map[string]interface{}{
+		"role_id":   strings.TrimSpace(string(roleID)),
+		"secret_id": strings.TrimSpace(string(secretID)),
+	})
+}
+
+// vaultLogin logs in to the auth method mounted at authPath with the
+// given login data and sets the resulting token on the client.
+func vaultLogin(client *vaultapi.Client, authPath string, data map[string]interface{}) error {
+	secret, err := client.Logical().Write(path.Join("auth", authPath, "login"), data)
+	if err != nil {
+		return err
+	}
+	if secret == nil || secret.Auth == nil {
+		return fmt.Errorf("login response has no auth data")
+	}
+
+	client.SetToken(secret.Auth.ClientToken)
+	return nil
+}
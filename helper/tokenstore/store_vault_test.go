@@ -0,0 +1,131 @@
+package tokenstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVaultStore_impl(t *testing.T) {
+	var _ Store = &VaultStore{}
+}
+
+func TestVaultStore(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, paths, closer := testVault(t)
+	defer closer()
+	s := &VaultStore{Client: client, Mount: "secret", Prefix: "consul"}
+
+	// Missing tokens aren't an error
+	token, err := s.Get("foo")
+	require.NoError(err)
+	require.Empty(token)
+
+	require.NoError(s.Put("foo", "secret"))
+	token, err = s.Get("foo")
+	require.NoError(err)
+	require.Equal("secret", token)
+	require.Contains(paths(), "/v1/secret/data/consul/foo")
+}
+
+func TestVaultKubernetesLogin(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, paths, closer := testVault(t)
+	defer closer()
+
+	dir, err := ioutil.TempDir("", "tokenstore")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	jwtPath := filepath.Join(dir, "token")
+	require.NoError(ioutil.WriteFile(jwtPath, []byte("jwt"), 0600))
+
+	require.NoError(VaultKubernetesLogin(client, "kubernetes", "consul", jwtPath))
+	require.Equal("login-token", client.Token())
+	require.Contains(paths(), "/v1/auth/kubernetes/login")
+}
+
+func TestVaultAppRoleLogin(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	client, paths, closer := testVault(t)
+	defer closer()
+
+	dir, err := ioutil.TempDir("", "tokenstore")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	roleIDPath := filepath.Join(dir, "role-id")
+	require.NoError(ioutil.WriteFile(roleIDPath, []byte("role\n"), 0600))
+	secretIDPath := filepath.Join(dir, "secret-id")
+	require.NoError(ioutil.WriteFile(secretIDPath, []byte("secret\n"), 0600))
+
+	require.NoError(VaultAppRoleLogin(client, "approle", roleIDPath, secretIDPath))
+	require.Equal("login-token", client.Token())
+	require.Contains(paths(), "/v1/auth/approle/login")
+}
+
+// testVault returns a Vault client for a fake Vault server that stores
+// written data and answers logins. The returned function returns the
+// paths that were written. The server is stopped by calling the returned
+// closer.
+func testVault(t *testing.T) (*vaultapi.Client, func() []string, func()) {
+	var lock sync.Mutex
+	data := make(map[string]interface{})
+	var written []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch r.Method {
+		case http.MethodGet:
+			v, ok := data[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errors":[]}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": v})
+
+		case http.MethodPut, http.MethodPost:
+			written = append(written, r.URL.Path)
+			if strings.HasPrefix(r.URL.Path, "/v1/auth/") && strings.HasSuffix(r.URL.Path, "/login") {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"auth": map[string]interface{}{"client_token": "login-token"},
+				})
+				return
+			}
+
+			var body map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data[r.URL.Path] = body
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	client, err := vaultapi.NewClient(&vaultapi.Config{Address: srv.URL})
+	require.NoError(t, err)
+	client.SetToken("root")
+
+	return client, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), written...)
+	}, srv.Close
+}
@@ -11,11 +11,11 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/hashicorp/consul-k8s/helper/tokenstore"
 	"github.com/hashicorp/consul-k8s/subcommand"
 	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/hashicorp/consul/command/flags"
 	"github.com/mitchellh/cli"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -24,12 +24,14 @@ type Command struct {
 
 	flags          *flag.FlagSet
 	k8s            *k8sflags.K8SFlags
+	tokenStore     *k8sflags.TokenStoreFlags
 	flagSecretName string
 	flagInitType   string
 	flagNamespace  string
 	flagACLDir     string
 
 	k8sClient *kubernetes.Clientset
+	store     tokenstore.Store
 
 	once sync.Once
 	help string
@@ -47,7 +49,9 @@ func (c *Command) init() {
 		"Directory name of shared volume where acl config will be output")
 
 	c.k8s = &k8sflags.K8SFlags{}
+	c.tokenStore = &k8sflags.TokenStoreFlags{}
 	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, c.tokenStore.Flags())
 	c.help = flags.Usage(help, c.flags)
 }
 
@@ -60,6 +64,10 @@ func (c *Command) Run(args []string) int {
 		c.UI.Error(fmt.Sprintf("Should have no non-flag arguments."))
 		return 1
 	}
+	if err := c.tokenStore.Validate(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 
 	config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
 	if err != nil {
@@ -74,6 +82,13 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
+	// Create the store the token is read from
+	c.store, err = c.tokenStore.Store(c.k8sClient, c.flagNamespace)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
 	// Check if the client secret exists yet
 	// If not, wait until it does
 	var secret string
@@ -111,13 +126,14 @@ func (c *Command) Run(args []string) int {
 }
 
 func (c *Command) getSecret(secretName string) (string, error) {
-	secret, err := c.k8sClient.CoreV1().Secrets(c.flagNamespace).Get(secretName, metav1.GetOptions{})
+	token, err := c.store.Get(secretName)
 	if err != nil {
 		return "", err
 	}
-
-	// Extract token
-	return string(secret.Data["token"]), nil
+	if token == "" {
+		return "", fmt.Errorf("token %q does not exist yet", secretName)
+	}
+	return token, nil
 }
 
 func (c *Command) Synopsis() string { return synopsis }
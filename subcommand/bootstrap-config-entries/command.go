@@ -0,0 +1,289 @@
+package bootstrapconfigentries
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+)
+
+// Command is the command for applying the baseline config entries of a
+// cluster.
+type Command struct {
+	UI cli.Ui
+
+	flags                      *flag.FlagSet
+	http                       *flags.HTTPFlags
+	flagConfigFile             string
+	flagDefaultIntentionAction string
+	flagOverwrite              bool
+	flagTimeout                time.Duration
+	flagLogLevel               string
+
+	consulClient *api.Client
+	cmdTimeout   context.Context
+
+	// retryDuration is how often we'll retry failed operations.
+	retryDuration time.Duration
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagConfigFile, "config-file", "",
+		"Path to a JSON file with an array of config entries to apply.")
+	c.flags.StringVar(&c.flagDefaultIntentionAction, "default-intention-action", "",
+		"If set to \"allow\" or \"deny\", an intention with this action from all "+
+			"services to all services is created if there isn't one.")
+	c.flags.BoolVar(&c.flagOverwrite, "overwrite", false,
+		"If true, config entries that already exist are overwritten. By default "+
+			"they're left as they are so that changes made since install are kept.")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 10*time.Minute,
+		"How long to keep retrying before giving up. Defaults to 10m.")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	c.help = flags.Usage(help, c.flags)
+
+	// Default retry to 1s. This is exposed for setting in tests.
+	if c.retryDuration == 0 {
+		c.retryDuration = 1 * time.Second
+	}
+}
+
+// Run applies the config entries and the default intention, retrying
+// until they're applied or the timeout is reached.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.validateFlags(); err != nil {
+		c.UI.Error("Error: " + err.Error())
+		return 1
+	}
+	logLevel := hclog.LevelFromString(c.flagLogLevel)
+	if logLevel == hclog.NoLevel {
+		c.UI.Error(fmt.Sprintf("Unknown log level: %s", c.flagLogLevel))
+		return 1
+	}
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:  logLevel,
+		Output: os.Stderr,
+	})
+
+	var entries []api.ConfigEntry
+	if c.flagConfigFile != "" {
+		var err error
+		entries, err = readConfigEntries(c.flagConfigFile)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading -config-file: %s", err))
+			return 1
+		}
+	}
+
+	// The client might already be set if we're in a test.
+	if c.consulClient == nil {
+		var err error
+		c.consulClient, err = c.http.APIClient()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	var cancel context.CancelFunc
+	c.cmdTimeout, cancel = context.WithTimeout(context.Background(), c.flagTimeout)
+	// The context will only ever be intentionally ended by the timeout.
+	defer cancel()
+
+	for _, entry := range entries {
+		entry := entry
+		opName := fmt.Sprintf("applying %s config entry %q", entry.GetKind(), entry.GetName())
+		err := c.untilSucceeds(opName, func() error {
+			return c.applyConfigEntry(logger, entry)
+		}, logger)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error %s: %s", opName, err))
+			return 1
+		}
+	}
+
+	if c.flagDefaultIntentionAction != "" {
+		opName := "creating default intention"
+		err := c.untilSucceeds(opName, func() error {
+			return c.createDefaultIntention(logger)
+		}, logger)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error %s: %s", opName, err))
+			return 1
+		}
+	}
+
+	c.UI.Info("Config entries bootstrapped")
+	return 0
+}
+
+// applyConfigEntry writes the given config entry with a check-and-set so
+// that concurrent writes aren't lost. An existing entry is only replaced
+// with -overwrite.
+func (c *Command) applyConfigEntry(logger hclog.Logger, entry api.ConfigEntry) error {
+	configEntries := c.consulClient.ConfigEntries()
+	var index uint64
+	existing, _, err := configEntries.Get(entry.GetKind(), entry.GetName(), nil)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if !c.flagOverwrite {
+			logger.Info("config entry already exists, leaving it as it is",
+				"kind", entry.GetKind(), "name", entry.GetName())
+			return nil
+		}
+		index = existing.GetModifyIndex()
+	}
+
+	// With an index of 0 the write only succeeds if the entry doesn't exist.
+	ok, _, err := configEntries.CAS(entry, index, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("config entry was modified concurrently")
+	}
+	return nil
+}
+
+// createDefaultIntention creates the intention from all services to all
+// services if there isn't one. An existing one is left as it is, even if
+// its action differs, since it's been set intentionally.
+func (c *Command) createDefaultIntention(logger hclog.Logger) error {
+	intentions, _, err := c.consulClient.Connect().Intentions(nil)
+	if err != nil {
+		return err
+	}
+	for _, ixn := range intentions {
+		if ixn.SourceName == api.IntentionWildcard && ixn.DestinationName == api.IntentionWildcard {
+			if string(ixn.Action) != c.flagDefaultIntentionAction {
+				logger.Warn("default intention already exists with a different action, leaving it as it is",
+					"action", ixn.Action)
+			}
+			return nil
+		}
+	}
+
+	_, _, err = c.consulClient.Connect().IntentionCreate(&api.Intention{
+		SourceName:      api.IntentionWildcard,
+		DestinationName: api.IntentionWildcard,
+		SourceType:      api.IntentionSourceConsul,
+		Action:          api.IntentionAction(c.flagDefaultIntentionAction),
+		Description:     "Default intention created by consul-k8s",
+	}, nil)
+	return err
+}
+
+// untilSucceeds runs op until it returns nil or the command times out.
+func (c *Command) untilSucceeds(opName string, op func() error, logger hclog.Logger) error {
+	for {
+		err := op()
+		if err == nil {
+			logger.Info(fmt.Sprintf("Success: %s", opName))
+			return nil
+		}
+		logger.Error(fmt.Sprintf("Failure: %s", opName), "err", err)
+		logger.Info("Retrying in " + c.retryDuration.String())
+		// Wait on either the retry duration (in which case we continue) or the
+		// overall command timeout.
+		select {
+		case <-time.After(c.retryDuration):
+			continue
+		case <-c.cmdTimeout.Done():
+			return errors.New("reached command timeout")
+		}
+	}
+}
+
+func (c *Command) validateFlags() error {
+	if len(c.flags.Args()) > 0 {
+		return errors.New("should have no non-flag arguments")
+	}
+	if c.flagConfigFile == "" && c.flagDefaultIntentionAction == "" {
+		return errors.New("-config-file or -default-intention-action must be set")
+	}
+	switch c.flagDefaultIntentionAction {
+	case "", string(api.IntentionActionAllow), string(api.IntentionActionDeny):
+	default:
+		return fmt.Errorf("-default-intention-action must be %q or %q",
+			api.IntentionActionAllow, api.IntentionActionDeny)
+	}
+	if c.flagTimeout <= 0 {
+		return errors.New("-timeout must be greater than 0")
+	}
+	return nil
+}
+
+// readConfigEntries reads the config entries from the JSON array in the
+// given file.
+func readConfigEntries(path string) ([]api.ConfigEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, err
+	}
+	entries := make([]api.ConfigEntry, 0, len(raws))
+	for i, raw := range raws {
+		entry, err := api.DecodeConfigEntryFromJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %s", i, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// isNotFound returns true if the error is from Consul not finding the
+// requested config entry.
+func isNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Unexpected response code: 404")
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Apply the baseline config entries of a Consul cluster."
+const help = `
+Usage: consul-k8s bootstrap-config-entries [options]
+
+  Applies the config entries in -config-file, e.g. the global
+  proxy-defaults, and optionally the default intention from all services
+  to all services. It's meant to be run as a Job at install time so that
+  new clusters start with the intended configuration.
+
+  Config entries are written with a check-and-set. Entries that already
+  exist are left as they are unless -overwrite is set, so running the
+  command again is safe.
+
+`
@@ -0,0 +1,149 @@
+package bootstrapconfigentries
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/consul/agent"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/testrpc"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{},
+			ExpErr: "-config-file or -default-intention-action must be set",
+		},
+		{
+			Flags:  []string{"-default-intention-action", "block"},
+			ExpErr: `-default-intention-action must be "allow" or "deny"`,
+		},
+		{
+			Flags:  []string{"-default-intention-action", "deny", "-timeout", "0s"},
+			ExpErr: "-timeout must be greater than 0",
+		},
+		{
+			Flags:  []string{"-config-file", "/does/not/exist"},
+			ExpErr: "Error reading -config-file",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	a := agent.NewTestAgent(t, t.Name(), `connect { enabled = true }`)
+	defer a.Shutdown()
+	testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+	configFile := writeConfigFile(t, `[
+		{"Kind": "proxy-defaults", "Name": "global", "Config": {"protocol": "http"}},
+		{"Kind": "service-defaults", "Name": "web", "Protocol": "http"}
+	]`)
+	defer os.RemoveAll(filepath.Dir(configFile))
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		consulClient: a.Client(),
+	}
+	responseCode := cmd.Run([]string{
+		"-config-file", configFile,
+		"-default-intention-action", "deny",
+	})
+	require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+	entry, _, err := a.Client().ConfigEntries().Get(api.ProxyDefaults, api.ProxyConfigGlobal, nil)
+	require.NoError(err)
+	require.Equal("http", entry.(*api.ProxyConfigEntry).Config["protocol"])
+	entry, _, err = a.Client().ConfigEntries().Get(api.ServiceDefaults, "web", nil)
+	require.NoError(err)
+	require.Equal("http", entry.(*api.ServiceConfigEntry).Protocol)
+
+	intentions, _, err := a.Client().Connect().Intentions(nil)
+	require.NoError(err)
+	require.Len(intentions, 1)
+	require.Equal(api.IntentionWildcard, intentions[0].SourceName)
+	require.Equal(api.IntentionWildcard, intentions[0].DestinationName)
+	require.Equal(api.IntentionActionDeny, intentions[0].Action)
+}
+
+// Test that existing config entries are only replaced with -overwrite.
+func TestRun_ExistingEntry(t *testing.T) {
+	t.Parallel()
+
+	for _, overwrite := range []bool{false, true} {
+		overwrite := overwrite
+		name := "no overwrite"
+		if overwrite {
+			name = "overwrite"
+		}
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			a := agent.NewTestAgent(t, t.Name(), "")
+			defer a.Shutdown()
+			testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+			_, _, err := a.Client().ConfigEntries().Set(&api.ServiceConfigEntry{
+				Kind:     api.ServiceDefaults,
+				Name:     "web",
+				Protocol: "tcp",
+			}, nil)
+			require.NoError(err)
+
+			configFile := writeConfigFile(t, `[{"Kind": "service-defaults", "Name": "web", "Protocol": "http"}]`)
+			defer os.RemoveAll(filepath.Dir(configFile))
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:           ui,
+				consulClient: a.Client(),
+			}
+			args := []string{"-config-file", configFile}
+			if overwrite {
+				args = append(args, "-overwrite")
+			}
+			responseCode := cmd.Run(args)
+			require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+			entry, _, err := a.Client().ConfigEntries().Get(api.ServiceDefaults, "web", nil)
+			require.NoError(err)
+			expProtocol := "tcp"
+			if overwrite {
+				expProtocol = "http"
+			}
+			require.Equal(expProtocol, entry.(*api.ServiceConfigEntry).Protocol)
+		})
+	}
+}
+
+// writeConfigFile writes the given config entries to a file in a new
+// temporary directory and returns its path.
+func writeConfigFile(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "config-entries.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	return path
+}
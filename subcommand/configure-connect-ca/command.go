@@ -0,0 +1,467 @@
+package configureconnectca
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/tokenstore"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/mitchellh/cli"
+)
+
+// vaultProvider is the name of Consul's Vault Connect CA provider.
+const vaultProvider = "vault"
+
+// Command is the command for configuring Consul's Connect CA provider to
+// use Vault.
+type Command struct {
+	UI cli.Ui
+
+	flags                        *flag.FlagSet
+	http                         *flags.HTTPFlags
+	flagVaultAddress             string
+	flagRootPKIPath              string
+	flagIntermediatePKIPath      string
+	flagVaultCAFile              string
+	flagVaultAppRolePath         string
+	flagVaultAppRoleRoleIDFile   string
+	flagVaultAppRoleSecretIDFile string
+	flagProviderTokenFile        string
+	flagMonitorPeriod            time.Duration
+	flagTimeout                  time.Duration
+	flagLogLevel                 string
+
+	consulClient *api.Client
+	vaultClient  *vaultapi.Client
+	cmdTimeout   context.Context
+
+	// retryDuration is how often we'll retry failed operations.
+	retryDuration time.Duration
+
+	once  sync.Once
+	help  string
+	sigCh chan os.Signal
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagVaultAddress, "vault-address", "",
+		"Address of Vault for the Consul servers to use. Defaults to the address "+
+			"this command uses, which is read from VAULT_ADDR.")
+	c.flags.StringVar(&c.flagRootPKIPath, "root-pki-path", "",
+		"Path of the Vault PKI secrets engine for the root certificate. Must be set.")
+	c.flags.StringVar(&c.flagIntermediatePKIPath, "intermediate-pki-path", "",
+		"Path of the Vault PKI secrets engine for the intermediate certificate. Must be set.")
+	c.flags.StringVar(&c.flagVaultCAFile, "vault-ca-file", "",
+		"Path on the Consul servers of the CA certificate to verify Vault's certificate with.")
+	c.flags.StringVar(&c.flagVaultAppRolePath, "vault-approle-path", "approle",
+		"Path the Vault AppRole auth method is mounted at.")
+	c.flags.StringVar(&c.flagVaultAppRoleRoleIDFile, "vault-approle-role-id-file", "",
+		"Path of a file with the role ID to log in to Vault with, e.g. mounted from a "+
+			"Kubernetes Secret. If this is blank, the Vault token is read from the "+
+			"VAULT_TOKEN environment variable.")
+	c.flags.StringVar(&c.flagVaultAppRoleSecretIDFile, "vault-approle-secret-id-file", "",
+		"Path of a file with the secret ID to log in to Vault with.")
+	c.flags.StringVar(&c.flagProviderTokenFile, "provider-token-file", "",
+		"Path of a file with the Vault token for Consul to use, e.g. mounted from a "+
+			"Kubernetes Secret. Consul keeps using this token, so it must be long-lived or "+
+			"periodic. If this is blank, the token this command uses is given to Consul. "+
+			"With AppRole login, that token expires with the role's TTL, so -monitor-period "+
+			"must then be set to replace it before it expires.")
+	c.flags.DurationVar(&c.flagMonitorPeriod, "monitor-period", 0,
+		"If set, the command keeps running after configuring the provider and checks its "+
+			"health this often: that Consul has an active root and that the provider token is "+
+			"valid for at least another period. The configuration is updated if it changed, "+
+			"e.g. when the token file is updated, and an AppRole login token is replaced before "+
+			"it expires. If zero, the command exits once configured.")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 10*time.Minute,
+		"How long to keep retrying before giving up. Defaults to 10m.")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	c.help = flags.Usage(help, c.flags)
+	c.sigCh = make(chan os.Signal, 1)
+
+	// Default retry to 1s. This is exposed for setting in tests.
+	if c.retryDuration == 0 {
+		c.retryDuration = 1 * time.Second
+	}
+}
+
+// Run configures the Vault Connect CA provider if it isn't already and
+// waits until Consul has an active root from it.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.validateFlags(); err != nil {
+		c.UI.Error("Error: " + err.Error())
+		return 1
+	}
+	logLevel := hclog.LevelFromString(c.flagLogLevel)
+	if logLevel == hclog.NoLevel {
+		c.UI.Error(fmt.Sprintf("Unknown log level: %s", c.flagLogLevel))
+		return 1
+	}
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:  logLevel,
+		Output: os.Stderr,
+	})
+
+	// The clients might already be set if we're in a test.
+	if c.vaultClient == nil {
+		config := vaultapi.DefaultConfig()
+		if config.Error != nil {
+			c.UI.Error(fmt.Sprintf("Error reading Vault configuration: %s", config.Error))
+			return 1
+		}
+		var err error
+		c.vaultClient, err = vaultapi.NewClient(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating Vault client: %s", err))
+			return 1
+		}
+	}
+	if c.flagVaultAppRoleRoleIDFile != "" {
+		if err := c.appRoleLogin(); err != nil {
+			c.UI.Error(fmt.Sprintf("Error logging in to Vault: %s", err))
+			return 1
+		}
+	}
+	if c.flagProviderTokenFile == "" && c.vaultClient.Token() == "" {
+		c.UI.Error("Error: no Vault token; set VAULT_TOKEN, -vault-approle-role-id-file or -provider-token-file")
+		return 1
+	}
+	if c.consulClient == nil {
+		var err error
+		c.consulClient, err = c.http.APIClient()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	var cancel context.CancelFunc
+	c.cmdTimeout, cancel = context.WithTimeout(context.Background(), c.flagTimeout)
+	// The context will only ever be intentionally ended by the timeout.
+	defer cancel()
+
+	var oldRootID string
+	var rotated bool
+	err := c.untilSucceeds("configuring Vault Connect CA provider", func() error {
+		var err error
+		oldRootID, rotated, err = c.configure(logger)
+		return err
+	}, logger)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error configuring Vault Connect CA provider: %s", err))
+		return 1
+	}
+
+	err = c.untilSucceeds("checking Connect CA roots", func() error {
+		return c.checkRoots(oldRootID, rotated)
+	}, logger)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error checking Connect CA roots: %s", err))
+		return 1
+	}
+
+	c.UI.Info("Vault Connect CA provider configured")
+	if c.flagMonitorPeriod <= 0 {
+		return 0
+	}
+
+	// Monitor the provider until interrupted. Failures are only logged
+	// since they're expected to be fixed outside of this command, e.g. by
+	// updating the token's Secret.
+	signal.Notify(c.sigCh, os.Interrupt)
+	for {
+		select {
+		case <-time.After(c.flagMonitorPeriod):
+		case <-c.sigCh:
+			return 0
+		}
+
+		if err := c.checkProvider(logger); err != nil {
+			logger.Error("Vault Connect CA provider is unhealthy", "err", err)
+		}
+	}
+}
+
+// checkProvider updates the provider configuration if it changed, and
+// returns an error if Consul has no active root or if the provider token
+// expires within the monitor period. With AppRole login, the provider
+// token is the login token, which is replaced before it expires.
+func (c *Command) checkProvider(logger hclog.Logger) error {
+	ttl, err := c.providerTokenTTL()
+	if c.appRoleProvidesToken() && (err != nil || (ttl > 0 && ttl < 2*c.flagMonitorPeriod)) {
+		logger.Info("logging in to Vault again to replace the provider token", "token-ttl", ttl)
+		if err := c.appRoleLogin(); err != nil {
+			return fmt.Errorf("error logging in to Vault: %s", err)
+		}
+		ttl, err = c.providerTokenTTL()
+	}
+	if err != nil {
+		return err
+	}
+
+	oldRootID, rotated, err := c.configure(logger)
+	if err != nil {
+		return fmt.Errorf("error configuring: %s", err)
+	}
+	if err := c.checkRoots(oldRootID, rotated); err != nil {
+		return fmt.Errorf("error checking roots: %s", err)
+	}
+
+	// Tokens without a TTL, such as root tokens, don't expire.
+	if ttl > 0 && ttl < c.flagMonitorPeriod {
+		return fmt.Errorf("provider token expires in %s", ttl)
+	}
+	logger.Debug("Vault Connect CA provider is healthy", "token-ttl", ttl)
+	return nil
+}
+
+// providerTokenTTL returns the remaining TTL of the provider token, which
+// is zero if the token doesn't expire.
+func (c *Command) providerTokenTTL() (time.Duration, error) {
+	token, err := c.providerToken()
+	if err != nil {
+		return 0, err
+	}
+	client, err := c.vaultClient.Clone()
+	if err != nil {
+		return 0, err
+	}
+	client.SetToken(token)
+	secret, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		return 0, fmt.Errorf("error looking up provider token: %s", err)
+	}
+	ttl, err := secret.TokenTTL()
+	if err != nil {
+		return 0, fmt.Errorf("error reading TTL of provider token: %s", err)
+	}
+	return ttl, nil
+}
+
+// appRoleProvidesToken returns true if the provider token is the token
+// from the AppRole login.
+func (c *Command) appRoleProvidesToken() bool {
+	return c.flagVaultAppRoleRoleIDFile != "" && c.flagProviderTokenFile == ""
+}
+
+// appRoleLogin logs in to Vault with the AppRole flags.
+func (c *Command) appRoleLogin() error {
+	return tokenstore.VaultAppRoleLogin(c.vaultClient, c.flagVaultAppRolePath,
+		c.flagVaultAppRoleRoleIDFile, c.flagVaultAppRoleSecretIDFile)
+}
+
+// configure sets the Connect CA configuration to the Vault provider if
+// it isn't already. It returns the ID of the active root before the
+// change and whether the change rotates the root.
+//
+// Switching from another provider is handled by Consul: the new root is
+// cross-signed by the old one so that existing certificates stay valid
+// while they're replaced.
+func (c *Command) configure(logger hclog.Logger) (string, bool, error) {
+	connect := c.consulClient.Connect()
+	current, _, err := connect.CAGetConfig(nil)
+	if err != nil {
+		return "", false, err
+	}
+	token, err := c.providerToken()
+	if err != nil {
+		return "", false, err
+	}
+	desired := c.providerConfig(token)
+	if current.Provider == vaultProvider && sameProviderConfig(current.Config, desired) {
+		if fmt.Sprint(current.Config["Token"]) == token {
+			logger.Info("Vault Connect CA provider is already configured")
+			return "", false, nil
+		}
+
+		// Only the token changed, so the root stays the same.
+		logger.Info("updating the token of the Vault Connect CA provider")
+		_, err = connect.CASetConfig(&api.CAConfig{
+			Provider: vaultProvider,
+			Config:   desired,
+		}, nil)
+		return "", false, err
+	}
+
+	roots, _, err := connect.CARoots(nil)
+	if err != nil {
+		return "", false, err
+	}
+
+	logger.Info("setting Connect CA provider", "from", current.Provider, "to", vaultProvider)
+	_, err = connect.CASetConfig(&api.CAConfig{
+		Provider: vaultProvider,
+		Config:   desired,
+	}, nil)
+	if err != nil {
+		return "", false, err
+	}
+	return roots.ActiveRootID, true, nil
+}
+
+// checkRoots returns an error unless Consul has an active root and, if the
+// root was rotated, the active root is no longer the old one.
+func (c *Command) checkRoots(oldRootID string, rotated bool) error {
+	roots, _, err := c.consulClient.Connect().CARoots(nil)
+	if err != nil {
+		return err
+	}
+	if roots.ActiveRootID == "" {
+		return errors.New("no active root")
+	}
+	if rotated && roots.ActiveRootID == oldRootID {
+		return errors.New("active root has not been rotated yet")
+	}
+	for _, root := range roots.Roots {
+		if root.ID == roots.ActiveRootID && root.Active {
+			return nil
+		}
+	}
+	return errors.New("active root is not in the list of roots")
+}
+
+// providerToken returns the Vault token for Consul to use. It's read from
+// -provider-token-file every time so that updates to its Secret are
+// picked up.
+func (c *Command) providerToken() (string, error) {
+	if c.flagProviderTokenFile == "" {
+		return c.vaultClient.Token(), nil
+	}
+	token, err := ioutil.ReadFile(c.flagProviderTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading provider token: %s", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// providerConfig returns the configuration of the Vault provider with the
+// given token.
+func (c *Command) providerConfig(token string) map[string]interface{} {
+	address := c.flagVaultAddress
+	if address == "" {
+		address = c.vaultClient.Address()
+	}
+	config := map[string]interface{}{
+		"Address":             address,
+		"Token":               token,
+		"RootPKIPath":         c.flagRootPKIPath,
+		"IntermediatePKIPath": c.flagIntermediatePKIPath,
+	}
+	if c.flagVaultCAFile != "" {
+		config["CAFile"] = c.flagVaultCAFile
+	}
+	return config
+}
+
+// sameProviderConfig returns true if the current provider configuration
+// has the same address, PKI paths and CA file as the desired one. The
+// token isn't compared, since changing it doesn't rotate the root.
+func sameProviderConfig(current, desired map[string]interface{}) bool {
+	for _, key := range []string{"Address", "RootPKIPath", "IntermediatePKIPath", "CAFile"} {
+		if fmt.Sprint(current[key]) != fmt.Sprint(desired[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// untilSucceeds runs op until it returns nil or the command times out.
+func (c *Command) untilSucceeds(opName string, op func() error, logger hclog.Logger) error {
+	for {
+		err := op()
+		if err == nil {
+			logger.Info(fmt.Sprintf("Success: %s", opName))
+			return nil
+		}
+		logger.Error(fmt.Sprintf("Failure: %s", opName), "err", err)
+		logger.Info("Retrying in " + c.retryDuration.String())
+		// Wait on either the retry duration (in which case we continue) or the
+		// overall command timeout.
+		select {
+		case <-time.After(c.retryDuration):
+			continue
+		case <-c.cmdTimeout.Done():
+			return errors.New("reached command timeout")
+		}
+	}
+}
+
+func (c *Command) validateFlags() error {
+	if len(c.flags.Args()) > 0 {
+		return errors.New("should have no non-flag arguments")
+	}
+	if c.flagRootPKIPath == "" {
+		return errors.New("-root-pki-path must be set")
+	}
+	if c.flagIntermediatePKIPath == "" {
+		return errors.New("-intermediate-pki-path must be set")
+	}
+	if (c.flagVaultAppRoleRoleIDFile == "") != (c.flagVaultAppRoleSecretIDFile == "") {
+		return errors.New("-vault-approle-role-id-file and -vault-approle-secret-id-file must both be set")
+	}
+	if c.flagMonitorPeriod < 0 {
+		return errors.New("-monitor-period must not be negative")
+	}
+	if c.appRoleProvidesToken() && c.flagMonitorPeriod == 0 {
+		return errors.New("-provider-token-file or -monitor-period must be set with AppRole login, " +
+			"since the login token expires with the role's TTL")
+	}
+	if c.flagTimeout <= 0 {
+		return errors.New("-timeout must be greater than 0")
+	}
+	return nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Configure Consul's Connect CA provider to use Vault."
+const help = `
+Usage: consul-k8s configure-connect-ca [options]
+
+  Configures Consul's Connect CA provider to use the Vault PKI secrets
+  engines at -root-pki-path and -intermediate-pki-path, then waits until
+  Consul has an active root from Vault. The Vault address and TLS
+  settings are read from the standard Vault environment variables. This
+  command's own token is read from VAULT_TOKEN or, with
+  -vault-approle-role-id-file, from an AppRole login. The token given to
+  Consul is read from -provider-token-file, and defaults to the command's
+  own token.
+
+  If another provider is configured, e.g. Consul's built-in CA, Consul
+  cross-signs the new root with the old one so that existing certificates
+  stay valid while they're replaced. If the Vault provider is already
+  configured with the same address and paths, only its token is updated
+  if it changed, which doesn't rotate the root.
+
+  With -monitor-period, the command keeps running and checks the
+  provider's health periodically, logging an error if Consul has no
+  active root or the provider token is about to expire. A token from an
+  AppRole login is replaced by logging in again before it expires.
+
+`
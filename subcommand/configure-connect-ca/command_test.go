@@ -0,0 +1,150 @@
+package configureconnectca
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/consul/agent"
+	"github.com/hashicorp/consul/testrpc"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{},
+			ExpErr: "-root-pki-path must be set",
+		},
+		{
+			Flags:  []string{"-root-pki-path", "connect-root"},
+			ExpErr: "-intermediate-pki-path must be set",
+		},
+		{
+			Flags: []string{"-root-pki-path", "connect-root", "-intermediate-pki-path", "connect-intermediate",
+				"-vault-approle-role-id-file", "/vault/role-id"},
+			ExpErr: "-vault-approle-role-id-file and -vault-approle-secret-id-file must both be set",
+		},
+		{
+			Flags: []string{"-root-pki-path", "connect-root", "-intermediate-pki-path", "connect-intermediate",
+				"-vault-approle-role-id-file", "/vault/role-id", "-vault-approle-secret-id-file", "/vault/secret-id"},
+			ExpErr: "-provider-token-file or -monitor-period must be set with AppRole login",
+		},
+		{
+			Flags: []string{"-root-pki-path", "connect-root", "-intermediate-pki-path", "connect-intermediate",
+				"-monitor-period", "-1s"},
+			ExpErr: "-monitor-period must not be negative",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+func TestSameProviderConfig(t *testing.T) {
+	t.Parallel()
+	desired := map[string]interface{}{
+		"Address":             "https://vault:8200",
+		"Token":               "new-token",
+		"RootPKIPath":         "connect-root",
+		"IntermediatePKIPath": "connect-intermediate",
+	}
+	cases := map[string]struct {
+		Current map[string]interface{}
+		Exp     bool
+	}{
+		"same but the token": {
+			Current: map[string]interface{}{
+				"Address":             "https://vault:8200",
+				"Token":               "old-token",
+				"RootPKIPath":         "connect-root",
+				"IntermediatePKIPath": "connect-intermediate",
+			},
+			Exp: true,
+		},
+		"different path": {
+			Current: map[string]interface{}{
+				"Address":             "https://vault:8200",
+				"RootPKIPath":         "pki",
+				"IntermediatePKIPath": "connect-intermediate",
+			},
+			Exp: false,
+		},
+		"built-in CA": {
+			Current: map[string]interface{}{
+				"LeafCertTTL": "72h",
+			},
+			Exp: false,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.Exp, sameProviderConfig(c.Current, desired))
+		})
+	}
+}
+
+func TestCheckRoots(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	a := agent.NewTestAgent(t, t.Name(), `connect { enabled = true }`)
+	defer a.Shutdown()
+	testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+	roots, _, err := a.Client().Connect().CARoots(nil)
+	require.NoError(err)
+
+	cmd := Command{consulClient: a.Client()}
+	require.NoError(cmd.checkRoots("", false))
+	require.EqualError(cmd.checkRoots(roots.ActiveRootID, true), "active root has not been rotated yet")
+	require.NoError(cmd.checkRoots("old-root", true))
+}
+
+// Test that the provider token is read from the token file if it's set,
+// and is the command's own token otherwise.
+func TestProviderToken(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	vaultClient, err := vaultapi.NewClient(vaultapi.DefaultConfig())
+	require.NoError(err)
+	vaultClient.SetToken("login-token")
+	cmd := Command{vaultClient: vaultClient}
+	token, err := cmd.providerToken()
+	require.NoError(err)
+	require.Equal("login-token", token)
+
+	f, err := ioutil.TempFile("", "")
+	require.NoError(err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("provider-token\n")
+	require.NoError(err)
+	require.NoError(f.Close())
+
+	cmd.flagProviderTokenFile = f.Name()
+	token, err = cmd.providerToken()
+	require.NoError(err)
+	require.Equal("provider-token", token)
+
+	// Updates to the file are picked up.
+	require.NoError(ioutil.WriteFile(f.Name(), []byte("rotated-token"), 0600))
+	token, err = cmd.providerToken()
+	require.NoError(err)
+	require.Equal("rotated-token", token)
+}
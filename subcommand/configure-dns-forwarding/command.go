@@ -0,0 +1,436 @@
+package configurednsforwarding
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The DNS providers whose configuration can be managed.
+const (
+	providerCoreDNS = "coredns"
+	providerKubeDNS = "kube-dns"
+)
+
+const (
+	// corefileKey and stubDomainsKey are the keys of the CoreDNS and
+	// kube-dns ConfigMaps that the forwarding is configured in.
+	corefileKey    = "Corefile"
+	stubDomainsKey = "stubDomains"
+
+	// The markers around the server block managed in the Corefile, so that
+	// it can be updated and removed without touching the rest of it.
+	corefileBegin = "# BEGIN consul-k8s dns forwarding"
+	corefileEnd   = "# END consul-k8s dns forwarding"
+
+	// managedStubDomainsAnnotation is the annotation of the kube-dns
+	// ConfigMap listing the comma-separated stubDomains entries managed by
+	// consul-k8s, so that entries added by operators are left alone.
+	managedStubDomainsAnnotation = "consul.hashicorp.com/managed-stub-domains"
+)
+
+// corefileBlock matches the managed server block, including the newline
+// after it.
+var corefileBlock = regexp.MustCompile(
+	`(?s)` + regexp.QuoteMeta(corefileBegin) + `.*?` + regexp.QuoteMeta(corefileEnd) + `\n?`)
+
+// Command is the command for forwarding the Consul DNS domain from the
+// cluster DNS to Consul.
+type Command struct {
+	UI cli.Ui
+
+	flags              *flag.FlagSet
+	k8s                *k8sflags.K8SFlags
+	flagNamespace      string
+	flagDNSServiceName string
+	flagProvider       string
+	flagConfigMapNS    string
+	flagConfigMapName  string
+	flagDomain         string
+	flagSyncPeriod     time.Duration
+	flagRemove         bool
+	flagLogLevel       string
+
+	clientset kubernetes.Interface
+
+	once  sync.Once
+	help  string
+	sigCh chan os.Signal
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagNamespace, "k8s-namespace", "",
+		"Name of Kubernetes namespace of the Consul DNS Service")
+	c.flags.StringVar(&c.flagDNSServiceName, "dns-service-name", "",
+		"Name of the Consul DNS Service that queries are forwarded to")
+	c.flags.StringVar(&c.flagProvider, "dns-provider", providerCoreDNS,
+		"The cluster DNS provider: \"coredns\", whose Corefile gets a server block "+
+			"for the domain, or \"kube-dns\", whose stubDomains get an entry for it.")
+	c.flags.StringVar(&c.flagConfigMapNS, "dns-configmap-namespace", "kube-system",
+		"Namespace of the ConfigMap of the cluster DNS provider")
+	c.flags.StringVar(&c.flagConfigMapName, "dns-configmap-name", "",
+		"Name of the ConfigMap of the cluster DNS provider. Defaults to \"coredns\" "+
+			"or \"kube-dns\" depending on -dns-provider.")
+	c.flags.StringVar(&c.flagDomain, "domain", "consul",
+		"The Consul DNS domain to forward")
+	c.flags.DurationVar(&c.flagSyncPeriod, "sync-period", time.Minute,
+		"How often the forwarding is checked and updated, e.g. when the address "+
+			"of the Consul DNS Service changes. Defaults to 1m.")
+	c.flags.BoolVar(&c.flagRemove, "remove", false,
+		"If true, the forwarding is removed from the DNS configuration and the command "+
+			"exits, e.g. as a pre-delete hook on uninstall.")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.help = flags.Usage(help, c.flags)
+	c.sigCh = make(chan os.Signal, 1)
+}
+
+// Run keeps the forwarding of the Consul domain in the cluster DNS
+// configuration up to date until interrupted, or removes it with -remove.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.validateFlags(); err != nil {
+		c.UI.Error("Error: " + err.Error())
+		return 1
+	}
+	if c.flagConfigMapName == "" {
+		c.flagConfigMapName = c.flagProvider
+	}
+	logLevel := hclog.LevelFromString(c.flagLogLevel)
+	if logLevel == hclog.NoLevel {
+		c.UI.Error(fmt.Sprintf("Unknown log level: %s", c.flagLogLevel))
+		return 1
+	}
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:  logLevel,
+		Output: os.Stderr,
+	})
+
+	// The client might already be set if we're in a test.
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+
+	if c.flagRemove {
+		if err := c.updateConfigMap(logger, ""); err != nil {
+			c.UI.Error(fmt.Sprintf("Error removing DNS forwarding: %s", err))
+			return 1
+		}
+		return 0
+	}
+
+	// Set up channel for graceful SIGINT shutdown.
+	signal.Notify(c.sigCh, os.Interrupt)
+
+	for {
+		if err := c.sync(logger); err != nil {
+			logger.Error("failed to sync DNS forwarding", "err", err)
+		}
+
+		// Re-loop after the sync period or exit if we receive an interrupt.
+		select {
+		case <-time.After(c.flagSyncPeriod):
+			continue
+		case <-c.sigCh:
+			logger.Info("SIGINT received, shutting down")
+			return 0
+		}
+	}
+}
+
+// sync forwards the domain to the current address of the Consul DNS
+// Service.
+func (c *Command) sync(logger hclog.Logger) error {
+	svc, err := c.clientset.CoreV1().Services(c.flagNamespace).Get(c.flagDNSServiceName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting Service %q: %s", c.flagDNSServiceName, err)
+	}
+	addr := svc.Spec.ClusterIP
+	if addr == "" || addr == apiv1.ClusterIPNone {
+		return fmt.Errorf("Service %q has no cluster IP", c.flagDNSServiceName)
+	}
+	return c.updateConfigMap(logger, addr)
+}
+
+// updateConfigMap forwards the domain to addr in the DNS ConfigMap, or
+// removes the forwarding if addr is empty. The ConfigMap is only updated
+// if it changes.
+func (c *Command) updateConfigMap(logger hclog.Logger, addr string) error {
+	configMaps := c.clientset.CoreV1().ConfigMaps(c.flagConfigMapNS)
+	cm, err := configMaps.Get(c.flagConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting ConfigMap %q: %s", c.flagConfigMapName, err)
+	}
+
+	key := corefileKey
+	if c.flagProvider == providerKubeDNS {
+		key = stubDomainsKey
+	}
+	old := cm.Data[key]
+	managed := cm.Annotations[managedStubDomainsAnnotation]
+	var updated string
+	updatedManaged := managed
+	switch c.flagProvider {
+	case providerCoreDNS:
+		updated, err = setCorefileForward(old, c.flagDomain, addr)
+		if err != nil {
+			return fmt.Errorf("error updating %s of ConfigMap %q: %s", key, c.flagConfigMapName, err)
+		}
+	case providerKubeDNS:
+		owned := isManaged(managed, c.flagDomain)
+		updated, err = setStubDomain(old, c.flagDomain, addr, owned)
+		if err != nil {
+			return fmt.Errorf("error updating %s of ConfigMap %q: %s", key, c.flagConfigMapName, err)
+		}
+		// The entry is managed once it's written. An unmanaged entry that
+		// already forwards to addr stays unmanaged.
+		if addr == "" || owned || updated != old {
+			updatedManaged = setManaged(managed, c.flagDomain, addr != "")
+		}
+	}
+	if updated == old && updatedManaged == managed {
+		return nil
+	}
+
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	if updated == "" && key == stubDomainsKey {
+		delete(cm.Data, key)
+	} else {
+		cm.Data[key] = updated
+	}
+	if updatedManaged != managed {
+		if cm.Annotations == nil {
+			cm.Annotations = make(map[string]string)
+		}
+		if updatedManaged == "" {
+			delete(cm.Annotations, managedStubDomainsAnnotation)
+		} else {
+			cm.Annotations[managedStubDomainsAnnotation] = updatedManaged
+		}
+	}
+	if _, err := configMaps.Update(cm); err != nil {
+		return fmt.Errorf("error updating ConfigMap %q: %s", c.flagConfigMapName, err)
+	}
+	if addr == "" {
+		logger.Info("removed DNS forwarding", "domain", c.flagDomain)
+	} else {
+		logger.Info("updated DNS forwarding", "domain", c.flagDomain, "address", addr)
+	}
+	return nil
+}
+
+// setCorefileForward returns the Corefile with the managed server block
+// forwarding the domain to addr, or without the block if addr is empty.
+// It returns an error if another server block already serves the domain,
+// since CoreDNS refuses to load a Corefile with the same zone twice.
+func setCorefileForward(corefile, domain, addr string) (string, error) {
+	corefile = corefileBlock.ReplaceAllString(corefile, "")
+	if addr == "" {
+		return corefile, nil
+	}
+	if corefileServesZone(corefile, domain) {
+		return "", fmt.Errorf("the Corefile already has a server block for %q that isn't managed "+
+			"by consul-k8s; remove it to have the domain forwarded to Consul", domain)
+	}
+	if corefile != "" && !strings.HasSuffix(corefile, "\n") {
+		corefile += "\n"
+	}
+	return corefile + fmt.Sprintf(`%s
+%s:53 {
+    errors
+    cache 30
+    forward . %s
+}
+%s
+`, corefileBegin, domain, addr, corefileEnd), nil
+}
+
+// corefileServesZone returns true if a server block of the Corefile serves
+// the zone on port 53, e.g. "consul", "consul.:53" or "dns://consul:53".
+func corefileServesZone(corefile, zone string) bool {
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	depth := 0
+	for _, line := range strings.Split(corefile, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if depth == 0 {
+			if i := strings.Index(line, "{"); i >= 0 {
+				keys := strings.FieldsFunc(line[:i], func(r rune) bool {
+					return r == ',' || r == ' ' || r == '\t'
+				})
+				for _, key := range keys {
+					key = strings.TrimPrefix(key, "dns://")
+					port := "53"
+					if i := strings.LastIndex(key, ":"); i >= 0 {
+						key, port = key[:i], key[i+1:]
+					}
+					if port == "53" && strings.ToLower(strings.TrimSuffix(key, ".")) == zone {
+						return true
+					}
+				}
+			}
+		}
+		depth += strings.Count(line, "{") - strings.Count(line, "}")
+	}
+	return false
+}
+
+// setStubDomain returns the kube-dns stubDomains JSON with the domain
+// forwarded to addr, or without the domain if addr is empty. managed is
+// whether the entry of the domain is managed by consul-k8s. An entry that
+// isn't is never changed or removed, and it's an error if it doesn't
+// already forward to addr.
+func setStubDomain(stubDomains, domain, addr string, managed bool) (string, error) {
+	domains := make(map[string][]string)
+	if stubDomains != "" {
+		if err := json.Unmarshal([]byte(stubDomains), &domains); err != nil {
+			return "", fmt.Errorf("error parsing stubDomains: %s", err)
+		}
+	}
+
+	current, ok := domains[domain]
+	if addr == "" {
+		if !ok || !managed {
+			return stubDomains, nil
+		}
+		delete(domains, domain)
+	} else {
+		if len(current) == 1 && current[0] == addr {
+			return stubDomains, nil
+		}
+		if ok && !managed {
+			return "", fmt.Errorf("stubDomains already has an entry for %q that isn't managed "+
+				"by consul-k8s; remove it to have the domain forwarded to Consul", domain)
+		}
+		domains[domain] = []string{addr}
+	}
+	if len(domains) == 0 {
+		return "", nil
+	}
+
+	data, err := json.Marshal(domains)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// isManaged returns true if the comma-separated list of managed domains
+// contains the domain.
+func isManaged(managed, domain string) bool {
+	for _, d := range strings.Split(managed, ",") {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// setManaged returns the sorted comma-separated list of managed domains
+// with the domain added, or removed if managed is false.
+func setManaged(list, domain string, managed bool) string {
+	var domains []string
+	for _, d := range strings.Split(list, ",") {
+		if d != "" && d != domain {
+			domains = append(domains, d)
+		}
+	}
+	if managed {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return strings.Join(domains, ",")
+}
+
+func (c *Command) validateFlags() error {
+	if len(c.flags.Args()) > 0 {
+		return errors.New("should have no non-flag arguments")
+	}
+	if c.flagProvider != providerCoreDNS && c.flagProvider != providerKubeDNS {
+		return fmt.Errorf("-dns-provider must be %q or %q", providerCoreDNS, providerKubeDNS)
+	}
+	if c.flagDomain == "" {
+		return errors.New("-domain must be set")
+	}
+	if c.flagRemove {
+		return nil
+	}
+	if c.flagNamespace == "" {
+		return errors.New("-k8s-namespace must be set")
+	}
+	if c.flagDNSServiceName == "" {
+		return errors.New("-dns-service-name must be set")
+	}
+	if c.flagSyncPeriod <= 0 {
+		return errors.New("-sync-period must be greater than 0")
+	}
+	return nil
+}
+
+// interrupt sends os.Interrupt signal to the command
+// so it can exit gracefully. This function is needed for tests
+func (c *Command) interrupt() {
+	c.sigCh <- os.Interrupt
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Forward the Consul DNS domain from the cluster DNS."
+const help = `
+Usage: consul-k8s configure-dns-forwarding [options]
+
+  Configures the cluster DNS to forward queries for the Consul domain to
+  the Consul DNS Service and keeps the forwarding address up to date. For
+  CoreDNS, a server block between marker comments is managed in the
+  Corefile. For kube-dns, an entry is managed in stubDomains and recorded
+  in the consul.hashicorp.com/managed-stub-domains annotation of the
+  ConfigMap. The rest of the configuration is left as it is. If the
+  Corefile already has a server block, or stubDomains an entry, for the
+  domain that isn't managed, the command fails instead of changing it,
+  unless the entry already forwards to the Consul DNS Service. -remove
+  only removes managed entries.
+
+  With -remove, the forwarding is removed and the command exits.
+
+`
@@ -0,0 +1,291 @@
+package configurednsforwarding
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testCorefile = `.:53 {
+    errors
+    kubernetes cluster.local in-addr.arpa ip6.arpa
+    forward . /etc/resolv.conf
+}
+`
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{"-dns-provider", "bind"},
+			ExpErr: `-dns-provider must be "coredns" or "kube-dns"`,
+		},
+		{
+			Flags:  []string{},
+			ExpErr: "-k8s-namespace must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", "default"},
+			ExpErr: "-dns-service-name must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", "default", "-dns-service-name", "consul-dns", "-sync-period", "0s"},
+			ExpErr: "-sync-period must be greater than 0",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+func TestSetCorefileForward(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	block := `# BEGIN consul-k8s dns forwarding
+consul:53 {
+    errors
+    cache 30
+    forward . 10.0.0.10
+}
+# END consul-k8s dns forwarding
+`
+
+	added, err := setCorefileForward(testCorefile, "consul", "10.0.0.10")
+	require.NoError(err)
+	require.Equal(testCorefile+block, added)
+
+	// Setting the same address again doesn't change anything.
+	unchanged, err := setCorefileForward(added, "consul", "10.0.0.10")
+	require.NoError(err)
+	require.Equal(added, unchanged)
+
+	// A new address replaces the old one.
+	updated, err := setCorefileForward(added, "consul", "10.0.0.11")
+	require.NoError(err)
+	require.Contains(updated, "forward . 10.0.0.11")
+	require.NotContains(updated, "10.0.0.10")
+
+	// Removing the block restores the original.
+	removed, err := setCorefileForward(updated, "consul", "")
+	require.NoError(err)
+	require.Equal(testCorefile, removed)
+}
+
+// Test that the block isn't added if an unmanaged server block already
+// serves the domain.
+func TestSetCorefileForward_unmanagedBlock(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		Block  string
+		ExpErr bool
+	}{
+		"domain":               {Block: "consul {", ExpErr: true},
+		"domain and port":      {Block: "consul:53 {", ExpErr: true},
+		"fully qualified":      {Block: "consul.:53 {", ExpErr: true},
+		"with scheme":          {Block: "dns://consul:53 {", ExpErr: true},
+		"one of several zones": {Block: "example.com, consul {", ExpErr: true},
+		"other port":           {Block: "consul:1053 {", ExpErr: false},
+		"other domain":         {Block: "consul.example.com {", ExpErr: false},
+		"commented out":        {Block: "# consul {\nexample.com {", ExpErr: false},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			corefile := testCorefile + c.Block + "\n    forward . 10.0.0.20\n}\n"
+			_, err := setCorefileForward(corefile, "consul", "10.0.0.10")
+			if c.ExpErr {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), `already has a server block for "consul"`)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSetStubDomain(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		StubDomains string
+		Addr        string
+		Managed     bool
+		Exp         string
+		ExpErr      string
+	}{
+		"add to empty": {
+			Addr: "10.0.0.10",
+			Exp:  `{"consul":["10.0.0.10"]}`,
+		},
+		"add to existing": {
+			StubDomains: `{"acme.local":["1.2.3.4"]}`,
+			Addr:        "10.0.0.10",
+			Exp:         `{"acme.local":["1.2.3.4"],"consul":["10.0.0.10"]}`,
+		},
+		"unchanged": {
+			StubDomains: `{"consul": ["10.0.0.10"]}`,
+			Addr:        "10.0.0.10",
+			Managed:     true,
+			Exp:         `{"consul": ["10.0.0.10"]}`,
+		},
+		"update": {
+			StubDomains: `{"consul":["10.0.0.9"]}`,
+			Addr:        "10.0.0.10",
+			Managed:     true,
+			Exp:         `{"consul":["10.0.0.10"]}`,
+		},
+		"unmanaged unchanged": {
+			StubDomains: `{"consul": ["10.0.0.10"]}`,
+			Addr:        "10.0.0.10",
+			Exp:         `{"consul": ["10.0.0.10"]}`,
+		},
+		"unmanaged update": {
+			StubDomains: `{"consul":["10.0.0.9"]}`,
+			Addr:        "10.0.0.10",
+			ExpErr:      `stubDomains already has an entry for "consul" that isn't managed`,
+		},
+		"remove": {
+			StubDomains: `{"acme.local":["1.2.3.4"],"consul":["10.0.0.10"]}`,
+			Managed:     true,
+			Exp:         `{"acme.local":["1.2.3.4"]}`,
+		},
+		"remove last": {
+			StubDomains: `{"consul":["10.0.0.10"]}`,
+			Managed:     true,
+			Exp:         "",
+		},
+		"unmanaged remove": {
+			StubDomains: `{"consul":["10.0.0.10"]}`,
+			Exp:         `{"consul":["10.0.0.10"]}`,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			actual, err := setStubDomain(c.StubDomains, "consul", c.Addr, c.Managed)
+			if c.ExpErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.ExpErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.Exp, actual)
+		})
+	}
+}
+
+// Test that the Corefile forwards to the cluster IP of the DNS Service and
+// that -remove removes it again.
+func TestRun_CoreDNS(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	k8s := fake.NewSimpleClientset(
+		&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-dns", Namespace: "default"},
+			Spec:       apiv1.ServiceSpec{ClusterIP: "10.0.0.10"},
+		},
+		&apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "coredns", Namespace: "kube-system"},
+			Data:       map[string]string{"Corefile": testCorefile},
+		},
+	)
+
+	cmd := Command{UI: cli.NewMockUi(), clientset: k8s}
+	cmd.once.Do(cmd.init)
+	require.NoError(cmd.flags.Parse([]string{
+		"-k8s-namespace", "default",
+		"-dns-service-name", "consul-dns",
+	}))
+	cmd.flagConfigMapName = "coredns"
+	require.NoError(cmd.sync(hclog.NewNullLogger()))
+
+	cm, err := k8s.CoreV1().ConfigMaps("kube-system").Get("coredns", metav1.GetOptions{})
+	require.NoError(err)
+	require.Contains(cm.Data["Corefile"], "forward . 10.0.0.10")
+
+	ui := cli.NewMockUi()
+	removeCmd := Command{UI: ui, clientset: k8s}
+	responseCode := removeCmd.Run([]string{"-remove"})
+	require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+	cm, err = k8s.CoreV1().ConfigMaps("kube-system").Get("coredns", metav1.GetOptions{})
+	require.NoError(err)
+	require.Equal(testCorefile, cm.Data["Corefile"])
+}
+
+// Test that the kube-dns entry is recorded as managed and removed with
+// -remove, and that an operator's entry is neither changed nor removed.
+func TestRun_KubeDNS(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	k8s := fake.NewSimpleClientset(
+		&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-dns", Namespace: "default"},
+			Spec:       apiv1.ServiceSpec{ClusterIP: "10.0.0.10"},
+		},
+		&apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"},
+			Data:       map[string]string{"stubDomains": `{"acme.local":["1.2.3.4"]}`},
+		},
+	)
+	syncArgs := []string{
+		"-k8s-namespace", "default",
+		"-dns-service-name", "consul-dns",
+		"-dns-provider", "kube-dns",
+	}
+	syncDomain := func(domain string) error {
+		cmd := Command{UI: cli.NewMockUi(), clientset: k8s}
+		cmd.once.Do(cmd.init)
+		require.NoError(cmd.flags.Parse(append(syncArgs, "-domain", domain)))
+		cmd.flagConfigMapName = "kube-dns"
+		return cmd.sync(hclog.NewNullLogger())
+	}
+	remove := func(domain string) {
+		ui := cli.NewMockUi()
+		cmd := Command{UI: ui, clientset: k8s}
+		responseCode := cmd.Run([]string{"-dns-provider", "kube-dns", "-domain", domain, "-remove"})
+		require.Equal(0, responseCode, ui.ErrorWriter.String())
+	}
+	configMap := func() *apiv1.ConfigMap {
+		cm, err := k8s.CoreV1().ConfigMaps("kube-system").Get("kube-dns", metav1.GetOptions{})
+		require.NoError(err)
+		return cm
+	}
+
+	require.NoError(syncDomain("consul"))
+	cm := configMap()
+	require.Equal(`{"acme.local":["1.2.3.4"],"consul":["10.0.0.10"]}`, cm.Data["stubDomains"])
+	require.Equal("consul", cm.Annotations[managedStubDomainsAnnotation])
+
+	// The operator's entry isn't overwritten or removed.
+	err := syncDomain("acme.local")
+	require.Error(err)
+	require.Contains(err.Error(), "isn't managed by consul-k8s")
+	remove("acme.local")
+	cm = configMap()
+	require.Contains(cm.Data["stubDomains"], `"acme.local":["1.2.3.4"]`)
+
+	remove("consul")
+	cm = configMap()
+	require.Equal(`{"acme.local":["1.2.3.4"]}`, cm.Data["stubDomains"])
+	require.NotContains(cm.Annotations, managedStubDomainsAnnotation)
+}
@@ -0,0 +1,191 @@
+package drainwatcher
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	connectinject "github.com/hashicorp/consul-k8s/connect-inject"
+	"github.com/hashicorp/consul-k8s/helper/controller"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command is the command for putting the Connect services on cordoned
+// nodes into maintenance mode.
+type Command struct {
+	UI cli.Ui
+
+	flags            *flag.FlagSet
+	http             *flags.HTTPFlags
+	k8s              *k8sflags.K8SFlags
+	flagAgentPort    string
+	flagResyncPeriod time.Duration
+	flagLogLevel     string
+
+	clientset kubernetes.Interface
+
+	once  sync.Once
+	help  string
+	sigCh chan os.Signal
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagAgentPort, "consul-agent-port", "8500",
+		"Port of the Consul client agents on each node, which the services of the "+
+			"pods on that node are registered with. Defaults to 8500.")
+	c.flags.DurationVar(&c.flagResyncPeriod, "resync-period", 30*time.Second,
+		"How often maintenance mode is set again for the services on cordoned nodes, "+
+			"e.g. after they've been re-registered. Defaults to 30s.")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.help = flags.Usage(help, c.flags)
+	c.sigCh = make(chan os.Signal, 1)
+}
+
+// Run watches the nodes until interrupted.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.validateFlags(); err != nil {
+		c.UI.Error("Error: " + err.Error())
+		return 1
+	}
+	logLevel := hclog.LevelFromString(c.flagLogLevel)
+	if logLevel == hclog.NoLevel {
+		c.UI.Error(fmt.Sprintf("Unknown log level: %s", c.flagLogLevel))
+		return 1
+	}
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:  logLevel,
+		Output: os.Stderr,
+	})
+
+	// The client might already be set if we're in a test.
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+
+	ctl := &controller.Controller{
+		Log:          logger.Named("controller"),
+		Name:         "drain-watcher",
+		ResyncPeriod: c.flagResyncPeriod,
+		Resource: &connectinject.NodeDrainResource{
+			Client:       c.clientset,
+			Log:          logger.Named("drain"),
+			ConsulClient: c.agentClient(),
+			ResyncPeriod: c.flagResyncPeriod,
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ctl.Run(ctx.Done())
+	}()
+
+	// Set up channel for graceful SIGINT shutdown.
+	signal.Notify(c.sigCh, os.Interrupt)
+	select {
+	// Unexpected exit
+	case <-doneCh:
+		cancel()
+		return 1
+
+	// Interrupted, gracefully exit
+	case <-c.sigCh:
+		cancel()
+		<-doneCh
+		return 0
+	}
+}
+
+// agentClient returns a function that returns a client of the Consul agent
+// on the given host, configured with the HTTP flags. Clients are reused
+// per host.
+func (c *Command) agentClient() func(hostIP string) (*api.Client, error) {
+	var lock sync.Mutex
+	clients := make(map[string]*api.Client)
+	return func(hostIP string) (*api.Client, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if client, ok := clients[hostIP]; ok {
+			return client, nil
+		}
+
+		config := api.DefaultConfig()
+		c.http.MergeOntoConfig(config)
+		config.Address = net.JoinHostPort(hostIP, c.flagAgentPort)
+		client, err := api.NewClient(config)
+		if err != nil {
+			return nil, fmt.Errorf("error creating client of the Consul agent on %s: %s", hostIP, err)
+		}
+		clients[hostIP] = client
+		return client, nil
+	}
+}
+
+func (c *Command) validateFlags() error {
+	if len(c.flags.Args()) > 0 {
+		return errors.New("should have no non-flag arguments")
+	}
+	if c.flagAgentPort == "" {
+		return errors.New("-consul-agent-port must be set")
+	}
+	if c.flagResyncPeriod <= 0 {
+		return errors.New("-resync-period must be greater than 0")
+	}
+	return nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Put the Connect services on cordoned nodes into maintenance mode."
+const help = `
+Usage: consul-k8s drain-watcher [options]
+
+  Watches the Kubernetes nodes and, when one is cordoned, e.g. before it's
+  drained by the cluster autoscaler, puts the services and sidecar proxies
+  of the injected pods on it into maintenance mode. This removes them from
+  load balancing before the pods are evicted. If the node is uncordoned,
+  they're taken out of maintenance mode again.
+
+  The Consul agent of each node is reached on the node's IP at
+  -consul-agent-port, with the scheme, token and TLS settings of the HTTP
+  flags.
+
+`
@@ -0,0 +1,58 @@
+package drainwatcher
+
+import (
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{"-consul-agent-port", ""},
+			ExpErr: "-consul-agent-port must be set",
+		},
+		{
+			Flags:  []string{"-resync-period", "0s"},
+			ExpErr: "-resync-period must be greater than 0",
+		},
+		{
+			Flags:  []string{"-log-level", "verbose"},
+			ExpErr: "Unknown log level: verbose",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+func TestAgentClient(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	cmd := Command{UI: cli.NewMockUi()}
+	cmd.once.Do(cmd.init)
+	require.NoError(cmd.flags.Parse([]string{"-consul-agent-port", "8501"}))
+
+	clientFor := cmd.agentClient()
+	client, err := clientFor("10.0.0.1")
+	require.NoError(err)
+	again, err := clientFor("10.0.0.1")
+	require.NoError(err)
+	require.True(client == again, "client should be reused for the same host")
+	other, err := clientFor("10.0.0.2")
+	require.NoError(err)
+	require.False(client == other, "client should not be shared between hosts")
+}
@@ -0,0 +1,112 @@
+package flags
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/hashicorp/consul-k8s/helper/tokenstore"
+	vaultapi "github.com/hashicorp/vault/api"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// TokenStoreKubernetes, TokenStoreVault and TokenStoreAWSSecretsManager
+	// are the supported values of the -secrets-backend flag.
+	TokenStoreKubernetes        = "kubernetes"
+	TokenStoreVault             = "vault"
+	TokenStoreAWSSecretsManager = "aws-secrets-manager"
+)
+
+// TokenStoreFlags are the flags for choosing where ACL tokens are stored.
+// The Vault address and TLS settings are read from the standard Vault
+// environment variables, e.g. VAULT_ADDR and VAULT_CACERT. The AWS region
+// and credentials are read from the standard AWS environment and shared
+// configuration.
+type TokenStoreFlags struct {
+	backend       string
+	vaultMount    string
+	vaultPrefix   string
+	vaultAuthPath string
+	vaultRole     string
+	awsPrefix     string
+}
+
+func (f *TokenStoreFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.StringVar(&f.backend, "secrets-backend", TokenStoreKubernetes,
+		"Where ACL tokens are stored. Supported values are \"kubernetes\", which "+
+			"stores them in Kubernetes Secrets, \"vault\", which stores them "+
+			"in a Vault KV version 2 secrets engine, and \"aws-secrets-manager\", "+
+			"which stores them in AWS Secrets Manager.")
+	fs.StringVar(&f.vaultMount, "vault-kv-mount", "secret",
+		"Path the Vault KV version 2 secrets engine is mounted at.")
+	fs.StringVar(&f.vaultPrefix, "vault-path-prefix", "consul",
+		"Path within the Vault secrets engine under which tokens are stored.")
+	fs.StringVar(&f.vaultAuthPath, "vault-k8s-auth-path", "kubernetes",
+		"Path the Vault Kubernetes auth method is mounted at.")
+	fs.StringVar(&f.vaultRole, "vault-k8s-auth-role", "",
+		"Vault role to log in as with the Kubernetes auth method. If this is blank, "+
+			"the Vault token is read from the VAULT_TOKEN environment variable.")
+	fs.StringVar(&f.awsPrefix, "aws-secrets-manager-prefix", "consul/",
+		"Prefix of the names of the AWS Secrets Manager secrets tokens are stored in.")
+	return fs
+}
+
+// Validate returns an error if the flags are invalid.
+func (f *TokenStoreFlags) Validate() error {
+	switch f.backend {
+	case TokenStoreKubernetes, TokenStoreVault, TokenStoreAWSSecretsManager:
+		return nil
+	default:
+		return fmt.Errorf("-secrets-backend must be %q, %q or %q",
+			TokenStoreKubernetes, TokenStoreVault, TokenStoreAWSSecretsManager)
+	}
+}
+
+// Store returns the configured token store. Tokens stored in Kubernetes
+// are stored in the given namespace.
+func (f *TokenStoreFlags) Store(client kubernetes.Interface, namespace string) (tokenstore.Store, error) {
+	switch f.backend {
+	case TokenStoreVault:
+		return f.vaultStore()
+	case TokenStoreAWSSecretsManager:
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, fmt.Errorf("error creating AWS session: %s", err)
+		}
+		return &tokenstore.AWSSecretsManagerStore{
+			Client: secretsmanager.New(sess),
+			Prefix: f.awsPrefix,
+		}, nil
+	default:
+		return &tokenstore.KubernetesStore{Client: client, Namespace: namespace}, nil
+	}
+}
+
+// vaultStore returns a Vault store, logging in to Vault with the
+// Kubernetes auth method if a role is set.
+func (f *TokenStoreFlags) vaultStore() (tokenstore.Store, error) {
+	config := vaultapi.DefaultConfig()
+	if config.Error != nil {
+		return nil, fmt.Errorf("error reading Vault configuration: %s", config.Error)
+	}
+	vaultClient, err := vaultapi.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Vault client: %s", err)
+	}
+	if f.vaultRole != "" {
+		err := tokenstore.VaultKubernetesLogin(vaultClient, f.vaultAuthPath, f.vaultRole,
+			tokenstore.DefaultServiceAccountTokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("error logging in to Vault: %s", err)
+		}
+	}
+
+	return &tokenstore.VaultStore{
+		Client: vaultClient,
+		Mount:  f.vaultMount,
+		Prefix: f.vaultPrefix,
+	}, nil
+}
@@ -3,11 +3,12 @@ package subcommand
 import (
 	"context"
 	"crypto/tls"
-	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -15,10 +16,15 @@ import (
 
 	"github.com/hashicorp/consul-k8s/connect-inject"
 	"github.com/hashicorp/consul-k8s/helper/cert"
+	"github.com/hashicorp/consul-k8s/helper/health"
+	"github.com/hashicorp/consul-k8s/helper/logging"
+	"github.com/hashicorp/consul-k8s/helper/startup"
+	"github.com/hashicorp/consul-k8s/subcommand"
 	"github.com/hashicorp/consul/command/flags"
 	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
-	"k8s.io/apimachinery/pkg/types"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -27,18 +33,37 @@ type Command struct {
 	UI cli.Ui
 
 	flagListen          string
-	flagAutoName        string // MutatingWebhookConfiguration for updating
-	flagAutoHosts       string // SANs for the auto-generated TLS cert.
-	flagCertFile        string // TLS cert for listening (PEM)
-	flagKeyFile         string // TLS cert private key (PEM)
-	flagDefaultInject   bool   // True to inject by default
-	flagConsulImage     string // Docker image for Consul
-	flagEnvoyImage      string // Docker image for Envoy
-	flagConsulK8sImage  string // Docker image for consul-k8s
-	flagACLAuthMethod   string // Auth Method to use for ACLs, if enabled
-	flagCentralConfig   bool   // True to enable central config injection
-	flagDefaultProtocol string // Default protocol for use with central config
-	flagConsulCACert    string // Path to CA Certificate to use when communicating with Consul clients
+	flagAutoName        string        // MutatingWebhookConfigurations for updating
+	flagAutoHosts       string        // SANs for the auto-generated TLS cert.
+	flagCertFile        string        // TLS cert for listening (PEM)
+	flagKeyFile         string        // TLS cert private key (PEM)
+	flagCertTTL         time.Duration // Validity of auto-generated certs
+	flagCertRenewBefore time.Duration // How long before expiry auto-generated certs are renewed
+	flagCAGracePeriod   time.Duration // How long a replaced CA stays in the caBundles
+	flagKeyType         string        // Type of auto-generated keys, ec or rsa
+	flagKeyBits         int           // Size of auto-generated keys
+	flagCACertFile      string        // CA cert and chain for signing auto-generated certs (PEM)
+	flagCAKeyFile       string        // CA private key for signing auto-generated certs (PEM)
+	flagCMIssuer        string        // cert-manager issuer as <kind>/<name>
+	flagCMNamespace     string        // Namespace of the cert-manager Certificate
+	flagCMSecret        string        // Name of the cert-manager Certificate and Secret
+	flagDefaultInject   bool          // True to inject by default
+	flagConsulImage     string        // Docker image for Consul
+	flagEnvoyImage      string        // Docker image for Envoy
+	flagConsulK8sImage  string        // Docker image for consul-k8s
+	flagACLAuthMethod   string        // Auth Method to use for ACLs, if enabled
+	flagLoginAttempts   int           // Max attempts to log in with the Auth Method
+	flagCentralConfig   bool          // True to enable central config injection
+	flagDefaultProtocol string        // Default protocol for use with central config
+	flagConsulCACert    string        // Path to CA Certificate to use when communicating with Consul clients
+	flagDogstatsdURL    string        // Default DogStatsD URL for Envoy stats
+	flagStatsTags       string        // Comma-separated name=value tags for Envoy stats
+	flagLogLevel        string
+	flagLogJSON         bool
+	flagPprofListen     string
+	flagStartupTimeout  time.Duration // How long each startup phase may take
+	flagImageArchs      string        // Comma-separated node architectures the images support
+	flagValidateRes     bool          // True to check injected pods against LimitRanges and ResourceQuotas
 	flagSet             *flag.FlagSet
 
 	once sync.Once
@@ -51,13 +76,41 @@ func (c *Command) init() {
 	c.flagSet.BoolVar(&c.flagDefaultInject, "default-inject", true, "Inject by default.")
 	c.flagSet.StringVar(&c.flagListen, "listen", ":8080", "Address to bind listener to.")
 	c.flagSet.StringVar(&c.flagAutoName, "tls-auto", "",
-		"MutatingWebhookConfiguration name. If specified, will auto generate cert bundle.")
+		"Comma-separated MutatingWebhookConfiguration names. If specified, will auto generate cert bundle "+
+			"and keep the caBundle of the webhooks in these configurations updated.")
 	c.flagSet.StringVar(&c.flagAutoHosts, "tls-auto-hosts", "",
 		"Comma-separated hosts for auto-generated TLS cert. If specified, will auto generate cert bundle.")
 	c.flagSet.StringVar(&c.flagCertFile, "tls-cert-file", "",
 		"PEM-encoded TLS certificate to serve. If blank, will generate random cert.")
 	c.flagSet.StringVar(&c.flagKeyFile, "tls-key-file", "",
 		"PEM-encoded TLS private key to serve. If blank, will generate random cert.")
+	c.flagSet.DurationVar(&c.flagCertTTL, "tls-cert-ttl", 24*time.Hour,
+		"How long the auto-generated TLS cert is valid for. Defaults to 24h.")
+	c.flagSet.DurationVar(&c.flagCertRenewBefore, "tls-cert-renew-before", 0,
+		"How long before it expires the auto-generated TLS cert is renewed. Renewal is "+
+			"staggered across replicas by up to half of this. Defaults to 10% of -tls-cert-ttl.")
+	c.flagSet.DurationVar(&c.flagCAGracePeriod, "tls-auto-ca-grace-period", 5*time.Minute,
+		"How long the caBundles of the -tls-auto webhooks keep a CA cert after it's replaced, "+
+			"so that replicas still serving certs from it keep working. Defaults to 5m.")
+	c.flagSet.StringVar(&c.flagKeyType, "tls-auto-key-type", "ec",
+		"Type of the keys of the auto-generated TLS cert and CA, either \"ec\" or \"rsa\".")
+	c.flagSet.IntVar(&c.flagKeyBits, "tls-auto-key-bits", 0,
+		"Size of the keys of the auto-generated TLS cert and CA: 256 or 384 for EC keys, "+
+			"or a multiple of 1024 of at least 2048 for RSA keys. Defaults to 256 for EC "+
+			"keys and 2048 for RSA keys.")
+	c.flagSet.StringVar(&c.flagCACertFile, "tls-ca-cert-file", "",
+		"PEM-encoded certificate of an existing CA to sign the auto-generated TLS cert with, "+
+			"optionally followed by the rest of its chain. If blank, a self-signed CA is generated.")
+	c.flagSet.StringVar(&c.flagCAKeyFile, "tls-ca-key-file", "",
+		"PEM-encoded private key of the CA in -tls-ca-cert-file.")
+	c.flagSet.StringVar(&c.flagCMIssuer, "cert-manager-issuer", "",
+		"If set, the TLS cert is issued by cert-manager from this issuer, given as "+
+			"Issuer/<name> or ClusterIssuer/<name>. A Certificate for -tls-auto-hosts is "+
+			"created if it doesn't exist and the cert is read from its Secret.")
+	c.flagSet.StringVar(&c.flagCMNamespace, "cert-manager-namespace", "",
+		"Namespace of the cert-manager Certificate and Secret.")
+	c.flagSet.StringVar(&c.flagCMSecret, "cert-manager-secret", "",
+		"Name of the cert-manager Certificate and of the Secret it's issued to.")
 	c.flagSet.StringVar(&c.flagConsulImage, "consul-image", connectinject.DefaultConsulImage,
 		"Docker image for Consul. Defaults to an Consul 1.3.0.")
 	c.flagSet.StringVar(&c.flagEnvoyImage, "envoy-image", connectinject.DefaultEnvoyImage,
@@ -66,12 +119,42 @@ func (c *Command) init() {
 		"Docker image for consul-k8s. Used for the connect sidecar.")
 	c.flagSet.StringVar(&c.flagACLAuthMethod, "acl-auth-method", "",
 		"The name of the Kubernetes Auth Method to use for connectInjection if ACLs are enabled.")
+	c.flagSet.IntVar(&c.flagLoginAttempts, "acl-login-max-attempts", connectinject.DefaultLoginMaxAttempts,
+		"The number of times injected pods try to log in with the Auth Method before failing. "+
+			"Retries use exponential backoff with jitter. Denied logins are not retried.")
 	c.flagSet.BoolVar(&c.flagCentralConfig, "enable-central-config", false,
 		"Write a service-defaults config for every Connect service using protocol from -default-protocol or Pod annotation.")
 	c.flagSet.StringVar(&c.flagDefaultProtocol, "default-protocol", "",
 		"The default protocol to use in central config registrations.")
 	c.flagSet.StringVar(&c.flagConsulCACert, "consul-ca-cert", "",
 		"Path to CA certificate to use if communicating with Consul clients over HTTPS.")
+	c.flagSet.StringVar(&c.flagDogstatsdURL, "envoy-dogstatsd-url", "",
+		"The DogStatsD URL that injected Envoy proxies send their stats to, e.g. udp://${HOST_IP}:8125. "+
+			"${HOST_IP} is the IP of the pod's node. Pods can override this with the "+
+			"consul.hashicorp.com/envoy-dogstatsd-url annotation.")
+	c.flagSet.StringVar(&c.flagStatsTags, "envoy-stats-tags", "",
+		"Comma-separated name=value tags that injected Envoy proxies add to all of their stats.")
+	c.flagSet.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+	c.flagSet.StringVar(&c.flagPprofListen, "pprof-listen", "",
+		"If set, the pprof endpoints are served under /debug/pprof/, and the log level "+
+			"endpoint at /debug/log-level, on this address, which must be on localhost, "+
+			"e.g. 127.0.0.1:6060. If empty, neither is served.")
+	c.flagSet.DurationVar(&c.flagStartupTimeout, "startup-timeout", 5*time.Minute,
+		"How long to wait at startup for the certificate to load and, with -tls-auto, "+
+			"for the webhook configurations to be updated before exiting. Defaults to 5m.")
+	c.flagSet.StringVar(&c.flagImageArchs, "image-architectures", "",
+		"Comma-separated node architectures, e.g. amd64,arm64, that the Consul, Envoy and "+
+			"consul-k8s images are available for. If set, injected pods are restricted to "+
+			"nodes with one of them by node affinity.")
+	c.flagSet.BoolVar(&c.flagValidateRes, "validate-resources", false,
+		"If true, pods are rejected if the injected containers would make them violate a "+
+			"LimitRange or exceed a ResourceQuota of their namespace, with an error naming it. "+
+			"Requires permission to list and watch LimitRanges and ResourceQuotas.")
+	c.flagSet.BoolVar(&c.flagLogJSON, "log-json", false,
+		"Enable or disable JSON output format for logging. The log level can be changed "+
+			"at runtime with PUT /debug/log-level?level=<level> on the -pprof-listen address.")
 	c.help = flags.Usage(help, c.flagSet)
 }
 
@@ -86,6 +169,76 @@ func (c *Command) Run(args []string) int {
 		c.UI.Error("-consul-k8s-image must be set")
 		return 1
 	}
+	if c.flagDogstatsdURL != "" {
+		if err := connectinject.ValidateDogstatsdURL(c.flagDogstatsdURL); err != nil {
+			c.UI.Error("-envoy-dogstatsd-url " + err.Error())
+			return 1
+		}
+	}
+	var statsTags []string
+	for _, tag := range splitNames(c.flagStatsTags) {
+		if err := connectinject.ValidateStatsTag(tag); err != nil {
+			c.UI.Error("-envoy-stats-tags: " + err.Error())
+			return 1
+		}
+		statsTags = append(statsTags, tag)
+	}
+	if c.flagStartupTimeout <= 0 {
+		c.UI.Error("-startup-timeout must be greater than 0")
+		return 1
+	}
+	if c.flagCertTTL <= 0 {
+		c.UI.Error("-tls-cert-ttl must be greater than 0")
+		return 1
+	}
+	if c.flagCertRenewBefore < 0 || c.flagCertRenewBefore >= c.flagCertTTL {
+		c.UI.Error("-tls-cert-renew-before must be at least 0 and less than -tls-cert-ttl")
+		return 1
+	}
+	if err := cert.ValidateKey(c.flagKeyType, c.flagKeyBits); err != nil {
+		c.UI.Error(fmt.Sprintf("-tls-auto-key-type and -tls-auto-key-bits: %s", err))
+		return 1
+	}
+	if (c.flagCACertFile == "") != (c.flagCAKeyFile == "") {
+		c.UI.Error("-tls-ca-cert-file and -tls-ca-key-file must both be set")
+		return 1
+	}
+	var cmIssuerKind, cmIssuerName string
+	if c.flagCMIssuer != "" {
+		parts := strings.SplitN(c.flagCMIssuer, "/", 2)
+		if len(parts) != 2 || (parts[0] != "Issuer" && parts[0] != "ClusterIssuer") || parts[1] == "" {
+			c.UI.Error("-cert-manager-issuer must be Issuer/<name> or ClusterIssuer/<name>")
+			return 1
+		}
+		cmIssuerKind, cmIssuerName = parts[0], parts[1]
+		if c.flagCMNamespace == "" || c.flagCMSecret == "" {
+			c.UI.Error("-cert-manager-namespace and -cert-manager-secret must be set with -cert-manager-issuer")
+			return 1
+		}
+		if c.flagAutoHosts == "" {
+			c.UI.Error("-tls-auto-hosts must be set with -cert-manager-issuer")
+			return 1
+		}
+		if c.flagCertFile != "" {
+			c.UI.Error("-tls-cert-file can't be set with -cert-manager-issuer")
+			return 1
+		}
+	}
+	if c.flagPprofListen != "" {
+		if err := subcommand.ValidatePprofAddr(c.flagPprofListen); err != nil {
+			c.UI.Error(err.Error())
+			return 1
+		}
+	}
+	level := hclog.LevelFromString(c.flagLogLevel)
+	if level == hclog.NoLevel {
+		c.UI.Error(fmt.Sprintf("Unknown log level: %s", c.flagLogLevel))
+		return 1
+	}
+	loggers := &logging.Loggers{
+		Level: level,
+		JSON:  c.flagLogJSON,
+	}
 
 	// We must have an in-cluster K8S client
 	config, err := rest.InClusterConfig()
@@ -100,16 +253,58 @@ func (c *Command) Run(args []string) int {
 	}
 
 	// Determine where to source the certificates from
-	var certSource cert.Source = &cert.GenSource{
-		Name:  "Connect Inject",
-		Hosts: strings.Split(c.flagAutoHosts, ","),
+	// The hostname is the pod name, which staggers renewal across
+	// replicas.
+	hostname, _ := os.Hostname()
+	genSource := &cert.GenSource{
+		Name:         "Connect Inject",
+		Hosts:        strings.Split(c.flagAutoHosts, ","),
+		Expiry:       c.flagCertTTL,
+		ExpiryWithin: c.flagCertRenewBefore,
+		KeyType:      c.flagKeyType,
+		KeyBits:      c.flagKeyBits,
+		StaggerKey:   hostname,
+	}
+	if c.flagCACertFile != "" {
+		if genSource.CACert, err = ioutil.ReadFile(c.flagCACertFile); err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading CA cert file %s: %s", c.flagCACertFile, err))
+			return 1
+		}
+		if genSource.CAKey, err = ioutil.ReadFile(c.flagCAKeyFile); err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading CA key file %s: %s", c.flagCAKeyFile, err))
+			return 1
+		}
 	}
+	var certSource cert.Source = genSource
+	// certSourceName labels the expiry metrics of the certificates.
+	certSourceName := "generated"
 	if c.flagCertFile != "" {
+		certSourceName = c.flagCertFile
 		certSource = &cert.DiskSource{
 			CertPath: c.flagCertFile,
 			KeyPath:  c.flagKeyFile,
 		}
 	}
+	if c.flagCMIssuer != "" {
+		certificate := &cert.CertManagerCertificate{
+			Namespace:  c.flagCMNamespace,
+			Name:       c.flagCMSecret,
+			SecretName: c.flagCMSecret,
+			DNSNames:   splitNames(c.flagAutoHosts),
+			IssuerKind: cmIssuerKind,
+			IssuerName: cmIssuerName,
+		}
+		if err := certificate.Ensure(config); err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating cert-manager Certificate: %s", err))
+			return 1
+		}
+		certSourceName = c.flagCMSecret
+		certSource = &cert.SecretSource{
+			Client:    clientset,
+			Namespace: c.flagCMNamespace,
+			Name:      c.flagCMSecret,
+		}
+	}
 
 	// Create the certificate notifier so we can update for certificates,
 	// then start all the background routines for updating certificates.
@@ -119,7 +314,43 @@ func (c *Command) Run(args []string) int {
 	go certNotify.Start(context.Background())
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
-	go c.certWatcher(ctx, certCh, clientset)
+	// Readiness is made up of a check of each subsystem.
+	checker := &health.Checker{}
+	checker.Add("certificate", c.checkCertificate)
+	var caUpdater *webhookCAUpdater
+	if c.flagAutoName != "" {
+		caUpdater = &webhookCAUpdater{
+			Client:      clientset,
+			Names:       splitNames(c.flagAutoName),
+			UI:          c.UI,
+			GracePeriod: c.flagCAGracePeriod,
+		}
+		checker.Add("webhook-configurations", health.Synced(caUpdater.HasSynced))
+		go caUpdater.Run(ctx)
+	}
+	go c.certWatcher(ctx, certCh, certSourceName, caUpdater)
+
+	// Startup waits for the certificate and then for the webhook
+	// configurations to have its CA, so that a slow start shows up on the
+	// readiness endpoint and a stuck one exits.
+	phases := []startup.Phase{{
+		Name:    "certificate",
+		Timeout: c.flagStartupTimeout,
+		Run: func(ctx context.Context) error {
+			return startup.Poll(ctx, time.Second, c.checkCertificate)
+		},
+	}}
+	if caUpdater != nil {
+		phases = append(phases, startup.Phase{
+			Name:    "webhook-configurations",
+			Timeout: c.flagStartupTimeout,
+			Run: func(ctx context.Context) error {
+				return startup.Poll(ctx, time.Second, health.Synced(caUpdater.HasSynced))
+			},
+		})
+	}
+	start := startup.NewSequence(phases...)
+	checker.Add("startup", start.Check)
 
 	var consulCACert []byte
 	if c.flagConsulCACert != "" {
@@ -138,14 +369,32 @@ func (c *Command) Run(args []string) int {
 		ImageConsulK8S:       c.flagConsulK8sImage,
 		RequireAnnotation:    !c.flagDefaultInject,
 		AuthMethod:           c.flagACLAuthMethod,
+		LoginMaxAttempts:     c.flagLoginAttempts,
 		WriteServiceDefaults: c.flagCentralConfig,
 		DefaultProtocol:      c.flagDefaultProtocol,
 		ConsulCACert:         string(consulCACert),
-		Log:                  hclog.Default().Named("handler"),
+		EnvoyDogstatsdURL:    c.flagDogstatsdURL,
+		EnvoyStatsTags:       statsTags,
+		Log:                  loggers.Named("handler"),
 	}
+	if c.flagValidateRes {
+		// They're looked up on every admission, so they're cached by
+		// informers rather than listed each time.
+		factory := informers.NewSharedInformerFactory(clientset, 0)
+		limitRanges := factory.Core().V1().LimitRanges()
+		quotas := factory.Core().V1().ResourceQuotas()
+		injector.LimitRanges = limitRanges.Lister()
+		injector.ResourceQuotas = quotas.Lister()
+		factory.Start(ctx.Done())
+		checker.Add("resource-informers", health.Synced(func() bool {
+			return limitRanges.Informer().HasSynced() && quotas.Informer().HasSynced()
+		}))
+	}
+	injector.ImageArchitectures = splitNames(c.flagImageArchs)
 	mux := http.NewServeMux()
 	mux.HandleFunc("/mutate", injector.Handle)
-	mux.HandleFunc("/health/ready", c.handleReady)
+	mux.Handle("/health/ready", checker)
+	mux.Handle("/metrics", promhttp.Handler())
 	var handler http.Handler = mux
 	server := &http.Server{
 		Addr:      c.flagListen,
@@ -153,6 +402,22 @@ func (c *Command) Run(args []string) int {
 		TLSConfig: &tls.Config{GetCertificate: c.getCertificate},
 	}
 
+	if c.flagPprofListen != "" {
+		go func() {
+			c.UI.Info(fmt.Sprintf("Serving pprof on %q...", c.flagPprofListen))
+			if err := subcommand.ServePprof(c.flagPprofListen, loggers); err != nil {
+				c.UI.Error(fmt.Sprintf("Error serving pprof: %s", err))
+			}
+		}()
+	}
+
+	go func() {
+		if err := start.Run(ctx); err != nil && ctx.Err() == nil {
+			c.UI.Error(err.Error())
+			server.Close()
+		}
+	}()
+
 	c.UI.Info(fmt.Sprintf("Listening on %q...", c.flagListen))
 	if err := server.ListenAndServeTLS("", ""); err != nil {
 		c.UI.Error(fmt.Sprintf("Error listening: %s", err))
@@ -162,11 +427,14 @@ func (c *Command) Run(args []string) int {
 	return 0
 }
 
-func (c *Command) handleReady(rw http.ResponseWriter, req *http.Request) {
-	// Always ready at this point. The main readiness check is whether
-	// there is a TLS certificate. If we reached this point it means we
-	// served a TLS certificate.
-	rw.WriteHeader(204)
+// checkCertificate checks that a TLS certificate has been loaded. A probe
+// over TLS can't get here without one, but it's still reported so that
+// the verbose output is complete.
+func (c *Command) checkCertificate() error {
+	if c.cert.Load() == nil {
+		return errors.New("no certificate loaded")
+	}
+	return nil
 }
 
 func (c *Command) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
@@ -178,50 +446,33 @@ func (c *Command) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error)
 	return certRaw.(*tls.Certificate), nil
 }
 
-func (c *Command) certWatcher(ctx context.Context, ch <-chan cert.Bundle, clientset *kubernetes.Clientset) {
-	var bundle cert.Bundle
+func (c *Command) certWatcher(ctx context.Context, ch <-chan cert.Bundle, sourceName string, caUpdater *webhookCAUpdater) {
 	for {
+		var bundle cert.Bundle
 		select {
 		case bundle = <-ch:
 			c.UI.Output("Updated certificate bundle received. Updating certs...")
 			// Bundle is updated, set it up
 
-		case <-time.After(1 * time.Second):
-			// This forces the mutating webhook config to remain updated
-			// fairly quickly. This is a jank way to do this and we should
-			// look to improve it in the future. Since we use Patch requests
-			// it is pretty cheap to do, though.
-
 		case <-ctx.Done():
 			// Quit
 			return
 		}
 
+		cert.RecordExpiry("webhook", sourceName, bundle.Cert)
+		cert.RecordExpiry("webhook-ca", sourceName, bundle.CACert)
+
 		cert, err := tls.X509KeyPair(bundle.Cert, bundle.Key)
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error loading TLS keypair: %s", err))
 			continue
 		}
 
-		// If there is a MWC name set, then update the CA bundle.
-		if c.flagAutoName != "" && len(bundle.CACert) > 0 {
-			// The CA Bundle value must be base64 encoded
-			value := base64.StdEncoding.EncodeToString(bundle.CACert)
-
-			_, err := clientset.Admissionregistration().
-				MutatingWebhookConfigurations().
-				Patch(c.flagAutoName, types.JSONPatchType, []byte(fmt.Sprintf(
-					`[{
-						"op": "add",
-						"path": "/webhooks/0/clientConfig/caBundle",
-						"value": %q
-					}]`, value)))
-			if err != nil {
-				c.UI.Error(fmt.Sprintf(
-					"Error updating MutatingWebhookConfiguration: %s",
-					err))
-				continue
-			}
+		// If there are MWC names set, then update the CA bundle. The
+		// updater watches the configurations so it also patches them if
+		// they're changed or recreated later.
+		if caUpdater != nil && len(bundle.CACert) > 0 {
+			caUpdater.SetCA(bundle.CACert)
 		}
 
 		// Update the certificate
@@ -229,6 +480,17 @@ func (c *Command) certWatcher(ctx context.Context, ch <-chan cert.Bundle, client
 	}
 }
 
+// splitNames splits a comma-separated list of names, ignoring empty names.
+func splitNames(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 func (c *Command) Synopsis() string { return synopsis }
 func (c *Command) Help() string {
 	c.once.Do(c.init)
@@ -15,6 +15,55 @@ func TestRun_FlagValidation(t *testing.T) {
 			Flags:  []string{},
 			ExpErr: "-consul-k8s-image must be set",
 		},
+		{
+			Flags:  []string{"-consul-k8s-image", "hashicorp/consul-k8s", "-log-level", "loud"},
+			ExpErr: "Unknown log level: loud",
+		},
+		{
+			Flags:  []string{"-consul-k8s-image", "hashicorp/consul-k8s", "-envoy-dogstatsd-url", "http://localhost:8125"},
+			ExpErr: "-envoy-dogstatsd-url must start with udp:// or unix://",
+		},
+		{
+			Flags:  []string{"-consul-k8s-image", "hashicorp/consul-k8s", "-envoy-stats-tags", "team"},
+			ExpErr: `-envoy-stats-tags: "team" must be in the form name=value`,
+		},
+		{
+			Flags:  []string{"-consul-k8s-image", "hashicorp/consul-k8s", "-tls-cert-ttl", "0s"},
+			ExpErr: "-tls-cert-ttl must be greater than 0",
+		},
+		{
+			Flags:  []string{"-consul-k8s-image", "hashicorp/consul-k8s", "-tls-cert-ttl", "720h", "-tls-cert-renew-before", "720h"},
+			ExpErr: "-tls-cert-renew-before must be at least 0 and less than -tls-cert-ttl",
+		},
+		{
+			Flags:  []string{"-consul-k8s-image", "hashicorp/consul-k8s", "-tls-auto-key-type", "rsa", "-tls-auto-key-bits", "1024"},
+			ExpErr: "-tls-auto-key-type and -tls-auto-key-bits: RSA keys must be a multiple of 1024 bits of at least 2048, got 1024",
+		},
+		{
+			Flags:  []string{"-consul-k8s-image", "hashicorp/consul-k8s", "-tls-ca-cert-file", "ca.pem"},
+			ExpErr: "-tls-ca-cert-file and -tls-ca-key-file must both be set",
+		},
+		{
+			Flags:  []string{"-consul-k8s-image", "hashicorp/consul-k8s", "-cert-manager-issuer", "ca"},
+			ExpErr: "-cert-manager-issuer must be Issuer/<name> or ClusterIssuer/<name>",
+		},
+		{
+			Flags:  []string{"-consul-k8s-image", "hashicorp/consul-k8s", "-cert-manager-issuer", "ClusterIssuer/ca"},
+			ExpErr: "-cert-manager-namespace and -cert-manager-secret must be set with -cert-manager-issuer",
+		},
+		{
+			Flags: []string{"-consul-k8s-image", "hashicorp/consul-k8s", "-cert-manager-issuer", "ClusterIssuer/ca",
+				"-cert-manager-namespace", "consul", "-cert-manager-secret", "injector-cert"},
+			ExpErr: "-tls-auto-hosts must be set with -cert-manager-issuer",
+		},
+		{
+			Flags:  []string{"-consul-k8s-image", "hashicorp/consul-k8s", "-pprof-listen", ":6060"},
+			ExpErr: `pprof address ":6060" must be on localhost or a loopback IP`,
+		},
+		{
+			Flags:  []string{"-consul-k8s-image", "hashicorp/consul-k8s", "-startup-timeout", "0s"},
+			ExpErr: "-startup-timeout must be greater than 0",
+		},
 	}
 
 	for _, c := range cases {
@@ -23,7 +72,7 @@ func TestRun_FlagValidation(t *testing.T) {
 			cmd := Command{
 				UI: ui,
 			}
-			code := cmd.Run([]string{})
+			code := cmd.Run(c.Flags)
 			require.Equal(t, 1, code)
 			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
 		})
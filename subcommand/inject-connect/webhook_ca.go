@@ -0,0 +1,268 @@
+package subcommand
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mitchellh/cli"
+	admissionv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// retiredCAExpiryAnnotation is set on a MutatingWebhookConfiguration while
+// its caBundles still have the CA certificates that were replaced, to the
+// time at which they're removed.
+const retiredCAExpiryAnnotation = "consul.hashicorp.com/retired-ca-expiry"
+
+// webhookCAUpdater keeps the caBundle of every webhook in the named
+// MutatingWebhookConfigurations set to the current CA certificate. Each
+// configuration is watched by name, so one that is changed or recreated is
+// patched right away and no other configurations are ever read.
+type webhookCAUpdater struct {
+	Client kubernetes.Interface
+	Names  []string
+	UI     cli.Ui
+
+	// GracePeriod is how long the CA certificates that are replaced stay
+	// in the caBundles alongside the current one, so that requests to
+	// replicas still serving certs from the old CA don't fail while the
+	// new CA rolls out. If this is zero, they're replaced right away.
+	GracePeriod time.Duration
+
+	lock     sync.Mutex
+	caBundle []byte
+	queue    workqueue.RateLimitingInterface
+	stores   map[string]cache.Store
+	synced   uint32
+}
+
+// SetCA sets the CA certificate and patches every configuration that
+// doesn't have it yet.
+func (u *webhookCAUpdater) SetCA(ca []byte) {
+	u.init()
+	u.lock.Lock()
+	u.caBundle = ca
+	u.lock.Unlock()
+
+	for _, name := range u.Names {
+		u.queue.Add(name)
+	}
+}
+
+// Run starts the watches and patches the configurations until the context
+// is cancelled.
+func (u *webhookCAUpdater) Run(ctx context.Context) {
+	u.init()
+	defer u.queue.ShutDown()
+
+	for _, name := range u.Names {
+		informer := u.informer(name)
+		u.stores[name] = informer.GetStore()
+		go informer.Run(ctx.Done())
+		if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+			return
+		}
+	}
+	atomic.StoreUint32(&u.synced, 1)
+
+	go func() {
+		for u.processNext() {
+		}
+	}()
+	<-ctx.Done()
+}
+
+// HasSynced returns true once the watches of all configurations have
+// synced.
+func (u *webhookCAUpdater) HasSynced() bool {
+	return atomic.LoadUint32(&u.synced) == 1
+}
+
+// init creates the queue so that SetCA can be called before Run.
+func (u *webhookCAUpdater) init() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.queue == nil {
+		u.queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		u.stores = make(map[string]cache.Store, len(u.Names))
+	}
+}
+
+// informer returns an informer for the configuration with the given name.
+func (u *webhookCAUpdater) informer(name string) cache.SharedIndexInformer {
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	informer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = selector
+				return u.Client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = selector
+				return u.Client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Watch(options)
+			},
+		},
+		&admissionv1beta1.MutatingWebhookConfiguration{},
+		0,
+		cache.Indexers{},
+	)
+
+	enqueue := func(obj interface{}) {
+		if cfg, ok := obj.(*admissionv1beta1.MutatingWebhookConfiguration); ok && cfg.Name == name {
+			u.queue.Add(name)
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, newObj interface{}) { enqueue(newObj) },
+	})
+	return informer
+}
+
+// processNext patches the next configuration in the queue. It returns
+// false once the queue is shut down.
+func (u *webhookCAUpdater) processNext() bool {
+	key, quit := u.queue.Get()
+	if quit {
+		return false
+	}
+	defer u.queue.Done(key)
+
+	name := key.(string)
+	if err := u.patch(name); err != nil {
+		u.UI.Error(fmt.Sprintf("Error updating MutatingWebhookConfiguration %q: %s", name, err))
+		u.queue.AddRateLimited(key)
+		return true
+	}
+	u.queue.Forget(key)
+	return true
+}
+
+// patch sets the caBundle of the webhooks of the named configuration that
+// don't have the current CA certificate.
+func (u *webhookCAUpdater) patch(name string) error {
+	u.lock.Lock()
+	ca := u.caBundle
+	u.lock.Unlock()
+	if len(ca) == 0 {
+		return nil
+	}
+
+	obj, exists, err := u.stores[name].GetByKey(name)
+	if err != nil || !exists {
+		// If the configuration doesn't exist, it's patched when it's
+		// created.
+		return err
+	}
+	cfg := obj.(*admissionv1beta1.MutatingWebhookConfiguration)
+
+	// The replaced CAs are kept until the expiry in the annotation, which
+	// is set when they're first kept.
+	now := time.Now()
+	expiry, hasExpiry := now.Add(u.GracePeriod), false
+	if v, ok := cfg.Annotations[retiredCAExpiryAnnotation]; ok {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			expiry, hasExpiry = t, true
+		}
+	}
+	retain := u.GracePeriod > 0 && now.Before(expiry)
+
+	var ops []map[string]interface{}
+	retained := false
+	for i, webhook := range cfg.Webhooks {
+		bundle := ca
+		if retain {
+			bundle = caBundleWithRetired(webhook.ClientConfig.CABundle, ca)
+		}
+		retained = retained || len(bundle) > len(ca)
+		if bytes.Equal(webhook.ClientConfig.CABundle, bundle) {
+			continue
+		}
+		// The []byte value is base64 encoded when marshalled, as the
+		// caBundle must be.
+		ops = append(ops, map[string]interface{}{
+			"op":    "add",
+			"path":  fmt.Sprintf("/webhooks/%d/clientConfig/caBundle", i),
+			"value": bundle,
+		})
+	}
+
+	annotationPath := "/metadata/annotations/" + strings.Replace(retiredCAExpiryAnnotation, "/", "~1", -1)
+	switch {
+	case retained && !hasExpiry:
+		if cfg.Annotations == nil {
+			ops = append(ops, map[string]interface{}{
+				"op":    "add",
+				"path":  "/metadata/annotations",
+				"value": map[string]string{},
+			})
+		}
+		ops = append(ops, map[string]interface{}{
+			"op":    "add",
+			"path":  annotationPath,
+			"value": expiry.UTC().Format(time.RFC3339),
+		})
+	case !retained && hasExpiry:
+		ops = append(ops, map[string]interface{}{
+			"op":   "remove",
+			"path": annotationPath,
+		})
+	}
+
+	// Check again once the retired CAs expire.
+	if retained {
+		u.queue.AddAfter(name, expiry.Sub(now))
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	_, err = u.Client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().
+		Patch(name, types.JSONPatchType, patch)
+	return err
+}
+
+// caBundleWithRetired returns the given CA certificates followed by the
+// certificates in the existing caBundle that aren't among them.
+func caBundleWithRetired(existing, ca []byte) []byte {
+	current := make(map[string]bool)
+	for rest := ca; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		current[string(block.Bytes)] = true
+	}
+
+	bundle := ca
+	for rest := existing; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" || current[string(block.Bytes)] {
+			continue
+		}
+		current[string(block.Bytes)] = true
+		bundle = append(bundle[:len(bundle):len(bundle)], pem.EncodeToMemory(block)...)
+	}
+	return bundle
+}
@@ -0,0 +1,141 @@
+package subcommand
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/cert"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	admissionv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWebhookCAUpdater(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	mwcs := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+
+	_, err := mwcs.Create(testMWC("inject"))
+	require.NoError(t, err)
+	_, err = mwcs.Create(testMWC("other"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updater := &webhookCAUpdater{
+		Client: client,
+		Names:  []string{"inject"},
+		UI:     cli.NewMockUi(),
+	}
+	updater.SetCA([]byte("ca"))
+	go updater.Run(ctx)
+
+	requireCABundle := func(t *testing.T, name, expected string) {
+		retry.Run(t, func(r *retry.R) {
+			cfg, err := mwcs.Get(name, metav1.GetOptions{})
+			require.NoError(r, err)
+			for _, webhook := range cfg.Webhooks {
+				require.Equal(r, expected, string(webhook.ClientConfig.CABundle))
+			}
+		})
+	}
+
+	// The named configuration is patched and the other one isn't.
+	requireCABundle(t, "inject", "ca")
+	requireCABundle(t, "other", "")
+
+	// A recreated configuration is patched.
+	require.NoError(t, mwcs.Delete("inject", nil))
+	_, err = mwcs.Create(testMWC("inject"))
+	require.NoError(t, err)
+	requireCABundle(t, "inject", "ca")
+
+	// A new CA is patched in.
+	updater.SetCA([]byte("new-ca"))
+	requireCABundle(t, "inject", "new-ca")
+}
+
+// Test that a replaced CA stays in the caBundles for the grace period.
+func TestWebhookCAUpdater_gracePeriod(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	mwcs := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+
+	oldCA := testCA(t)
+	newCA := testCA(t)
+	mwc := testMWC("inject")
+	for i := range mwc.Webhooks {
+		mwc.Webhooks[i].ClientConfig.CABundle = oldCA
+	}
+	_, err := mwcs.Create(mwc)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updater := &webhookCAUpdater{
+		Client:      client,
+		Names:       []string{"inject"},
+		UI:          cli.NewMockUi(),
+		GracePeriod: time.Second,
+	}
+	updater.SetCA(newCA)
+	go updater.Run(ctx)
+
+	requireCABundle := func(expected []byte, expiry bool) {
+		retry.Run(t, func(r *retry.R) {
+			cfg, err := mwcs.Get("inject", metav1.GetOptions{})
+			require.NoError(r, err)
+			for _, webhook := range cfg.Webhooks {
+				require.Equal(r, string(expected), string(webhook.ClientConfig.CABundle))
+			}
+			_, ok := cfg.Annotations[retiredCAExpiryAnnotation]
+			require.Equal(r, expiry, ok)
+		})
+	}
+
+	// Both CAs are trusted during the grace period, and only the new one
+	// after it.
+	requireCABundle(append(append([]byte{}, newCA...), oldCA...), true)
+	requireCABundle(newCA, false)
+}
+
+func TestCABundleWithRetired(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	a, b, c := testCA(t), testCA(t), testCA(t)
+	cat := func(cas ...[]byte) []byte {
+		var result []byte
+		for _, ca := range cas {
+			result = append(result, ca...)
+		}
+		return result
+	}
+
+	require.Equal(a, caBundleWithRetired(nil, a))
+	require.Equal(a, caBundleWithRetired(a, a))
+	require.Equal(cat(a, b), caBundleWithRetired(b, a))
+	require.Equal(cat(a, b, c), caBundleWithRetired(cat(a, b, c), a))
+	require.Equal(cat(a, b), caBundleWithRetired(cat(b, b), a))
+}
+
+// testCA returns a new PEM-encoded CA certificate.
+func testCA(t *testing.T) []byte {
+	bundle, err := (&cert.GenSource{Name: "Test"}).Certificate(context.Background(), nil)
+	require.NoError(t, err)
+	return bundle.CACert
+}
+
+func testMWC(name string) *admissionv1beta1.MutatingWebhookConfiguration {
+	return &admissionv1beta1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Webhooks: []admissionv1beta1.Webhook{
+			{Name: "a.consul.hashicorp.com"},
+			{Name: "b.consul.hashicorp.com"},
+		},
+	}
+}
@@ -0,0 +1,43 @@
+package subcommand
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// ValidatePprofAddr returns an error if addr isn't a host:port address on
+// the loopback interface. The profiles expose internals of the process,
+// so they can only be reached from within the pod, e.g. with
+// `kubectl port-forward`.
+func ValidatePprofAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid pprof address %q: %s", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("pprof address %q must be on localhost or a loopback IP", addr)
+	}
+	return nil
+}
+
+// ServePprof serves the pprof endpoints under /debug/pprof/ on the given
+// address. If logLevel is set, it's served at /debug/log-level, since it
+// changes the process without authentication and so mustn't be reachable
+// from outside the pod either. It blocks until the listener fails.
+func ServePprof(addr string, logLevel http.Handler) error {
+	mux := http.NewServeMux()
+	if logLevel != nil {
+		mux.Handle("/debug/log-level", logLevel)
+	}
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return http.ListenAndServe(addr, mux)
+}
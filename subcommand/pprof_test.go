@@ -0,0 +1,45 @@
+package subcommand
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePprofAddr(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		Addr   string
+		ExpErr string
+	}{
+		"localhost":     {Addr: "localhost:6060"},
+		"IPv4 loopback": {Addr: "127.0.0.1:6060"},
+		"IPv6 loopback": {Addr: "[::1]:6060"},
+		"no port": {
+			Addr:   "127.0.0.1",
+			ExpErr: `invalid pprof address "127.0.0.1"`,
+		},
+		"all interfaces": {
+			Addr:   ":6060",
+			ExpErr: `pprof address ":6060" must be on localhost or a loopback IP`,
+		},
+		"pod IP": {
+			Addr:   "10.0.0.1:6060",
+			ExpErr: `pprof address "10.0.0.1:6060" must be on localhost or a loopback IP`,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			err := ValidatePprofAddr(c.Addr)
+			if c.ExpErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			require.Contains(t, err.Error(), c.ExpErr)
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package preupgradecheck
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+)
+
+// The serf statuses of members. Members that have left gracefully stay in
+// the member list until they're reaped, and don't affect the cluster.
+const (
+	memberStatusLeaving = 2
+	memberStatusLeft    = 3
+	memberStatusFailed  = 4
+)
+
+// Command is the command for checking that a Consul cluster is healthy
+// enough to be upgraded.
+type Command struct {
+	UI cli.Ui
+
+	flags               *flag.FlagSet
+	http                *flags.HTTPFlags
+	flagExpectedServers int
+
+	consulClient *api.Client
+
+	once sync.Once
+	help string
+}
+
+// check is a named check of the cluster state.
+type check struct {
+	name string
+	fn   func() error
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.IntVar(&c.flagExpectedServers, "expected-servers", 0,
+		"Number of Consul servers expected to be voters in the Raft configuration. "+
+			"Must be set.")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+// Run runs all the checks and writes a report of them. It exits 1 if any
+// of them failed.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Error: should have no non-flag arguments")
+		return 1
+	}
+	if c.flagExpectedServers <= 0 {
+		c.UI.Error("Error: -expected-servers must be greater than 0")
+		return 1
+	}
+
+	// The client might already be set if we're in a test.
+	if c.consulClient == nil {
+		var err error
+		c.consulClient, err = c.http.APIClient()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	checks := []check{
+		{"serf-members", c.checkMembers},
+		{"raft-peers", c.checkPeers},
+		{"autopilot", c.checkAutopilot},
+	}
+	failed := 0
+	for _, check := range checks {
+		if err := check.fn(); err != nil {
+			failed++
+			c.UI.Error(fmt.Sprintf("[-] %s: %s", check.name, err))
+		} else {
+			c.UI.Info(fmt.Sprintf("[+] %s", check.name))
+		}
+	}
+	if failed > 0 {
+		c.UI.Error(fmt.Sprintf("%d of %d checks failed; the cluster should not be upgraded",
+			failed, len(checks)))
+		return 1
+	}
+	c.UI.Info("All checks passed")
+	return 0
+}
+
+// checkMembers returns an error listing the LAN members that are failed or
+// leaving. Members that have left are only reported.
+func (c *Command) checkMembers() error {
+	members, err := c.consulClient.Agent().Members(false)
+	if err != nil {
+		return fmt.Errorf("error listing members: %s", err)
+	}
+	unhealthy, left := memberStatuses(members)
+	if len(left) > 0 {
+		c.UI.Info(fmt.Sprintf("[i] serf-members: members have left: %s", strings.Join(left, ", ")))
+	}
+	if len(unhealthy) > 0 {
+		return fmt.Errorf("members are failed or leaving: %s", strings.Join(unhealthy, ", "))
+	}
+	return nil
+}
+
+// memberStatuses returns the sorted names of the members that are failed
+// or leaving, and of those that have left.
+func memberStatuses(members []*api.AgentMember) ([]string, []string) {
+	var unhealthy, left []string
+	for _, m := range members {
+		switch m.Status {
+		case memberStatusLeaving, memberStatusFailed:
+			unhealthy = append(unhealthy, m.Name)
+		case memberStatusLeft:
+			left = append(left, m.Name)
+		}
+	}
+	sort.Strings(unhealthy)
+	sort.Strings(left)
+	return unhealthy, left
+}
+
+// checkPeers returns an error if the number of voters in the Raft
+// configuration isn't the expected number of servers, or if there is no
+// leader.
+func (c *Command) checkPeers() error {
+	raft, err := c.consulClient.Operator().RaftGetConfiguration(nil)
+	if err != nil {
+		return fmt.Errorf("error reading Raft configuration: %s", err)
+	}
+	voters := 0
+	hasLeader := false
+	for _, s := range raft.Servers {
+		if s.Voter {
+			voters++
+		}
+		if s.Leader {
+			hasLeader = true
+		}
+	}
+	if !hasLeader {
+		return errors.New("no leader")
+	}
+	if voters != c.flagExpectedServers {
+		return fmt.Errorf("%d voters, expected %d", voters, c.flagExpectedServers)
+	}
+	return nil
+}
+
+// checkAutopilot returns an error listing the servers autopilot considers
+// unhealthy. Upgrading one server at a time is only safe if the cluster
+// can tolerate losing it.
+func (c *Command) checkAutopilot() error {
+	health, err := c.consulClient.Operator().AutopilotServerHealth(nil)
+	if err != nil {
+		return fmt.Errorf("error reading server health: %s", err)
+	}
+	var unhealthy []string
+	for _, s := range health.Servers {
+		if !s.Healthy {
+			unhealthy = append(unhealthy, s.Name)
+		}
+	}
+	if len(unhealthy) > 0 {
+		sort.Strings(unhealthy)
+		return fmt.Errorf("servers are unhealthy: %s", strings.Join(unhealthy, ", "))
+	}
+	if c.flagExpectedServers > 1 && health.FailureTolerance < 1 {
+		return errors.New("cluster cannot tolerate the loss of a server")
+	}
+	return nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Check that a Consul cluster is safe to upgrade."
+const help = `
+Usage: consul-k8s pre-upgrade-check [options]
+
+  Checks that the Consul cluster is healthy enough to be upgraded and
+  writes a report of each check. It's meant to be run as a pre-upgrade
+  hook so that an upgrade fails before any server is restarted. The
+  checks are:
+
+    serf-members  no LAN member is failed or leaving; members that
+                  have left are listed but don't fail the check
+    raft-peers    there is a leader and -expected-servers voters
+    autopilot     all servers are healthy and, with more than one
+                  server, the cluster can tolerate losing one
+
+  Exits 1 if any check fails.
+
+`
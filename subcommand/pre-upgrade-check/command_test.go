@@ -0,0 +1,96 @@
+package preupgradecheck
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/agent"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/consul/testrpc"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{},
+			ExpErr: "-expected-servers must be greater than 0",
+		},
+		{
+			Flags:  []string{"-expected-servers", "3", "extra"},
+			ExpErr: "should have no non-flag arguments",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	a := agent.NewTestAgent(t, t.Name(), "")
+	defer a.Shutdown()
+	testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+	// Autopilot takes a moment to consider the server healthy.
+	retry.Run(t, func(r *retry.R) {
+		ui := cli.NewMockUi()
+		cmd := Command{
+			UI:           ui,
+			consulClient: a.Client(),
+		}
+		responseCode := cmd.Run([]string{"-expected-servers", "1"})
+		require.Equal(r, 0, responseCode, ui.ErrorWriter.String())
+		require.Contains(r, ui.OutputWriter.String(), "All checks passed")
+	})
+}
+
+func TestRun_UnexpectedServers(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	a := agent.NewTestAgent(t, t.Name(), "")
+	defer a.Shutdown()
+	testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		consulClient: a.Client(),
+	}
+	responseCode := cmd.Run([]string{"-expected-servers", "3"})
+	require.Equal(1, responseCode)
+	require.Contains(ui.ErrorWriter.String(), "[-] raft-peers: 1 voters, expected 3")
+}
+
+// Test that only failed and leaving members fail the check.
+func TestMemberStatuses(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	unhealthy, left := memberStatuses([]*api.AgentMember{
+		{Name: "server-0", Status: 1},
+		{Name: "client-b", Status: memberStatusFailed},
+		{Name: "client-a", Status: memberStatusLeaving},
+		{Name: "client-c", Status: memberStatusLeft},
+	})
+	require.Equal([]string{"client-a", "client-b"}, unhealthy)
+	require.Equal([]string{"client-c"}, left)
+
+	unhealthy, left = memberStatuses([]*api.AgentMember{{Name: "server-0", Status: 1}})
+	require.Empty(unhealthy)
+	require.Empty(left)
+}
@@ -0,0 +1,390 @@
+package rotateacltokens
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/audit"
+	"github.com/hashicorp/consul-k8s/helper/tokenstore"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/kubernetes"
+)
+
+// retiredDescriptionSuffix is appended to the description of a replaced
+// token so that server-acl-init, which finds existing tokens by their
+// description, doesn't find it instead of its replacement.
+const retiredDescriptionSuffix = " (retired)"
+
+// retiredSuffix is appended to the name of a token to get the name the
+// token it replaced is recorded under in the token store until it's
+// deleted.
+const retiredSuffix = "-retired"
+
+// retiredToken is the record of a replaced token, which is deleted once
+// Expiry is reached.
+type retiredToken struct {
+	AccessorID string    `json:"accessor_id"`
+	Expiry     time.Time `json:"expiry"`
+}
+
+// Command is the command for rotating the ACL tokens of components.
+type Command struct {
+	UI cli.Ui
+
+	flags              *flag.FlagSet
+	http               *flags.HTTPFlags
+	k8s                *k8sflags.K8SFlags
+	tokenStore         *k8sflags.TokenStoreFlags
+	flagNamespace      string
+	flagResourcePrefix string
+	flagTokenNames     string
+	flagRotationPeriod time.Duration
+	flagGracePeriod    time.Duration
+	flagCheckInterval  time.Duration
+	flagListen         string
+	flagLogLevel       string
+	flagAuditLog       string
+
+	clientset    kubernetes.Interface
+	consulClient *api.Client
+	store        tokenstore.Store
+
+	// audit records the changes made to Consul.
+	audit *audit.Recorder
+
+	tokenAge  *prometheus.GaugeVec
+	rotations *prometheus.CounterVec
+
+	once  sync.Once
+	help  string
+	sigCh chan os.Signal
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagNamespace, "k8s-namespace", "",
+		"Name of Kubernetes namespace where the token Secrets are stored")
+	c.flags.StringVar(&c.flagResourcePrefix, "resource-prefix", "",
+		"Prefix of the tokens stored by server-acl-init, e.g. <release-name>-consul")
+	c.flags.StringVar(&c.flagTokenNames, "token-names", "",
+		"Comma-separated names of the tokens to rotate, e.g. client,catalog-sync. "+
+			"The token of each name is stored as <resource-prefix>-<name>-acl-token.")
+	c.flags.DurationVar(&c.flagRotationPeriod, "rotation-period", 30*24*time.Hour,
+		"How old a token can get before it is rotated. Defaults to 720h.")
+	c.flags.DurationVar(&c.flagGracePeriod, "grace-period", 24*time.Hour,
+		"How long a rotated token stays valid so that components still using it "+
+			"can be restarted with the new token. Defaults to 24h.")
+	c.flags.DurationVar(&c.flagCheckInterval, "check-interval", time.Minute,
+		"How often the tokens are checked. Defaults to 1m.")
+	c.flags.StringVar(&c.flagListen, "listen", ":8080",
+		"Address to bind the metrics listener to.")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+	c.flags.StringVar(&c.flagAuditLog, "audit-log", "",
+		"Where to record the changes made to Consul: \"stdout\" or an http(s) URL that "+
+			"each change is POSTed to as JSON. If empty, changes are not recorded.")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &k8sflags.K8SFlags{}
+	c.tokenStore = &k8sflags.TokenStoreFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, c.tokenStore.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	c.tokenAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "consul_k8s",
+		Subsystem: "acl",
+		Name:      "token_age_seconds",
+		Help:      "Age of each component ACL token.",
+	}, []string{"name"})
+	c.rotations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "consul_k8s",
+		Subsystem: "acl",
+		Name:      "token_rotations_total",
+		Help:      "Number of rotations of each component ACL token.",
+	}, []string{"name"})
+	c.sigCh = make(chan os.Signal, 1)
+}
+
+// Run checks the age of each token on an interval and rotates the ones
+// older than the rotation period. Rotating a token clones it, writes the
+// clone to the token store and deletes the old token after the grace
+// period.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.validateFlags(); err != nil {
+		c.UI.Error("Error: " + err.Error())
+		return 1
+	}
+	logLevel := hclog.LevelFromString(c.flagLogLevel)
+	if logLevel == hclog.NoLevel {
+		c.UI.Error(fmt.Sprintf("Unknown log level: %s", c.flagLogLevel))
+		return 1
+	}
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:  logLevel,
+		Output: os.Stderr,
+	})
+	auditSink, err := audit.NewSink(c.flagAuditLog)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	c.audit = &audit.Recorder{Component: "rotate-acl-tokens", Sink: auditSink}
+
+	// The clients might already be set if we're in a test.
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.store == nil {
+		var err error
+		c.store, err = c.tokenStore.Store(c.clientset, c.flagNamespace)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing token store: %s", err))
+			return 1
+		}
+	}
+	if c.consulClient == nil {
+		var err error
+		c.consulClient, err = c.http.APIClient()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	// Serve the metrics
+	go func() {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(c.tokenAge, c.rotations)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+		c.UI.Info(fmt.Sprintf("Listening on %q...", c.flagListen))
+		if err := http.ListenAndServe(c.flagListen, mux); err != nil {
+			c.UI.Error(fmt.Sprintf("Error listening: %s", err))
+		}
+	}()
+
+	// Set up channel for graceful SIGINT shutdown.
+	signal.Notify(c.sigCh, os.Interrupt)
+
+	names := strings.Split(c.flagTokenNames, ",")
+	for {
+		for _, name := range names {
+			if err := c.rotate(logger, strings.TrimSpace(name), time.Now()); err != nil {
+				logger.Error("failed to check token", "name", name, "err", err)
+			}
+		}
+
+		// Re-loop after the check interval or exit if we receive an interrupt.
+		select {
+		case <-time.After(c.flagCheckInterval):
+			continue
+		case <-c.sigCh:
+			logger.Info("SIGINT received, shutting down")
+			return 0
+		}
+	}
+}
+
+// rotate checks the token with the given name as of now. It deletes the
+// token it previously replaced once its grace period is over, and replaces
+// the current token if it is older than the rotation period.
+//
+// The replaced token is recorded in the token store rather than on the
+// token's Secret, so that rotation works with every secrets backend.
+func (c *Command) rotate(logger hclog.Logger, name string, now time.Time) error {
+	tokenName := fmt.Sprintf("%s-%s-acl-token", c.flagResourcePrefix, name)
+	secretID, err := c.store.Get(tokenName)
+	if err != nil {
+		return fmt.Errorf("getting token %q: %s", tokenName, err)
+	}
+	if secretID == "" {
+		return fmt.Errorf("token %q not found", tokenName)
+	}
+
+	token, _, err := c.consulClient.ACL().TokenReadSelf(&api.QueryOptions{
+		Token: secretID,
+	})
+	if err != nil {
+		return fmt.Errorf("reading token %q: %s", tokenName, err)
+	}
+	age := now.Sub(token.CreateTime)
+	c.tokenAge.WithLabelValues(name).Set(age.Seconds())
+
+	// If a token was replaced, delete it once its grace period is over. We
+	// don't rotate again until then so that only one old token is in use.
+	retiredName := tokenName + retiredSuffix
+	raw, err := c.store.Get(retiredName)
+	if err != nil {
+		return fmt.Errorf("getting retired token record %q: %s", retiredName, err)
+	}
+	if raw != "" {
+		var retired retiredToken
+		if err := json.Unmarshal([]byte(raw), &retired); err != nil {
+			return fmt.Errorf("parsing retired token record %q: %s", retiredName, err)
+		}
+		if now.Before(retired.Expiry) {
+			return nil
+		}
+
+		logger.Info("deleting retired token", "name", name, "accessor-id", retired.AccessorID)
+		_, err = c.consulClient.ACL().TokenDelete(retired.AccessorID, nil)
+		if isTokenNotFoundErr(err) {
+			err = nil
+		} else {
+			c.recordAudit(logger, "acl-token-delete", retired.AccessorID, tokenName, err)
+		}
+		if err != nil {
+			return fmt.Errorf("deleting retired token %q: %s", retired.AccessorID, err)
+		}
+		if err := c.store.Put(retiredName, ""); err != nil {
+			return fmt.Errorf("clearing retired token record %q: %s", retiredName, err)
+		}
+
+		// The current token is checked for rotation on the next pass.
+		return nil
+	}
+
+	if age < c.flagRotationPeriod {
+		return nil
+	}
+
+	// The clone has the same policies, roles and service identities.
+	logger.Info("rotating token", "name", name, "age", age)
+	newToken, _, err := c.consulClient.ACL().TokenClone(token.AccessorID, token.Description, nil)
+	c.recordAudit(logger, "acl-token-clone", token.AccessorID, tokenName, err)
+	if err != nil {
+		return fmt.Errorf("cloning token: %s", err)
+	}
+	if err := c.store.Put(tokenName, newToken.SecretID); err != nil {
+		// Don't leave the unused clone behind.
+		_, delErr := c.consulClient.ACL().TokenDelete(newToken.AccessorID, nil)
+		c.recordAudit(logger, "acl-token-delete", newToken.AccessorID, tokenName, delErr)
+		if delErr != nil {
+			logger.Warn("failed to delete unused token", "accessor-id", newToken.AccessorID, "err", delErr)
+		}
+		return fmt.Errorf("storing token %q: %s", tokenName, err)
+	}
+	c.rotations.WithLabelValues(name).Inc()
+	c.tokenAge.WithLabelValues(name).Set(0)
+	logger.Info("rotated token", "name", name, "accessor-id", newToken.AccessorID)
+
+	// The clone has the same description, so mark the old token as retired.
+	token.Description += retiredDescriptionSuffix
+	_, _, err = c.consulClient.ACL().TokenUpdate(token, nil)
+	c.recordAudit(logger, "acl-token-update", token.AccessorID, tokenName, err)
+	if err != nil {
+		logger.Warn("failed to mark the replaced token as retired",
+			"accessor-id", token.AccessorID, "err", err)
+	}
+
+	// The record is written after the new token so that the current token
+	// is never recorded as retired.
+	record, err := json.Marshal(retiredToken{
+		AccessorID: token.AccessorID,
+		Expiry:     now.Add(c.flagGracePeriod),
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.store.Put(retiredName, string(record)); err != nil {
+		return fmt.Errorf("recording retired token %q, which must be deleted manually: %s", token.AccessorID, err)
+	}
+	return nil
+}
+
+// recordAudit records a change made to Consul in the audit log. source is
+// the name of the token in the token store that the change was made for.
+func (c *Command) recordAudit(logger hclog.Logger, operation, target, source string, err error) {
+	if auditErr := c.audit.Record(operation, target, source, err); auditErr != nil {
+		logger.Warn("failed to record audit event",
+			"operation", operation, "target", target, "err", auditErr)
+	}
+}
+
+func (c *Command) validateFlags() error {
+	if len(c.flags.Args()) > 0 {
+		return errors.New("should have no non-flag arguments")
+	}
+	if c.flagNamespace == "" {
+		return errors.New("-k8s-namespace must be set")
+	}
+	if c.flagResourcePrefix == "" {
+		return errors.New("-resource-prefix must be set")
+	}
+	if c.flagTokenNames == "" {
+		return errors.New("-token-names must be set")
+	}
+	if err := c.tokenStore.Validate(); err != nil {
+		return err
+	}
+	if c.flagRotationPeriod <= 0 {
+		return errors.New("-rotation-period must be greater than 0")
+	}
+	if c.flagCheckInterval <= 0 {
+		return errors.New("-check-interval must be greater than 0")
+	}
+	return nil
+}
+
+// isTokenNotFoundErr returns true if err is due to the token not existing.
+func isTokenNotFoundErr(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "ACL not found") ||
+		strings.Contains(err.Error(), "Unexpected response code: 404"))
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Rotate component ACL tokens."
+const help = `
+Usage: consul-k8s rotate-acl-tokens [options]
+
+  Periodically rotates the ACL tokens that server-acl-init stored in the
+  token store selected by -secrets-backend. A token older than the
+  rotation period is cloned and the clone is stored in its place. The old
+  token's description is suffixed with "(retired)", it is recorded as
+  <name>-retired in the token store and deleted once the grace period is
+  over, so components using it must be restarted within the grace period.
+  The Consul token used by this command must have acl:write.
+
+  Only the tokens in the token store are rotated. Tokens that pods get by
+  logging in with an auth method are short-lived and are destroyed when
+  the pod logs out, so they are not rotated.
+
+`
@@ -0,0 +1,173 @@
+package rotateacltokens
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/audit"
+	"github.com/hashicorp/consul-k8s/helper/tokenstore"
+	"github.com/hashicorp/consul/agent"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/testrpc"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	ns             = "default"
+	resourcePrefix = "release-name-consul"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{},
+			ExpErr: "-k8s-namespace must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns},
+			ExpErr: "-resource-prefix must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns, "-resource-prefix", resourcePrefix},
+			ExpErr: "-token-names must be set",
+		},
+		{
+			Flags: []string{"-k8s-namespace", ns, "-resource-prefix", resourcePrefix,
+				"-token-names", "client", "-secrets-backend", "etcd"},
+			ExpErr: "-secrets-backend must be",
+		},
+		{
+			Flags: []string{"-k8s-namespace", ns, "-resource-prefix", resourcePrefix,
+				"-token-names", "client", "-rotation-period", "0s"},
+			ExpErr: "-rotation-period must be greater than 0",
+		},
+		{
+			Flags: []string{"-k8s-namespace", ns, "-resource-prefix", resourcePrefix,
+				"-token-names", "client", "-audit-log", "/var/log/audit"},
+			ExpErr: "audit log destination must be \"stdout\" or an http(s) URL",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+// recordingSink records the audit events in memory.
+type recordingSink struct {
+	ops []string
+}
+
+func (s *recordingSink) Record(e audit.Event) error {
+	s.ops = append(s.ops, e.Operation+" "+e.Target)
+	return nil
+}
+
+// Test that an old token is replaced and deleted after the grace period,
+// and that the changes are recorded in the audit log.
+func TestRotate(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	a := agent.NewTestAgent(t, t.Name(), `
+	primary_datacenter = "dc1"
+	acl {
+		enabled = true
+	}`)
+	defer a.Shutdown()
+	testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+	bootstrap, _, err := a.Client().ACL().Bootstrap()
+	require.NoError(err)
+	consulClient, err := api.NewClient(&api.Config{
+		Address: a.HTTPAddr(),
+		Token:   bootstrap.SecretID,
+	})
+	require.NoError(err)
+
+	// Create the token and its Secret.
+	token, _, err := consulClient.ACL().TokenCreate(&api.ACLToken{
+		Description: "client Token",
+	}, nil)
+	require.NoError(err)
+	k8s := fake.NewSimpleClientset()
+	secretName := resourcePrefix + "-client-acl-token"
+	_, err = k8s.CoreV1().Secrets(ns).Create(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName},
+		Data:       map[string][]byte{"token": []byte(token.SecretID)},
+	})
+	require.NoError(err)
+
+	store := &tokenstore.KubernetesStore{Client: k8s, Namespace: ns}
+	cmd := Command{
+		UI:           cli.NewMockUi(),
+		clientset:    k8s,
+		consulClient: consulClient,
+		store:        store,
+	}
+	cmd.once.Do(cmd.init)
+	sink := &recordingSink{}
+	cmd.audit = &audit.Recorder{Component: "rotate-acl-tokens", Sink: sink}
+	cmd.flagNamespace = ns
+	cmd.flagResourcePrefix = resourcePrefix
+	cmd.flagRotationPeriod = time.Hour
+	cmd.flagGracePeriod = time.Hour
+
+	// The token is too new to be rotated.
+	require.NoError(cmd.rotate(hclog.Default(), "client", time.Now()))
+	secret, err := k8s.CoreV1().Secrets(ns).Get(secretName, metav1.GetOptions{})
+	require.NoError(err)
+	require.Equal(token.SecretID, string(secret.Data["token"]))
+
+	// The token is rotated once it is old enough.
+	now := time.Now().Add(2 * time.Hour)
+	require.NoError(cmd.rotate(hclog.Default(), "client", now))
+	secret, err = k8s.CoreV1().Secrets(ns).Get(secretName, metav1.GetOptions{})
+	require.NoError(err)
+	newSecretID := string(secret.Data["token"])
+	require.NotEqual(token.SecretID, newSecretID)
+	retired, err := store.Get(secretName + retiredSuffix)
+	require.NoError(err)
+	require.Contains(retired, token.AccessorID)
+
+	// The old token is still valid during the grace period, but no longer
+	// has the description of the current token.
+	oldToken, _, err := consulClient.ACL().TokenRead(token.AccessorID, nil)
+	require.NoError(err)
+	require.Equal("client Token"+retiredDescriptionSuffix, oldToken.Description)
+	newToken, _, err := consulClient.ACL().TokenReadSelf(&api.QueryOptions{Token: newSecretID})
+	require.NoError(err)
+	require.Equal("client Token", newToken.Description)
+
+	// The old token is deleted after the grace period.
+	require.NoError(cmd.rotate(hclog.Default(), "client", now.Add(2*time.Hour)))
+	_, _, err = consulClient.ACL().TokenRead(token.AccessorID, nil)
+	require.Error(err)
+	secret, err = k8s.CoreV1().Secrets(ns).Get(secretName, metav1.GetOptions{})
+	require.NoError(err)
+	require.Equal(newSecretID, string(secret.Data["token"]))
+	retired, err = store.Get(secretName + retiredSuffix)
+	require.NoError(err)
+	require.Empty(retired)
+
+	require.Equal([]string{
+		"acl-token-clone " + token.AccessorID,
+		"acl-token-update " + token.AccessorID,
+		"acl-token-delete " + token.AccessorID,
+	}, sink.ops)
+}
@@ -0,0 +1,387 @@
+package rotategossipkey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// rotatedAtAnnotation is set on the gossip key Secret to the time of
+	// the last successful rotation.
+	rotatedAtAnnotation = "consul.hashicorp.com/gossip-key-rotated-at"
+
+	// keyIDAnnotation is set on the gossip key Secret to the ID of the
+	// key, so that the active key can be identified without reading it.
+	keyIDAnnotation = "consul.hashicorp.com/gossip-key-id"
+)
+
+// Command is the command for rotating the gossip encryption key.
+type Command struct {
+	UI cli.Ui
+
+	flags          *flag.FlagSet
+	http           *flags.HTTPFlags
+	k8s            *k8sflags.K8SFlags
+	flagNamespace  string
+	flagSecretName string
+	flagSecretKey  string
+	flagLogLevel   string
+
+	flagFederationSecretName string
+	flagFederationSecretKey  string
+	flagRotationPeriod       time.Duration
+	flagCheckInterval        time.Duration
+	flagListen               string
+
+	clientset    kubernetes.Interface
+	consulClient *api.Client
+
+	lastRotation *prometheus.GaugeVec
+	rotations    *prometheus.CounterVec
+
+	once  sync.Once
+	help  string
+	sigCh chan os.Signal
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagNamespace, "k8s-namespace", "",
+		"Name of Kubernetes namespace where the gossip key Secret is stored")
+	c.flags.StringVar(&c.flagSecretName, "secret-name", "",
+		"Name of the Kubernetes Secret the gossip key is stored in")
+	c.flags.StringVar(&c.flagSecretKey, "secret-key", "key",
+		"Key of the gossip key within the Kubernetes Secret")
+	c.flags.StringVar(&c.flagFederationSecretName, "federation-secret-name", "",
+		"Name of the Kubernetes federation Secret to also update with the new gossip key. "+
+			"If empty, no federation Secret is updated.")
+	c.flags.StringVar(&c.flagFederationSecretKey, "federation-secret-key", "gossipEncryptionKey",
+		"Key of the gossip key within the Kubernetes federation Secret")
+	c.flags.DurationVar(&c.flagRotationPeriod, "rotation-period", 0,
+		"If set, the command keeps running and rotates the gossip key whenever the last "+
+			"rotation is older than this. Defaults to 0, which rotates the key once and exits.")
+	c.flags.DurationVar(&c.flagCheckInterval, "check-interval", time.Minute,
+		"How often the age of the gossip key is checked with -rotation-period. Defaults to 1m.")
+	c.flags.StringVar(&c.flagListen, "listen", ":8080",
+		"Address to bind the metrics listener to with -rotation-period.")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	c.lastRotation = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "consul_k8s",
+		Subsystem: "gossip",
+		Name:      "key_last_rotation_timestamp_seconds",
+		Help:      "Unix time of the last rotation of the gossip key, labeled with the ID of the active key.",
+	}, []string{"key_id"})
+	c.rotations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "consul_k8s",
+		Subsystem: "gossip",
+		Name:      "key_rotations_total",
+		Help:      "Number of rotations of the gossip key by result.",
+	}, []string{"result"})
+	c.sigCh = make(chan os.Signal, 1)
+}
+
+// Run rotates the gossip key once or, with -rotation-period, whenever it's
+// due until interrupted.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.validateFlags(); err != nil {
+		c.UI.Error("Error: " + err.Error())
+		return 1
+	}
+	logLevel := hclog.LevelFromString(c.flagLogLevel)
+	if logLevel == hclog.NoLevel {
+		c.UI.Error(fmt.Sprintf("Unknown log level: %s", c.flagLogLevel))
+		return 1
+	}
+	logger := hclog.New(&hclog.LoggerOptions{
+		Level:  logLevel,
+		Output: os.Stderr,
+	})
+
+	// The clients might already be set if we're in a test.
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.consulClient == nil {
+		var err error
+		c.consulClient, err = c.http.APIClient()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	if c.flagRotationPeriod == 0 {
+		if err := c.rotate(logger); err != nil {
+			c.UI.Error(err.Error())
+			return 1
+		}
+		return 0
+	}
+
+	// Serve the metrics
+	go func() {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(c.lastRotation, c.rotations)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+		c.UI.Info(fmt.Sprintf("Listening on %q...", c.flagListen))
+		if err := http.ListenAndServe(c.flagListen, mux); err != nil {
+			c.UI.Error(fmt.Sprintf("Error listening: %s", err))
+		}
+	}()
+
+	// Set up channel for graceful SIGINT shutdown.
+	signal.Notify(c.sigCh, os.Interrupt)
+
+	for {
+		if err := c.rotateIfDue(logger, time.Now()); err != nil {
+			logger.Error("failed to rotate gossip key", "err", err)
+		}
+
+		// Re-loop after the check interval or exit if we receive an interrupt.
+		select {
+		case <-time.After(c.flagCheckInterval):
+			continue
+		case <-c.sigCh:
+			logger.Info("SIGINT received, shutting down")
+			return 0
+		}
+	}
+}
+
+// rotateIfDue rotates the gossip key if the last rotation is older than
+// the rotation period as of now, and records the active key otherwise.
+func (c *Command) rotateIfDue(logger hclog.Logger, now time.Time) error {
+	secret, err := c.clientset.CoreV1().Secrets(c.flagNamespace).Get(c.flagSecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("Error getting Secret %q: %s", c.flagSecretName, err)
+	}
+
+	rotatedAt, err := time.Parse(time.RFC3339, secret.Annotations[rotatedAtAnnotation])
+	if err == nil && now.Sub(rotatedAt) < c.flagRotationPeriod {
+		c.lastRotation.Reset()
+		c.lastRotation.WithLabelValues(keyID(string(secret.Data[c.flagSecretKey]))).
+			Set(float64(rotatedAt.Unix()))
+		return nil
+	}
+
+	err = c.rotate(logger)
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	c.rotations.WithLabelValues(result).Inc()
+	return err
+}
+
+// rotate rotates the gossip key. The new key is installed on and made
+// primary for all agents before it's written to the Secrets, and the old
+// key is only removed once the Secrets have been updated.
+func (c *Command) rotate(logger hclog.Logger) error {
+	secret, err := c.clientset.CoreV1().Secrets(c.flagNamespace).Get(c.flagSecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("Error getting Secret %q: %s", c.flagSecretName, err)
+	}
+	oldKey := string(secret.Data[c.flagSecretKey])
+	if oldKey == "" {
+		return fmt.Errorf("Secret %q does not have data key %q", c.flagSecretName, c.flagSecretKey)
+	}
+
+	newKey, err := generateKey()
+	if err != nil {
+		return fmt.Errorf("Error generating gossip key: %s", err)
+	}
+
+	operator := c.consulClient.Operator()
+	logger.Info("installing new gossip key", "key-id", keyID(newKey))
+	if err := operator.KeyringInstall(newKey, nil); err != nil {
+		c.rollback(logger, oldKey, newKey, false)
+		return fmt.Errorf("Error installing new gossip key: %s", err)
+	}
+	logger.Info("making new gossip key primary", "key-id", keyID(newKey))
+	if err := operator.KeyringUse(newKey, nil); err != nil {
+		c.rollback(logger, oldKey, newKey, true)
+		return fmt.Errorf("Error making new gossip key primary: %s", err)
+	}
+
+	// Agents that restart or join from now on must use the new key. The
+	// federation Secret is updated first so that, if the gossip key
+	// Secret can't be updated, it can be put back with the rollback.
+	if c.flagFederationSecretName != "" {
+		if err := c.updateFederationSecret(newKey); err != nil {
+			c.rollback(logger, oldKey, newKey, true)
+			return err
+		}
+	}
+	rotatedAt := time.Now().UTC()
+	secret.Data[c.flagSecretKey] = []byte(newKey)
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[rotatedAtAnnotation] = rotatedAt.Format(time.RFC3339)
+	secret.Annotations[keyIDAnnotation] = keyID(newKey)
+	if _, err := c.clientset.CoreV1().Secrets(c.flagNamespace).Update(secret); err != nil {
+		c.rollback(logger, oldKey, newKey, true)
+		if c.flagFederationSecretName != "" {
+			if fedErr := c.updateFederationSecret(oldKey); fedErr != nil {
+				logger.Error("failed to restore old gossip key in federation Secret; it must be "+
+					"restored manually", "err", fedErr)
+			}
+		}
+		return fmt.Errorf("Error updating Secret %q: %s", c.flagSecretName, err)
+	}
+
+	logger.Info("removing old gossip key", "key-id", keyID(oldKey))
+	if err := operator.KeyringRemove(oldKey, nil); err != nil {
+		return fmt.Errorf("Error removing old gossip key: %s", err)
+	}
+
+	c.lastRotation.Reset()
+	c.lastRotation.WithLabelValues(keyID(newKey)).Set(float64(rotatedAt.Unix()))
+	logger.Info("rotated gossip key successfully", "key-id", keyID(newKey))
+	return nil
+}
+
+// updateFederationSecret sets the gossip key in the federation Secret.
+func (c *Command) updateFederationSecret(key string) error {
+	secrets := c.clientset.CoreV1().Secrets(c.flagNamespace)
+	secret, err := secrets.Get(c.flagFederationSecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("Error getting federation Secret %q: %s", c.flagFederationSecretName, err)
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[c.flagFederationSecretKey] = []byte(key)
+	if _, err := secrets.Update(secret); err != nil {
+		return fmt.Errorf("Error updating federation Secret %q: %s", c.flagFederationSecretName, err)
+	}
+	return nil
+}
+
+// rollback undoes a partially completed rotation so that the agents only
+// have the old key, which is still the key in the Secret. If primary is
+// true, the new key may have been made primary on some agents. Any failure
+// is logged since the rotation has already failed.
+func (c *Command) rollback(logger hclog.Logger, oldKey, newKey string, primary bool) {
+	operator := c.consulClient.Operator()
+	logger.Warn("rolling back gossip key rotation")
+	if primary {
+		if err := operator.KeyringUse(oldKey, nil); err != nil {
+			logger.Error("failed to make old gossip key primary again; the new key must be "+
+				"removed manually with `consul keyring`", "err", err)
+			return
+		}
+	}
+	if err := operator.KeyringRemove(newKey, nil); err != nil {
+		logger.Error("failed to remove new gossip key; it must be removed manually "+
+			"with `consul keyring`", "err", err)
+		return
+	}
+	logger.Info("rolled back gossip key rotation")
+}
+
+func (c *Command) validateFlags() error {
+	if len(c.flags.Args()) > 0 {
+		return errors.New("should have no non-flag arguments")
+	}
+	if c.flagNamespace == "" {
+		return errors.New("-k8s-namespace must be set")
+	}
+	if c.flagSecretName == "" {
+		return errors.New("-secret-name must be set")
+	}
+	if c.flagRotationPeriod < 0 {
+		return errors.New("-rotation-period must not be negative")
+	}
+	if c.flagRotationPeriod > 0 && c.flagCheckInterval <= 0 {
+		return errors.New("-check-interval must be greater than 0")
+	}
+	return nil
+}
+
+// keyID returns an ID of the given gossip key that can be shown without
+// revealing the key: the first 8 bytes of its SHA-256 hash in hex.
+func keyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// generateKey returns a new random gossip key, the same as
+// `consul keygen` does.
+func generateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Rotate the gossip encryption key."
+const help = `
+Usage: consul-k8s rotate-gossip-key [options]
+
+  Rotates the gossip encryption key stored in a Kubernetes Secret. A new
+  key is installed on all agents and made primary, then written to the
+  Secret, and finally the old key is removed from all agents. If a step
+  before updating the Secret fails, the agents are rolled back to the old
+  key. The Secret is annotated with the time of the rotation and the ID
+  of the new key.
+
+  By default the key is rotated once, e.g. from a CronJob. With
+  -rotation-period, the command keeps running and rotates the key
+  whenever the last rotation is older than the period. The Consul token
+  used by this command must have keyring:write.
+
+`
@@ -0,0 +1,250 @@
+package rotategossipkey
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/agent"
+	"github.com/hashicorp/consul/testrpc"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{},
+			ExpErr: "-k8s-namespace must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", "default"},
+			ExpErr: "-secret-name must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", "default", "-secret-name", "gossip", "-rotation-period", "-1h"},
+			ExpErr: "-rotation-period must not be negative",
+		},
+		{
+			Flags: []string{"-k8s-namespace", "default", "-secret-name", "gossip",
+				"-rotation-period", "24h", "-check-interval", "0"},
+			ExpErr: "-check-interval must be greater than 0",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	oldKey := "pUqJrVyVRj5jsiYEkM/tFQYfWyJIv4s3XkvDwy7Cu5s="
+	a := agent.NewTestAgent(t, t.Name(), `encrypt = "`+oldKey+`"`)
+	defer a.Shutdown()
+	testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+	k8s := fake.NewSimpleClientset()
+	_, err := k8s.CoreV1().Secrets("default").Create(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gossip"},
+		Data:       map[string][]byte{"key": []byte(oldKey)},
+	})
+	require.NoError(err)
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		clientset:    k8s,
+		consulClient: a.Client(),
+	}
+	responseCode := cmd.Run([]string{
+		"-k8s-namespace", "default",
+		"-secret-name", "gossip",
+	})
+	require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+	// The Secret has the new key.
+	secret, err := k8s.CoreV1().Secrets("default").Get("gossip", metav1.GetOptions{})
+	require.NoError(err)
+	newKey := string(secret.Data["key"])
+	require.NotEqual(oldKey, newKey)
+	require.Contains(secret.Annotations, rotatedAtAnnotation)
+	require.Equal(keyID(newKey), secret.Annotations[keyIDAnnotation])
+
+	// Only the new key is installed.
+	keyrings, err := a.Client().Operator().KeyringList(nil)
+	require.NoError(err)
+	for _, keyring := range keyrings {
+		require.Contains(keyring.Keys, newKey)
+		require.NotContains(keyring.Keys, oldKey)
+	}
+}
+
+// Test that the federation Secret is updated with the new key.
+func TestRun_FederationSecret(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	oldKey := "pUqJrVyVRj5jsiYEkM/tFQYfWyJIv4s3XkvDwy7Cu5s="
+	a := agent.NewTestAgent(t, t.Name(), `encrypt = "`+oldKey+`"`)
+	defer a.Shutdown()
+	testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+	k8s := fake.NewSimpleClientset()
+	_, err := k8s.CoreV1().Secrets("default").Create(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gossip"},
+		Data:       map[string][]byte{"key": []byte(oldKey)},
+	})
+	require.NoError(err)
+	_, err = k8s.CoreV1().Secrets("default").Create(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "federation"},
+		Data: map[string][]byte{
+			"caCert":              []byte("ca"),
+			"gossipEncryptionKey": []byte(oldKey),
+		},
+	})
+	require.NoError(err)
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		clientset:    k8s,
+		consulClient: a.Client(),
+	}
+	responseCode := cmd.Run([]string{
+		"-k8s-namespace", "default",
+		"-secret-name", "gossip",
+		"-federation-secret-name", "federation",
+	})
+	require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+	secret, err := k8s.CoreV1().Secrets("default").Get("gossip", metav1.GetOptions{})
+	require.NoError(err)
+	federation, err := k8s.CoreV1().Secrets("default").Get("federation", metav1.GetOptions{})
+	require.NoError(err)
+	require.Equal(string(secret.Data["key"]), string(federation.Data["gossipEncryptionKey"]))
+	require.Equal("ca", string(federation.Data["caCert"]))
+}
+
+// Test that the key is only rotated once the rotation period has passed
+// since the last rotation.
+func TestRotateIfDue(t *testing.T) {
+	t.Parallel()
+
+	oldKey := "pUqJrVyVRj5jsiYEkM/tFQYfWyJIv4s3XkvDwy7Cu5s="
+	rotatedAt := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	cases := map[string]struct {
+		Now        time.Time
+		ExpRotated bool
+	}{
+		"not due": {
+			Now:        rotatedAt.Add(23 * time.Hour),
+			ExpRotated: false,
+		},
+		"due": {
+			Now:        rotatedAt.Add(24 * time.Hour),
+			ExpRotated: true,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			a := agent.NewTestAgent(t, t.Name(), `encrypt = "`+oldKey+`"`)
+			defer a.Shutdown()
+			testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+			k8s := fake.NewSimpleClientset()
+			_, err := k8s.CoreV1().Secrets("default").Create(&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "gossip",
+					Annotations: map[string]string{
+						rotatedAtAnnotation: rotatedAt.Format(time.RFC3339),
+					},
+				},
+				Data: map[string][]byte{"key": []byte(oldKey)},
+			})
+			require.NoError(err)
+
+			cmd := Command{
+				UI:           cli.NewMockUi(),
+				clientset:    k8s,
+				consulClient: a.Client(),
+			}
+			cmd.once.Do(cmd.init)
+			require.NoError(cmd.flags.Parse([]string{
+				"-k8s-namespace", "default",
+				"-secret-name", "gossip",
+				"-rotation-period", "24h",
+			}))
+			require.NoError(cmd.rotateIfDue(hclog.NewNullLogger(), c.Now))
+
+			secret, err := k8s.CoreV1().Secrets("default").Get("gossip", metav1.GetOptions{})
+			require.NoError(err)
+			require.Equal(c.ExpRotated, string(secret.Data["key"]) != oldKey)
+		})
+	}
+}
+
+// Test that the agents are rolled back to the old key if the Secret can't
+// be updated.
+func TestRun_RollbackOnSecretUpdateFailure(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	oldKey := "pUqJrVyVRj5jsiYEkM/tFQYfWyJIv4s3XkvDwy7Cu5s="
+	a := agent.NewTestAgent(t, t.Name(), `encrypt = "`+oldKey+`"`)
+	defer a.Shutdown()
+	testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+	k8s := fake.NewSimpleClientset()
+	_, err := k8s.CoreV1().Secrets("default").Create(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gossip"},
+		Data:       map[string][]byte{"key": []byte(oldKey)},
+	})
+	require.NoError(err)
+	k8s.PrependReactor("update", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("update failed")
+	})
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		clientset:    k8s,
+		consulClient: a.Client(),
+	}
+	responseCode := cmd.Run([]string{
+		"-k8s-namespace", "default",
+		"-secret-name", "gossip",
+	})
+	require.Equal(1, responseCode)
+	require.Contains(ui.ErrorWriter.String(), "update failed")
+
+	// Only the old key is installed.
+	keyrings, err := a.Client().Operator().KeyringList(nil)
+	require.NoError(err)
+	for _, keyring := range keyrings {
+		require.Len(keyring.Keys, 1)
+		require.Contains(keyring.Keys, oldKey)
+	}
+}
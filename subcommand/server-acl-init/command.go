@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"fmt"
+	"github.com/hashicorp/consul-k8s/helper/audit"
+	"github.com/hashicorp/consul-k8s/helper/tokenstore"
 	"github.com/hashicorp/consul-k8s/subcommand"
 	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/hashicorp/consul/api"
@@ -17,7 +19,6 @@ import (
 	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 	apiv1 "k8s.io/api/core/v1"
-	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -27,6 +28,7 @@ type Command struct {
 
 	flags                        *flag.FlagSet
 	k8s                          *k8sflags.K8SFlags
+	tokenStore                   *k8sflags.TokenStoreFlags
 	flagReleaseName              string
 	flagServerLabelSelector      string
 	flagResourcePrefix           string
@@ -35,6 +37,7 @@ type Command struct {
 	flagAllowDNS                 bool
 	flagCreateClientToken        bool
 	flagCreateSyncToken          bool
+	flagSyncInstances            flags.AppendSliceValue
 	flagCreateInjectAuthMethod   bool
 	flagBindingRuleSelector      string
 	flagCreateEntLicenseToken    bool
@@ -45,8 +48,14 @@ type Command struct {
 	flagConsulCACert             string
 	flagConsulTLSServerName      string
 	flagUseHTTPS                 bool
+	flagForceReconcile           bool
+	flagAuditLog                 string
 
 	clientset kubernetes.Interface
+	// store is where the bootstrap and component tokens are stored.
+	store tokenstore.Store
+	// audit records the changes made to Consul.
+	audit *audit.Recorder
 	// cmdTimeout is cancelled when the command timeout is reached.
 	cmdTimeout    context.Context
 	retryDuration time.Duration
@@ -73,6 +82,11 @@ func (c *Command) init() {
 		"Toggle for creating a client agent token")
 	c.flags.BoolVar(&c.flagCreateSyncToken, "create-sync-token", false,
 		"Toggle for creating a catalog sync token")
+	c.flags.Var(&c.flagSyncInstances, "sync-instance",
+		"A sync-catalog deployment to create a separate token for, in the form "+
+			"<name>:<consul-service-prefix>. Its token is stored as <prefix>-catalog-sync-<name>-acl-token "+
+			"and only allows writing the Consul node k8s-sync-<name> and services starting with "+
+			"the given prefix. May be specified multiple times.")
 	c.flags.BoolVar(&c.flagCreateInjectAuthMethod, "create-inject-token", false,
 		"Toggle for creating a connect inject token")
 	c.flags.StringVar(&c.flagBindingRuleSelector, "acl-binding-rule-selector", "",
@@ -91,12 +105,22 @@ func (c *Command) init() {
 		"The server name to set as the SNI header when sending HTTPS requests to Consul.")
 	c.flags.BoolVar(&c.flagUseHTTPS, "use-https", false,
 		"Toggle for using HTTPS for all API calls to Consul.")
+	c.flags.BoolVar(&c.flagForceReconcile, "force-reconcile", false,
+		"Toggle for updating the rules of existing policies to the current rules, "+
+			"and for replacing the tokens of deleted Secrets with new tokens. Without it, "+
+			"a deleted Secret is recreated with the existing token, so delete the "+
+			"Secret and set this flag to replace a token that has been compromised.")
+	c.flags.StringVar(&c.flagAuditLog, "audit-log", "",
+		"Where to record the changes made to Consul: \"stdout\" or an http(s) URL that "+
+			"each change is POSTed to as JSON. If empty, changes are not recorded.")
 	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\".")
 
 	c.k8s = &k8sflags.K8SFlags{}
+	c.tokenStore = &k8sflags.TokenStoreFlags{}
 	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, c.tokenStore.Flags())
 	c.help = flags.Usage(help, c.flags)
 
 	// Default retry to 1s. This is exposed for setting in tests.
@@ -142,6 +166,21 @@ func (c *Command) Run(args []string) int {
 		c.UI.Error("-release-name or -server-label-selector must be set")
 		return 1
 	}
+	if err := c.tokenStore.Validate(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	syncInstances, err := parseSyncInstances(c.flagSyncInstances)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	auditSink, err := audit.NewSink(c.flagAuditLog)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	c.audit = &audit.Recorder{Component: "server-acl-init", Sink: auditSink}
 	// If only the -release-name is set, we use it as the label selector.
 	if c.flagReleaseName != "" {
 		c.flagServerLabelSelector = fmt.Sprintf("app=consul,component=server,release=%s", c.flagReleaseName)
@@ -170,6 +209,13 @@ func (c *Command) Run(args []string) int {
 			return 1
 		}
 	}
+	if c.store == nil {
+		c.store, err = c.tokenStore.Store(c.clientset, c.flagNamespace)
+		if err != nil {
+			logger.Error(err.Error())
+			return 1
+		}
+	}
 
 	scheme := "http"
 	if c.flagUseHTTPS {
@@ -201,12 +247,12 @@ func (c *Command) Run(args []string) int {
 	bootTokenSecretName := c.withPrefix("bootstrap-acl-token")
 	bootstrapToken, err := c.getBootstrapToken(logger, bootTokenSecretName)
 	if err != nil {
-		logger.Error(fmt.Sprintf("Unexpected error looking for preexisting bootstrap Secret: %s", err))
+		logger.Error(fmt.Sprintf("Unexpected error looking for preexisting bootstrap token: %s", err))
 		return 1
 	}
 
 	if bootstrapToken != "" {
-		logger.Info(fmt.Sprintf("ACLs already bootstrapped - retrieved bootstrap token from Secret %q", bootTokenSecretName))
+		logger.Info(fmt.Sprintf("ACLs already bootstrapped - retrieved bootstrap token %q", bootTokenSecretName))
 	} else {
 		logger.Info("No bootstrap token from previous installation found, continuing on to bootstrapping")
 		bootstrapToken, err = c.bootstrapServers(logger, bootTokenSecretName, scheme)
@@ -261,6 +307,19 @@ func (c *Command) Run(args []string) int {
 		}
 	}
 
+	for _, instance := range syncInstances {
+		rules, err := instance.rules()
+		if err != nil {
+			logger.Error(err.Error())
+			return 1
+		}
+		err = c.createACL("catalog-sync-"+instance.Name, rules, consulClient, logger)
+		if err != nil {
+			logger.Error(err.Error())
+			return 1
+		}
+	}
+
 	if c.flagCreateEntLicenseToken {
 		err := c.createACL("enterprise-license", entLicenseRules, consulClient, logger)
 		if err != nil {
@@ -298,21 +357,10 @@ func (c *Command) Run(args []string) int {
 }
 
 // getBootstrapToken returns the existing bootstrap token if there is one by
-// reading the Kubernetes Secret with name secretName.
+// reading the token with name secretName from the token store.
 // If there is no bootstrap token yet, then it returns an empty string (not an error).
 func (c *Command) getBootstrapToken(logger hclog.Logger, secretName string) (string, error) {
-	secret, err := c.clientset.CoreV1().Secrets(c.flagNamespace).Get(secretName, metav1.GetOptions{})
-	if err != nil {
-		if k8serrors.IsNotFound(err) {
-			return "", nil
-		}
-		return "", err
-	}
-	token, ok := secret.Data["token"]
-	if !ok {
-		return "", fmt.Errorf("secret %q does not have data key 'token'", secretName)
-	}
-	return string(token), nil
+	return c.store.Get(secretName)
 }
 
 func (c *Command) configureKubeClient() error {
@@ -403,9 +451,10 @@ func (c *Command) bootstrapServers(logger hclog.Logger, bootTokenSecretName, sch
 	// Call bootstrap ACLs API.
 	var bootstrapToken []byte
 	var unrecoverableErr error
-	err = c.untilSucceeds("bootstrapping ACLs - PUT /v1/acl/bootstrap",
-		func() error {
+	err = c.untilSucceedsAudited("bootstrapping ACLs - PUT /v1/acl/bootstrap",
+		func(attempts *auditAttempts) error {
 			bootstrapResp, _, err := consulClient.ACL().Bootstrap()
+			attempts.add("acl-bootstrap", "bootstrap-token", bootTokenSecretName, err)
 			if err == nil {
 				bootstrapToken = []byte(bootstrapResp.SecretID)
 				return nil
@@ -433,19 +482,10 @@ func (c *Command) bootstrapServers(logger hclog.Logger, bootTokenSecretName, sch
 		return "", err
 	}
 
-	// Write bootstrap token to a Kubernetes secret.
+	// Write bootstrap token to the token store.
 	err = c.untilSucceeds(fmt.Sprintf("writing bootstrap Secret %q", bootTokenSecretName),
 		func() error {
-			secret := &apiv1.Secret{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: bootTokenSecretName,
-				},
-				Data: map[string][]byte{
-					"token": bootstrapToken,
-				},
-			}
-			_, err := c.clientset.CoreV1().Secrets(c.flagNamespace).Create(secret)
-			return err
+			return c.store.Put(bootTokenSecretName, string(bootstrapToken))
 		}, logger)
 	if err != nil {
 		return "", err
@@ -483,14 +523,9 @@ func (c *Command) setServerTokens(logger hclog.Logger, consulClient *api.Client,
 		Description: "Agent Token Policy",
 		Rules:       agentRules,
 	}
-	err := c.untilSucceeds("creating agent policy - PUT /v1/acl/policy",
-		func() error {
-			_, _, err := consulClient.ACL().PolicyCreate(&agentPolicy, nil)
-			if isPolicyExistsErr(err, agentPolicy.Name) {
-				logger.Info(fmt.Sprintf("Policy %q already exists", agentPolicy.Name))
-				return nil
-			}
-			return err
+	err := c.untilSucceedsAudited("creating agent policy - PUT /v1/acl/policy",
+		func(attempts *auditAttempts) error {
+			return c.createOrUpdatePolicy(agentPolicy, consulClient, attempts, logger)
 		}, logger)
 	if err != nil {
 		return err
@@ -500,14 +535,15 @@ func (c *Command) setServerTokens(logger hclog.Logger, consulClient *api.Client,
 	var serverTokens []api.ACLToken
 	for _, pod := range serverPods {
 		var token *api.ACLToken
-		err := c.untilSucceeds(fmt.Sprintf("creating server token for %s - PUT /v1/acl/token", pod.Name),
-			func() error {
+		err := c.untilSucceedsAudited(fmt.Sprintf("creating server token for %s - PUT /v1/acl/token", pod.Name),
+			func(attempts *auditAttempts) error {
 				tokenReq := api.ACLToken{
 					Description: fmt.Sprintf("Server Token for %s", pod.Name),
 					Policies:    []*api.ACLTokenPolicyLink{{Name: agentPolicy.Name}},
 				}
 				var err error
 				token, _, err = consulClient.ACL().TokenCreate(&tokenReq, nil)
+				attempts.add("acl-token-create", tokenReq.Description, "", err)
 				return err
 			}, logger)
 		if err != nil {
@@ -535,9 +571,10 @@ func (c *Command) setServerTokens(logger hclog.Logger, consulClient *api.Client,
 		podName := pod.Name
 
 		// Update token.
-		err = c.untilSucceeds(fmt.Sprintf("updating server token for %s - PUT /v1/agent/token/agent", podName),
-			func() error {
+		err = c.untilSucceedsAudited(fmt.Sprintf("updating server token for %s - PUT /v1/agent/token/agent", podName),
+			func(attempts *auditAttempts) error {
 				_, err := serverClient.Agent().UpdateAgentACLToken(serverTokens[i].SecretID, nil)
+				attempts.add("agent-token-update", podName, "", err)
 				return err
 			}, logger)
 		if err != nil {
@@ -548,15 +585,13 @@ func (c *Command) setServerTokens(logger hclog.Logger, consulClient *api.Client,
 }
 
 // createACL creates a policy with rules and name, creates an ACL token for that
-// policy and then writes the token to a Kubernetes secret.
+// policy and then writes the token to the token store.
 func (c *Command) createACL(name, rules string, consulClient *api.Client, logger hclog.Logger) error {
-	// Check if the secret already exists, if so, we assume the ACL has already been created.
+	// Every step checks for its outputs, so a run picks up where a failed
+	// one stopped and recreates what has been deleted since.
 	secretName := c.withPrefix(name + "-acl-token")
-	_, err := c.clientset.CoreV1().Secrets(c.flagNamespace).Get(secretName, metav1.GetOptions{})
-	if err == nil {
-		logger.Info(fmt.Sprintf("Secret %q already exists", secretName))
-		return nil
-	}
+	existing, err := c.store.Get(secretName)
+	exists := err == nil && existing != ""
 
 	// Create policy with the given rules.
 	policyTmpl := api.ACLPolicy{
@@ -564,28 +599,52 @@ func (c *Command) createACL(name, rules string, consulClient *api.Client, logger
 		Description: fmt.Sprintf("%s Token Policy", name),
 		Rules:       rules,
 	}
-	err = c.untilSucceeds(fmt.Sprintf("creating %s policy", policyTmpl.Name),
-		func() error {
-			_, _, err := consulClient.ACL().PolicyCreate(&policyTmpl, &api.WriteOptions{})
-			if isPolicyExistsErr(err, policyTmpl.Name) {
-				logger.Info(fmt.Sprintf("Policy %q already exists", policyTmpl.Name))
-				return nil
-			}
-			return err
+	err = c.untilSucceedsAudited(fmt.Sprintf("creating %s policy", policyTmpl.Name),
+		func(attempts *auditAttempts) error {
+			return c.createOrUpdatePolicy(policyTmpl, consulClient, attempts, logger)
 		}, logger)
 	if err != nil {
 		return err
 	}
+	if exists {
+		logger.Info(fmt.Sprintf("Secret %q already exists", secretName))
+		return nil
+	}
 
-	// Create token for the policy.
+	// Create token for the policy. If a previous run created the token but
+	// failed to store it, that token is reused, unless -force-reconcile is
+	// set, in which case it's deleted and replaced.
 	tokenTmpl := api.ACLToken{
 		Description: fmt.Sprintf("%s Token", name),
 		Policies:    []*api.ACLTokenPolicyLink{{Name: policyTmpl.Name}},
 	}
 	var token string
-	err = c.untilSucceeds(fmt.Sprintf("creating token for policy %s", policyTmpl.Name),
-		func() error {
+	err = c.untilSucceedsAudited(fmt.Sprintf("creating token for policy %s", policyTmpl.Name),
+		func(attempts *auditAttempts) error {
+			for {
+				existingToken, err := findToken(consulClient, tokenTmpl.Description, policyTmpl.Name)
+				if err != nil {
+					return err
+				}
+				if existingToken == nil {
+					break
+				}
+				if !c.flagForceReconcile {
+					logger.Info(fmt.Sprintf("Token for policy %s already exists", policyTmpl.Name))
+					token = existingToken.SecretID
+					return nil
+				}
+
+				logger.Info(fmt.Sprintf("Replacing existing token for policy %s", policyTmpl.Name))
+				_, err = consulClient.ACL().TokenDelete(existingToken.AccessorID, nil)
+				attempts.add("acl-token-delete", existingToken.AccessorID, secretName, err)
+				if err != nil {
+					return err
+				}
+			}
+
 			createdToken, _, err := consulClient.ACL().TokenCreate(&tokenTmpl, &api.WriteOptions{})
+			attempts.add("acl-token-create", tokenTmpl.Description, secretName, err)
 			if err == nil {
 				token = createdToken.SecretID
 			}
@@ -595,19 +654,10 @@ func (c *Command) createACL(name, rules string, consulClient *api.Client, logger
 		return err
 	}
 
-	// Write token to a Kubernetes secret.
+	// Write token to the token store.
 	return c.untilSucceeds(fmt.Sprintf("writing Secret for token %s", policyTmpl.Name),
 		func() error {
-			secret := &apiv1.Secret{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: secretName,
-				},
-				Data: map[string][]byte{
-					"token": []byte(token),
-				},
-			}
-			_, err := c.clientset.CoreV1().Secrets(c.flagNamespace).Create(secret)
-			return err
+			return c.store.Put(secretName, token)
 		}, logger)
 }
 
@@ -621,14 +671,9 @@ func (c *Command) configureDNSPolicies(logger hclog.Logger, consulClient *api.Cl
 		Rules:       dnsRules,
 	}
 
-	err := c.untilSucceeds("creating dns policy - PUT /v1/acl/policy",
-		func() error {
-			_, _, err := consulClient.ACL().PolicyCreate(&dnsPolicy, nil)
-			if isPolicyExistsErr(err, dnsPolicy.Name) {
-				logger.Info(fmt.Sprintf("Policy %q already exists", dnsPolicy.Name))
-				return nil
-			}
-			return err
+	err := c.untilSucceedsAudited("creating dns policy - PUT /v1/acl/policy",
+		func(attempts *auditAttempts) error {
+			return c.createOrUpdatePolicy(dnsPolicy, consulClient, attempts, logger)
 		}, logger)
 	if err != nil {
 		return err
@@ -641,9 +686,10 @@ func (c *Command) configureDNSPolicies(logger hclog.Logger, consulClient *api.Cl
 	}
 
 	// Update anonymous token to include this policy
-	return c.untilSucceeds("updating anonymous token with DNS policy",
-		func() error {
+	return c.untilSucceedsAudited("updating anonymous token with DNS policy",
+		func(attempts *auditAttempts) error {
 			_, _, err := consulClient.ACL().TokenUpdate(&aToken, &api.WriteOptions{})
+			attempts.add("acl-token-update", "anonymous", "", err)
 			return err
 		}, logger)
 }
@@ -721,10 +767,11 @@ func (c *Command) configureConnectInject(logger hclog.Logger, consulClient *api.
 		},
 	}
 	var authMethod *api.ACLAuthMethod
-	err = c.untilSucceeds(fmt.Sprintf("creating auth method %s", authMethodTmpl.Name),
-		func() error {
+	err = c.untilSucceedsAudited(fmt.Sprintf("creating auth method %s", authMethodTmpl.Name),
+		func(attempts *auditAttempts) error {
 			var err error
 			authMethod, _, err = consulClient.ACL().AuthMethodCreate(&authMethodTmpl, &api.WriteOptions{})
+			attempts.add("acl-auth-method-create", authMethodTmpl.Name, "", err)
 			return err
 		}, logger)
 	if err != nil {
@@ -739,9 +786,10 @@ func (c *Command) configureConnectInject(logger hclog.Logger, consulClient *api.
 		BindName:    "${serviceaccount.name}",
 		Selector:    c.flagBindingRuleSelector,
 	}
-	return c.untilSucceeds(fmt.Sprintf("creating acl binding rule for %s", authMethodTmpl.Name),
-		func() error {
+	return c.untilSucceedsAudited(fmt.Sprintf("creating acl binding rule for %s", authMethodTmpl.Name),
+		func(attempts *auditAttempts) error {
 			_, _, err := consulClient.ACL().BindingRuleCreate(&abr, nil)
+			attempts.add("acl-binding-rule-create", abr.AuthMethod, "", err)
 			return err
 		}, logger)
 }
@@ -769,6 +817,70 @@ func (c *Command) untilSucceeds(opName string, op func() error, logger hclog.Log
 	return nil
 }
 
+// untilSucceedsAudited is untilSucceeds for operations that change Consul.
+// op adds the result of each change it attempts to attempts. The failures
+// of an attempt are dropped when op is retried, so that only the final
+// result of each change is recorded in the audit log rather than every
+// failed attempt.
+func (c *Command) untilSucceedsAudited(opName string, op func(attempts *auditAttempts) error, logger hclog.Logger) error {
+	var attempts auditAttempts
+	err := c.untilSucceeds(opName, func() error {
+		attempts.dropFailed()
+		return op(&attempts)
+	}, logger)
+	for _, a := range attempts.results {
+		c.recordAudit(logger, a.operation, a.target, a.source, a.err)
+	}
+	return err
+}
+
+// auditAttempts holds the results of the changes attempted by an operation
+// that is retried.
+type auditAttempts struct {
+	results []auditAttempt
+}
+
+type auditAttempt struct {
+	operation string
+	target    string
+	source    string
+	err       error
+}
+
+// add adds the result of an attempted change.
+func (a *auditAttempts) add(operation, target, source string, err error) {
+	a.results = append(a.results, auditAttempt{
+		operation: operation,
+		target:    target,
+		source:    source,
+		err:       err,
+	})
+}
+
+// dropFailed drops the results of the changes that failed, which are
+// attempted again.
+func (a *auditAttempts) dropFailed() {
+	succeeded := a.results[:0]
+	for _, r := range a.results {
+		if r.err == nil {
+			succeeded = append(succeeded, r)
+		}
+	}
+	a.results = succeeded
+}
+
+// recordAudit records a change made to Consul in the audit log. source is
+// the Kubernetes Secret the change was made for, if any.
+func (c *Command) recordAudit(logger hclog.Logger, operation, target, source string, err error) {
+	if source != "" {
+		source = fmt.Sprintf("Secret %s/%s", c.flagNamespace, source)
+	}
+	if auditErr := c.audit.Record(operation, target, source, err); auditErr != nil {
+		logger.Warn("failed to record audit event",
+			"operation", operation, "target", target, "err", auditErr)
+	}
+}
+
 // withPrefix returns the name of resource with the correct prefix based
 // on the -release-name or -resource-prefix flags.
 func (c *Command) withPrefix(resource string) string {
@@ -781,6 +893,59 @@ func (c *Command) withPrefix(resource string) string {
 	return fmt.Sprintf("%s-consul-%s", c.flagReleaseName, resource)
 }
 
+// createOrUpdatePolicy creates the policy. If the policy already exists, its
+// rules are only updated if -force-reconcile is set. The attempted changes
+// are added to attempts.
+func (c *Command) createOrUpdatePolicy(policy api.ACLPolicy, consulClient *api.Client, attempts *auditAttempts, logger hclog.Logger) error {
+	_, _, err := consulClient.ACL().PolicyCreate(&policy, &api.WriteOptions{})
+	if !isPolicyExistsErr(err, policy.Name) {
+		attempts.add("acl-policy-create", policy.Name, "", err)
+		return err
+	}
+	if !c.flagForceReconcile {
+		logger.Info(fmt.Sprintf("Policy %q already exists", policy.Name))
+		return nil
+	}
+
+	policies, _, err := consulClient.ACL().PolicyList(nil)
+	if err != nil {
+		return err
+	}
+	for _, p := range policies {
+		if p.Name == policy.Name {
+			policy.ID = p.ID
+			_, _, err := consulClient.ACL().PolicyUpdate(&policy, &api.WriteOptions{})
+			attempts.add("acl-policy-update", policy.Name, "", err)
+			if err == nil {
+				logger.Info(fmt.Sprintf("Policy %q updated", policy.Name))
+			}
+			return err
+		}
+	}
+	return fmt.Errorf("policy %q exists but was not found", policy.Name)
+}
+
+// findToken returns the token with the given description that is linked to
+// the named policy, or nil if there is no such token.
+func findToken(consulClient *api.Client, description, policyName string) (*api.ACLToken, error) {
+	tokens, _, err := consulClient.ACL().TokenList(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		if t.Description != description {
+			continue
+		}
+		for _, p := range t.Policies {
+			if p.Name == policyName {
+				token, _, err := consulClient.ACL().TokenRead(t.AccessorID, nil)
+				return token, err
+			}
+		}
+	}
+	return nil, nil
+}
+
 // isNoLeaderErr returns true if err is due to trying to call the
 // bootstrap ACLs API when there is no leader elected.
 func isNoLeaderErr(err error) bool {
@@ -810,7 +975,8 @@ const help = `
 Usage: consul-k8s server-acl-init [options]
 
   Bootstraps servers with ACLs and creates policies and ACL tokens for other
-  components as Kubernetes Secrets.
+  components as Kubernetes Secrets, or as Vault secrets with
+  -secrets-backend=vault.
   It will run indefinitely until all tokens have been created. It is idempotent
   and safe to run multiple times.
 
@@ -1,12 +1,17 @@
 package serveraclinit
 
 import (
+	"context"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/hashicorp/consul-k8s/helper/audit"
 	"github.com/hashicorp/consul/agent"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/tlsutil"
+	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
@@ -21,6 +26,7 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -46,6 +52,14 @@ func TestRun_FlagValidation(t *testing.T) {
 			Flags:  []string{"-server-label-selector=hi"},
 			ExpErr: "if -server-label-selector is set -resource-prefix must also be set",
 		},
+		{
+			Flags:  []string{"-release-name=name", "-secrets-backend=etcd"},
+			ExpErr: "-secrets-backend must be \"kubernetes\", \"vault\" or \"aws-secrets-manager\"",
+		},
+		{
+			Flags:  []string{"-release-name=name", "-audit-log=/var/log/audit"},
+			ExpErr: "audit log destination must be \"stdout\" or an http(s) URL",
+		},
 	}
 
 	for _, c := range cases {
@@ -160,6 +174,19 @@ func TestRun_Tokens(t *testing.T) {
 			TokenName:          "catalog-sync",
 			SecretName:         "my-prefix-catalog-sync-acl-token",
 		},
+		"catalog-sync instance token -release-name": {
+			TokenFlag:          "-sync-instance=team-a:a-",
+			ResourcePrefixFlag: "",
+			ReleaseNameFlag:    "release-name",
+			TokenName:          "catalog-sync-team-a",
+			SecretName:         "release-name-consul-catalog-sync-team-a-acl-token",
+		},
+		"catalog-sync instance token -resource-prefix": {
+			TokenFlag:          "-sync-instance=team-a:a-",
+			ResourcePrefixFlag: "my-prefix",
+			TokenName:          "catalog-sync-team-a",
+			SecretName:         "my-prefix-catalog-sync-team-a-acl-token",
+		},
 		"enterprise-license token -release-name": {
 			TokenFlag:          "-create-enterprise-license-token",
 			ResourcePrefixFlag: "",
@@ -262,6 +289,176 @@ func TestRun_Tokens(t *testing.T) {
 	}
 }
 
+// Test that the changes made to Consul are recorded in the audit log.
+func TestRun_AuditLog(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	k8s, testAgent := completeSetup(t, resourcePrefix)
+	defer testAgent.Shutdown()
+
+	var lock sync.Mutex
+	var events []audit.Event
+	auditServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e audit.Event
+		require.NoError(json.NewDecoder(r.Body).Decode(&e))
+		lock.Lock()
+		events = append(events, e)
+		lock.Unlock()
+	}))
+	defer auditServer.Close()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	responseCode := cmd.Run([]string{
+		"-server-label-selector=component=server,app=consul,release=" + releaseName,
+		"-resource-prefix=" + resourcePrefix,
+		"-k8s-namespace=" + ns,
+		"-expected-replicas=1",
+		"-create-sync-token",
+		"-audit-log=" + auditServer.URL,
+	})
+	require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+	lock.Lock()
+	defer lock.Unlock()
+	var ops []string
+	for _, e := range events {
+		require.Equal("server-acl-init", e.Component)
+		require.Empty(e.Error)
+		ops = append(ops, e.Operation+" "+e.Target)
+	}
+	require.Contains(ops, "acl-bootstrap bootstrap-token")
+	require.Contains(ops, "acl-policy-create catalog-sync-token")
+	require.Contains(ops, "acl-token-create catalog-sync Token")
+}
+
+// recordingSink records the audit events in memory.
+type recordingSink struct {
+	events []audit.Event
+}
+
+func (s *recordingSink) Record(e audit.Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+// Test that only the final result of each change of a retried operation
+// is recorded in the audit log.
+func TestUntilSucceedsAudited(t *testing.T) {
+	require := require.New(t)
+	sink := &recordingSink{}
+	cmd := Command{
+		audit:         &audit.Recorder{Component: "server-acl-init", Sink: sink},
+		cmdTimeout:    context.Background(),
+		retryDuration: time.Millisecond,
+	}
+
+	calls := 0
+	err := cmd.untilSucceedsAudited("test", func(attempts *auditAttempts) error {
+		calls++
+		// The first change succeeds on the second attempt and the second
+		// change fails until the third.
+		if calls == 1 {
+			attempts.add("acl-token-delete", "a", "", errors.New("no leader"))
+			return errors.New("no leader")
+		}
+		if calls == 2 {
+			attempts.add("acl-token-delete", "a", "", nil)
+		}
+		if calls < 3 {
+			attempts.add("acl-token-create", "b", "", errors.New("no leader"))
+			return errors.New("no leader")
+		}
+		attempts.add("acl-token-create", "b", "", nil)
+		return nil
+	}, hclog.NewNullLogger())
+	require.NoError(err)
+
+	var ops []string
+	for _, e := range sink.events {
+		require.Empty(e.Error)
+		ops = append(ops, e.Operation+" "+e.Target)
+	}
+	require.Equal([]string{"acl-token-delete a", "acl-token-create b"}, ops)
+}
+
+// Test that a rerun recreates a deleted token Secret, reusing the existing
+// token, and that -force-reconcile updates the rules of existing policies
+// and replaces the token of a deleted Secret.
+func TestRun_RecreatesDeletedSecret(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	k8s, testAgent := completeSetup(t, resourcePrefix)
+	defer testAgent.Shutdown()
+
+	args := []string{
+		"-server-label-selector=component=server,app=consul,release=" + releaseName,
+		"-resource-prefix=" + resourcePrefix,
+		"-k8s-namespace=" + ns,
+		"-expected-replicas=1",
+		"-create-sync-token",
+	}
+	run := func(extraArgs ...string) {
+		ui := cli.NewMockUi()
+		cmd := Command{
+			UI:        ui,
+			clientset: k8s,
+		}
+		responseCode := cmd.Run(append(args, extraArgs...))
+		require.Equal(0, responseCode, ui.ErrorWriter.String())
+	}
+	run()
+
+	syncSecretName := resourcePrefix + "-catalog-sync-acl-token"
+	syncSecret, err := k8s.CoreV1().Secrets(ns).Get(syncSecretName, metav1.GetOptions{})
+	require.NoError(err)
+	require.NoError(k8s.CoreV1().Secrets(ns).Delete(syncSecretName, nil))
+
+	// The Secret is recreated with the existing token.
+	run()
+	newSyncSecret, err := k8s.CoreV1().Secrets(ns).Get(syncSecretName, metav1.GetOptions{})
+	require.NoError(err)
+	require.Equal(syncSecret.Data["token"], newSyncSecret.Data["token"])
+
+	// Changed rules are only applied when forced.
+	bootToken := getBootToken(t, k8s, resourcePrefix)
+	consul := testAgent.Client()
+	syncPolicy := func() *api.ACLPolicy {
+		policies, _, err := consul.ACL().PolicyList(&api.QueryOptions{Token: bootToken})
+		require.NoError(err)
+		for _, p := range policies {
+			if p.Name == "catalog-sync-token" {
+				policy, _, err := consul.ACL().PolicyRead(p.ID, &api.QueryOptions{Token: bootToken})
+				require.NoError(err)
+				return policy
+			}
+		}
+		require.FailNow("catalog-sync-token policy was not found")
+		return nil
+	}
+	policy := syncPolicy()
+	policy.Rules = `node_prefix "" { policy = "read" }`
+	_, _, err = consul.ACL().PolicyUpdate(policy, &api.WriteOptions{Token: bootToken})
+	require.NoError(err)
+
+	run()
+	require.Equal(policy.Rules, syncPolicy().Rules)
+	run("-force-reconcile")
+	require.Equal(syncRules, syncPolicy().Rules)
+
+	// The token is replaced when forced.
+	require.NoError(k8s.CoreV1().Secrets(ns).Delete(syncSecretName, nil))
+	run("-force-reconcile")
+	newSyncSecret, err = k8s.CoreV1().Secrets(ns).Get(syncSecretName, metav1.GetOptions{})
+	require.NoError(err)
+	require.NotEqual(syncSecret.Data["token"], newSyncSecret.Data["token"])
+	_, _, err = consul.ACL().TokenReadSelf(&api.QueryOptions{Token: string(syncSecret.Data["token"])})
+	require.Error(err)
+}
+
 func TestRun_AllowDNS(t *testing.T) {
 	t.Parallel()
 	k8s, testAgent := completeSetup(t, resourcePrefix)
@@ -436,6 +633,12 @@ func TestRun_DelayedServerPods(t *testing.T) {
 			Path:   r.URL.Path,
 		})
 
+		// Listing tokens returns an array.
+		if r.URL.Path == "/v1/acl/tokens" {
+			fmt.Fprintln(w, "[]")
+			return
+		}
+
 		// Send an empty JSON response with code 200 to all calls.
 		fmt.Fprintln(w, "{}")
 	}))
@@ -551,6 +754,10 @@ func TestRun_DelayedServerPods(t *testing.T) {
 			"PUT",
 			"/v1/acl/policy",
 		},
+		{
+			"GET",
+			"/v1/acl/tokens",
+		},
 		{
 			"PUT",
 			"/v1/acl/token",
@@ -576,6 +783,12 @@ func TestRun_InProgressDeployment(t *testing.T) {
 			Path:   r.URL.Path,
 		})
 
+		// Listing tokens returns an array.
+		if r.URL.Path == "/v1/acl/tokens" {
+			fmt.Fprintln(w, "[]")
+			return
+		}
+
 		// Send an empty JSON response with code 200 to all calls.
 		fmt.Fprintln(w, "{}")
 	}))
@@ -707,6 +920,10 @@ func TestRun_InProgressDeployment(t *testing.T) {
 			"PUT",
 			"/v1/acl/policy",
 		},
+		{
+			"GET",
+			"/v1/acl/tokens",
+		},
 		{
 			"PUT",
 			"/v1/acl/token",
@@ -735,6 +952,12 @@ func TestRun_NoLeader(t *testing.T) {
 			Path:   r.URL.Path,
 		})
 
+		// Listing tokens returns an array.
+		if r.URL.Path == "/v1/acl/tokens" {
+			fmt.Fprintln(w, "[]")
+			return
+		}
+
 		switch r.URL.Path {
 		case "/v1/acl/bootstrap":
 			// On the first two calls, return the error that results from no leader
@@ -862,6 +1085,10 @@ func TestRun_NoLeader(t *testing.T) {
 			"PUT",
 			"/v1/acl/policy",
 		},
+		{
+			"GET",
+			"/v1/acl/tokens",
+		},
 		{
 			"PUT",
 			"/v1/acl/token",
@@ -890,6 +1117,12 @@ func TestRun_ClientTokensRetry(t *testing.T) {
 			Path:   r.URL.Path,
 		})
 
+		// Listing tokens returns an array.
+		if r.URL.Path == "/v1/acl/tokens" {
+			fmt.Fprintln(w, "[]")
+			return
+		}
+
 		switch r.URL.Path {
 		// The second call to create a policy will fail. This is the client
 		// token call.
@@ -999,6 +1232,10 @@ func TestRun_ClientTokensRetry(t *testing.T) {
 			"PUT",
 			"/v1/acl/policy",
 		},
+		{
+			"GET",
+			"/v1/acl/tokens",
+		},
 		{
 			"PUT",
 			"/v1/acl/token",
@@ -1027,6 +1264,12 @@ func TestRun_AlreadyBootstrapped(t *testing.T) {
 			Path:   r.URL.Path,
 		})
 
+		// Listing tokens returns an array.
+		if r.URL.Path == "/v1/acl/tokens" {
+			fmt.Fprintln(w, "[]")
+			return
+		}
+
 		switch r.URL.Path {
 		default:
 			fmt.Fprintln(w, "{}")
@@ -1123,6 +1366,10 @@ func TestRun_AlreadyBootstrapped(t *testing.T) {
 			"PUT",
 			"/v1/acl/policy",
 		},
+		{
+			"GET",
+			"/v1/acl/tokens",
+		},
 		{
 			"PUT",
 			"/v1/acl/token",
@@ -0,0 +1,88 @@
+package serveraclinit
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// validSyncInstanceName matches the names that can be used in the token's
+// Secret name and the Consul node name.
+var validSyncInstanceName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// syncInstance is a sync-catalog deployment that gets its own token. Its
+// token can only write its own node and the services with its prefix.
+type syncInstance struct {
+	// Name is the name of the instance. The instance's token is named
+	// catalog-sync-<Name>.
+	Name string
+
+	// NodeName is the Consul node the instance registers services on. It
+	// must be passed to the instance's -consul-node-name flag.
+	NodeName string
+
+	// ServicePrefix is the prefix of the services the instance can write.
+	// It must match the instance's -consul-service-prefix flag.
+	ServicePrefix string
+}
+
+// parseSyncInstances parses the values of the -sync-instance flag, each of
+// the form <name>:<consul-service-prefix>. The prefix is required, since an
+// empty prefix would let the instance write every service.
+func parseSyncInstances(values []string) ([]syncInstance, error) {
+	seen := make(map[string]bool, len(values))
+	instances := make([]syncInstance, 0, len(values))
+	for _, v := range values {
+		parts := strings.SplitN(v, ":", 2)
+		name := parts[0]
+		if !validSyncInstanceName.MatchString(name) {
+			return nil, fmt.Errorf("-sync-instance %q: name must consist of lower case "+
+				"alphanumeric characters or '-', and start and end with an alphanumeric character", v)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("-sync-instance %q: name is used more than once", v)
+		}
+		seen[name] = true
+
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("-sync-instance %q: a non-empty Consul service prefix must be "+
+				"given after the name, e.g. %s:%s-", v, name, name)
+		}
+
+		instances = append(instances, syncInstance{
+			Name:          name,
+			NodeName:      "k8s-sync-" + name,
+			ServicePrefix: parts[1],
+		})
+	}
+	return instances, nil
+}
+
+// rules returns the ACL rules of the instance's token.
+func (i syncInstance) rules() (string, error) {
+	tpl := template.Must(template.New("root").Parse(strings.TrimSpace(syncInstanceRulesTpl)))
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, i); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// The services of other instances are readable so that syncing to
+// Kubernetes keeps working.
+const syncInstanceRulesTpl = `
+node_prefix "" {
+   policy = "read"
+}
+node "{{ .NodeName }}" {
+   policy = "write"
+}
+service_prefix "" {
+   policy = "read"
+}
+service_prefix "{{ .ServicePrefix }}" {
+   policy = "write"
+}
+`
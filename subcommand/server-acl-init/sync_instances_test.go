@@ -0,0 +1,82 @@
+package serveraclinit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSyncInstances(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		Values []string
+		Exp    []syncInstance
+		ExpErr string
+	}{
+		"no instances": {
+			Values: nil,
+			Exp:    []syncInstance{},
+		},
+		"name and prefix": {
+			Values: []string{"team-a:a-", "team-b:b-"},
+			Exp: []syncInstance{
+				{Name: "team-a", NodeName: "k8s-sync-team-a", ServicePrefix: "a-"},
+				{Name: "team-b", NodeName: "k8s-sync-team-b", ServicePrefix: "b-"},
+			},
+		},
+		"invalid name": {
+			Values: []string{"Team_A:a-"},
+			ExpErr: `-sync-instance "Team_A:a-": name must consist of lower case alphanumeric characters`,
+		},
+		"name only": {
+			Values: []string{"team-a"},
+			ExpErr: `-sync-instance "team-a": a non-empty Consul service prefix must be given`,
+		},
+		"empty prefix": {
+			Values: []string{"team-a:"},
+			ExpErr: `-sync-instance "team-a:": a non-empty Consul service prefix must be given`,
+		},
+		"duplicate name": {
+			Values: []string{"team-a:a-", "team-a:b-"},
+			ExpErr: `-sync-instance "team-a:b-": name is used more than once`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			instances, err := parseSyncInstances(c.Values)
+			if c.ExpErr != "" {
+				require.Error(err)
+				require.Contains(err.Error(), c.ExpErr)
+				return
+			}
+			require.NoError(err)
+			require.Equal(c.Exp, instances)
+		})
+	}
+}
+
+func TestSyncInstance_rules(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+
+	rules, err := syncInstance{
+		Name:          "team-a",
+		NodeName:      "k8s-sync-team-a",
+		ServicePrefix: "a-",
+	}.rules()
+	require.NoError(err)
+	require.Equal(`node_prefix "" {
+   policy = "read"
+}
+node "k8s-sync-team-a" {
+   policy = "write"
+}
+service_prefix "" {
+   policy = "read"
+}
+service_prefix "a-" {
+   policy = "write"
+}`, rules)
+}
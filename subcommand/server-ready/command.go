@@ -0,0 +1,178 @@
+package serverready
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+)
+
+// Command is the command for checking the readiness of a Consul server.
+type Command struct {
+	UI cli.Ui
+
+	flags             *flag.FlagSet
+	http              *flags.HTTPFlags
+	flagRequireLeader bool
+	flagMaxIndexLag   uint64
+
+	consulClient *api.Client
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.BoolVar(&c.flagRequireLeader, "require-leader", true,
+		"If true, the server is only ready if the cluster has a leader. Defaults to true.")
+	c.flags.Uint64Var(&c.flagMaxIndexLag, "max-index-lag", 10,
+		"Maximum number of Raft log entries the server may be behind the leader "+
+			"and still be ready. Defaults to 10.")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+// Run checks once whether the local Consul server is ready. It exits 0 if
+// it is and 1 with the reason if it isn't, so that it can be used as an
+// exec readiness probe.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Error: should have no non-flag arguments")
+		return 1
+	}
+
+	// The client might already be set if we're in a test.
+	if c.consulClient == nil {
+		var err error
+		c.consulClient, err = c.http.APIClient()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	if err := c.check(); err != nil {
+		c.UI.Error(fmt.Sprintf("Not ready: %s", err))
+		return 1
+	}
+	c.UI.Info("Ready")
+	return 0
+}
+
+// check returns an error if the local server isn't a voter, if there is no
+// leader and one is required, or if the server is too far behind the
+// leader.
+func (c *Command) check() error {
+	self, err := c.consulClient.Agent().Self()
+	if err != nil {
+		return fmt.Errorf("error reading agent configuration: %s", err)
+	}
+	if server, _ := self["Config"]["Server"].(bool); !server {
+		return errors.New("agent is not a server")
+	}
+	nodeID, _ := self["Config"]["NodeID"].(string)
+
+	// The Raft configuration is read from the local server so that it's
+	// available without a leader.
+	raft, err := c.consulClient.Operator().RaftGetConfiguration(&api.QueryOptions{AllowStale: true})
+	if err != nil {
+		return fmt.Errorf("error reading Raft configuration: %s", err)
+	}
+	hasLeader, err := checkVoter(raft, nodeID)
+	if err != nil {
+		return err
+	}
+	if !hasLeader {
+		if c.flagRequireLeader {
+			return errors.New("no leader")
+		}
+		// Without a leader there's nothing to compare the index to.
+		return nil
+	}
+
+	health, err := c.consulClient.Operator().AutopilotServerHealth(nil)
+	if err != nil {
+		return fmt.Errorf("error reading server health: %s", err)
+	}
+	return checkIndex(health, nodeID, c.flagMaxIndexLag)
+}
+
+// checkVoter returns an error if the server with the given ID isn't a voter
+// in the given Raft configuration, and whether the configuration has a
+// leader otherwise.
+func checkVoter(raft *api.RaftConfiguration, id string) (bool, error) {
+	voter := false
+	found := false
+	hasLeader := false
+	for _, s := range raft.Servers {
+		if s.Leader {
+			hasLeader = true
+		}
+		if s.ID == id {
+			found = true
+			voter = s.Voter
+		}
+	}
+	if !found {
+		return false, errors.New("server is not in the Raft configuration")
+	}
+	if !voter {
+		return false, errors.New("server is not a voter")
+	}
+	return hasLeader, nil
+}
+
+// checkIndex returns an error if the last Raft index of the server with
+// the given ID is more than maxLag behind that of the leader.
+func checkIndex(health *api.OperatorHealthReply, id string, maxLag uint64) error {
+	var self, leader *api.ServerHealth
+	for i := range health.Servers {
+		s := &health.Servers[i]
+		if s.ID == id {
+			self = s
+		}
+		if s.Leader {
+			leader = s
+		}
+	}
+	if self == nil {
+		return errors.New("server has no health information yet")
+	}
+	if leader == nil {
+		return errors.New("leader has no health information yet")
+	}
+	if leader.LastIndex > self.LastIndex && leader.LastIndex-self.LastIndex > maxLag {
+		return fmt.Errorf("server is at index %d, %d behind the leader",
+			self.LastIndex, leader.LastIndex-self.LastIndex)
+	}
+	return nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Check whether a Consul server is ready."
+const help = `
+Usage: consul-k8s server-ready [options]
+
+  Checks whether the local Consul server is ready to serve requests: it
+  must be a voter, the cluster must have a leader unless -require-leader
+  is false, and the server must be within -max-index-lag Raft log entries
+  of the leader. Exits 0 if it is ready and 1 otherwise, so that it can
+  be used as the exec readiness probe of server pods.
+
+`
@@ -0,0 +1,125 @@
+package serverready
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/agent"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/consul/testrpc"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckVoter(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		Servers      []*api.RaftServer
+		ExpHasLeader bool
+		ExpErr       string
+	}{
+		"not in configuration": {
+			Servers: []*api.RaftServer{{ID: "other", Leader: true, Voter: true}},
+			ExpErr:  "server is not in the Raft configuration",
+		},
+		"not a voter": {
+			Servers: []*api.RaftServer{
+				{ID: "other", Leader: true, Voter: true},
+				{ID: "self", Voter: false},
+			},
+			ExpErr: "server is not a voter",
+		},
+		"voter without leader": {
+			Servers: []*api.RaftServer{
+				{ID: "other", Voter: true},
+				{ID: "self", Voter: true},
+			},
+			ExpHasLeader: false,
+		},
+		"voter with leader": {
+			Servers: []*api.RaftServer{
+				{ID: "other", Leader: true, Voter: true},
+				{ID: "self", Voter: true},
+			},
+			ExpHasLeader: true,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			hasLeader, err := checkVoter(&api.RaftConfiguration{Servers: c.Servers}, "self")
+			if c.ExpErr != "" {
+				require.EqualError(t, err, c.ExpErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.ExpHasLeader, hasLeader)
+		})
+	}
+}
+
+func TestCheckIndex(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		Servers []api.ServerHealth
+		ExpErr  string
+	}{
+		"no health for server": {
+			Servers: []api.ServerHealth{{ID: "other", Leader: true, LastIndex: 100}},
+			ExpErr:  "server has no health information yet",
+		},
+		"no health for leader": {
+			Servers: []api.ServerHealth{{ID: "self", LastIndex: 100}},
+			ExpErr:  "leader has no health information yet",
+		},
+		"within lag": {
+			Servers: []api.ServerHealth{
+				{ID: "other", Leader: true, LastIndex: 100},
+				{ID: "self", LastIndex: 90},
+			},
+		},
+		"behind": {
+			Servers: []api.ServerHealth{
+				{ID: "other", Leader: true, LastIndex: 100},
+				{ID: "self", LastIndex: 89},
+			},
+			ExpErr: "server is at index 89, 11 behind the leader",
+		},
+		"is leader": {
+			Servers: []api.ServerHealth{{ID: "self", Leader: true, LastIndex: 100}},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			err := checkIndex(&api.OperatorHealthReply{Servers: c.Servers}, "self", 10)
+			if c.ExpErr != "" {
+				require.EqualError(t, err, c.ExpErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	a := agent.NewTestAgent(t, t.Name(), "")
+	defer a.Shutdown()
+	testrpc.WaitForLeader(t, a.RPC, "dc1")
+
+	// Autopilot takes a moment to report the health of the server.
+	retry.Run(t, func(r *retry.R) {
+		ui := cli.NewMockUi()
+		cmd := Command{
+			UI:           ui,
+			consulClient: a.Client(),
+		}
+		responseCode := cmd.Run(nil)
+		require.Equal(r, 0, responseCode, ui.ErrorWriter.String())
+		require.Contains(r, ui.OutputWriter.String(), "Ready")
+	})
+}
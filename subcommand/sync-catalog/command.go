@@ -2,26 +2,52 @@ package synccatalog
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	catalogtoconsul "github.com/hashicorp/consul-k8s/catalog/to-consul"
 	catalogtok8s "github.com/hashicorp/consul-k8s/catalog/to-k8s"
+	"github.com/hashicorp/consul-k8s/helper/audit"
 	"github.com/hashicorp/consul-k8s/helper/controller"
+	"github.com/hashicorp/consul-k8s/helper/health"
+	"github.com/hashicorp/consul-k8s/helper/logging"
+	"github.com/hashicorp/consul-k8s/helper/startup"
 	"github.com/hashicorp/consul-k8s/subcommand"
 	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/command/flags"
 	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// auditBufferSize is how many audit events can wait to be recorded
+	// before further events are dropped.
+	auditBufferSize = 1024
+
+	// auditCloseTimeout is how long shutdown waits for the buffered audit
+	// events to be recorded.
+	auditCloseTimeout = 10 * time.Second
+
+	// syncLoopPeriods is how many sync periods the sync loop can go
+	// without completing a full sync before it fails the liveness check.
+	syncLoopPeriods = 10
 )
 
 // Command is the command for syncing the K8S and Consul service
@@ -33,6 +59,10 @@ type Command struct {
 	http                      *flags.HTTPFlags
 	k8s                       *k8sflags.K8SFlags
 	flagListen                string
+	flagTLSCertFile           string
+	flagTLSKeyFile            string
+	flagReadyChecks           string
+	flagLiveChecks            string
 	flagToConsul              bool
 	flagToK8S                 bool
 	flagConsulDomain          string
@@ -40,13 +70,28 @@ type Command struct {
 	flagK8SDefault            bool
 	flagK8SServicePrefix      string
 	flagConsulServicePrefix   string
+	flagConsulNodeName        string
 	flagK8SSourceNamespace    string
 	flagK8SWriteNamespace     string
 	flagConsulWritePeriod     flags.DurationValue
 	flagSyncClusterIPServices bool
 	flagNodePortSyncType      string
 	flagAddK8SNamespaceSuffix bool
+	flagPodMetaPrefix         string
+	flagWorkers               int
+	flagRetryBaseDelay        time.Duration
+	flagRetryMaxDelay         time.Duration
+	flagResyncPeriod          time.Duration
+	flagNamespaceFairness     bool
+	flagNamespacePriorities   string
+	flagSlowThreshold         time.Duration
+	flagStuckThreshold        time.Duration
+	flagStartupConsulTimeout  time.Duration
+	flagStartupK8SSyncTimeout time.Duration
 	flagLogLevel              string
+	flagLogJSON               bool
+	flagAuditLog              string
+	flagPprofListen           string
 
 	consulClient *api.Client
 	clientset    kubernetes.Interface
@@ -59,6 +104,22 @@ type Command struct {
 func (c *Command) init() {
 	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
 	c.flags.StringVar(&c.flagListen, "listen", ":8080", "Address to bind listener to.")
+	c.flags.StringVar(&c.flagTLSCertFile, "tls-cert-file", "",
+		"PEM-encoded TLS certificate to serve the health and metrics endpoints with. "+
+			"If blank, the listener uses plain HTTP.")
+	c.flags.StringVar(&c.flagTLSKeyFile, "tls-key-file", "",
+		"PEM-encoded TLS private key to serve the health and metrics endpoints with.")
+	c.flags.StringVar(&c.flagReadyChecks, "health-ready-checks", "",
+		"Comma-separated names of the checks of /health/ready. The checks are \"consul\" "+
+			"(a leader is known), \"consul-catalog\" (the catalog can be read), \"acl-token\" "+
+			"(the ACL token is valid), and the controller and initial sync checks of the "+
+			"enabled sync directions. Defaults to all of them except \"consul-catalog\" and \"acl-token\".")
+	c.flags.StringVar(&c.flagLiveChecks, "health-live-checks", "",
+		"Comma-separated names of the checks of /health/live, from the same set as "+
+			"-health-ready-checks plus \"to-consul-sync-loop\" (a full sync to Consul completed "+
+			"within the last 10 sync periods). Defaults to \"to-consul-sync-loop\" if syncing to "+
+			"Consul, and to none otherwise, in which case /health/live only checks that the "+
+			"process is serving.")
 	c.flags.BoolVar(&c.flagToConsul, "to-consul", true,
 		"If true, K8S services will be synced to Consul.")
 	c.flags.BoolVar(&c.flagToK8S, "to-k8s", true,
@@ -73,6 +134,9 @@ func (c *Command) init() {
 	c.flags.StringVar(&c.flagConsulServicePrefix, "consul-service-prefix", "",
 		"A prefix to prepend to all services written to Consul from Kubernetes. "+
 			"If this is not set then services will have no prefix.")
+	c.flags.StringVar(&c.flagConsulNodeName, "consul-node-name", catalogtoconsul.DefaultConsulNodeName,
+		"The Consul node to register services from Kubernetes on. Set this to a different "+
+			"name for each instance of sync-catalog that has its own ACL token.")
 	c.flags.StringVar(&c.flagK8SSourceNamespace, "k8s-source-namespace", metav1.NamespaceAll,
 		"The Kubernetes namespace to watch for service changes and sync to Consul. "+
 			"If this is not set then it will default to all namespaces.")
@@ -98,9 +162,59 @@ func (c *Command) init() {
 		"If true, Kubernetes namespace will be appended to service names synced to Consul separated by a dash. "+
 			"If false, no suffix will be appended to the service names in Consul. "+
 			"If the service name annotation is provided, the suffix is not appended.")
+	c.flags.StringVar(&c.flagPodMetaPrefix, "pod-meta-annotation-prefix", "",
+		"If set, pod annotations with this prefix are added to the meta of the Consul "+
+			"service instance registered for each pod of a ClusterIP service, "+
+			"e.g. consul.hashicorp.com/service-meta-. The remainder of the "+
+			"annotation key is used as the meta key. If not set, pod annotations are ignored.")
+	c.flags.IntVar(&c.flagWorkers, "k8s-workers", 1,
+		"The number of Kubernetes services that are processed concurrently when "+
+			"syncing to Consul. Defaults to 1.")
+	c.flags.DurationVar(&c.flagRetryBaseDelay, "k8s-retry-base-delay", controller.DefaultRetryBaseDelay,
+		"The initial delay before retrying a Kubernetes service that failed to be "+
+			"processed. The delay doubles with each retry. Defaults to 5ms.")
+	c.flags.DurationVar(&c.flagRetryMaxDelay, "k8s-retry-max-delay", controller.DefaultRetryMaxDelay,
+		"The maximum delay before retrying a Kubernetes service that failed to be "+
+			"processed. Defaults to 1000s.")
+	c.flags.DurationVar(&c.flagResyncPeriod, "k8s-resync-period", 0,
+		"If set, all Kubernetes services are processed again on this interval even "+
+			"if they haven't changed. Defaults to 0, which disables resyncs.")
+	c.flags.BoolVar(&c.flagNamespaceFairness, "k8s-namespace-fairness", false,
+		"If true, Kubernetes services are queued per namespace and processed in turns "+
+			"across namespaces, so that many changes in one namespace don't delay the others.")
+	c.flags.StringVar(&c.flagNamespacePriorities, "k8s-namespace-priorities", "",
+		"Comma-separated <namespace>=<n> pairs, e.g. kube-system=5, setting how many "+
+			"services of a namespace are processed in each of its turns with "+
+			"-k8s-namespace-fairness. Namespaces that aren't listed get 1.")
+	c.flags.DurationVar(&c.flagSlowThreshold, "k8s-slow-threshold", 0,
+		"If set, processing a Kubernetes service that takes longer than this is "+
+			"reported with a metric and a warning event on the service. Defaults to 0, which disables it.")
+	c.flags.DurationVar(&c.flagStuckThreshold, "k8s-stuck-threshold", 0,
+		"If set, a Kubernetes service that has been failing to process for longer than "+
+			"this is reported with a metric and a warning event on the service. While it's "+
+			"set, services that keep failing are retried every -k8s-retry-max-delay, or "+
+			"every threshold if that's shorter. Defaults to 0, which disables it.")
+	c.flags.DurationVar(&c.flagStartupConsulTimeout, "startup-consul-timeout", 5*time.Minute,
+		"How long to wait at startup for Consul to have a leader before exiting. "+
+			"Nothing is synced until it does. Defaults to 5m.")
+	c.flags.DurationVar(&c.flagStartupK8SSyncTimeout, "startup-k8s-sync-timeout", 5*time.Minute,
+		"How long to wait at startup for the Kubernetes informers to sync once Consul "+
+			"has a leader before exiting. Defaults to 5m.")
 	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\".")
+	c.flags.StringVar(&c.flagAuditLog, "audit-log", "",
+		"Where to record the registrations and deregistrations made in Consul: \"stdout\" "+
+			"or an http(s) URL that each change is POSTed to as JSON. If empty, changes are not recorded. "+
+			"Changes are recorded in the background, and dropped if the destination can't keep up.")
+	c.flags.StringVar(&c.flagPprofListen, "pprof-listen", "",
+		"If set, the pprof endpoints are served under /debug/pprof/, and the log level "+
+			"endpoint at /debug/log-level, on this address, which must be on localhost, "+
+			"e.g. 127.0.0.1:6060. If empty, neither is served.")
+	c.flags.BoolVar(&c.flagLogJSON, "log-json", false,
+		"Enable or disable JSON output format for logging. The level of each logger "+
+			"can be changed at runtime with PUT /debug/log-level?level=<level>[&logger=<prefix>] "+
+			"on the -pprof-listen address.")
 
 	c.http = &flags.HTTPFlags{}
 	c.k8s = &k8sflags.K8SFlags{}
@@ -119,6 +233,34 @@ func (c *Command) Run(args []string) int {
 		c.UI.Error(fmt.Sprintf("Should have no non-flag arguments."))
 		return 1
 	}
+	if c.flagWorkers < 1 {
+		c.UI.Error("-k8s-workers must be at least 1")
+		return 1
+	}
+	priorities, err := parsePriorities(c.flagNamespacePriorities)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error in -k8s-namespace-priorities: %s", err))
+		return 1
+	}
+	if (c.flagTLSCertFile == "") != (c.flagTLSKeyFile == "") {
+		c.UI.Error("-tls-cert-file and -tls-key-file must both be set")
+		return 1
+	}
+	if c.flagStartupConsulTimeout <= 0 || c.flagStartupK8SSyncTimeout <= 0 {
+		c.UI.Error("-startup-consul-timeout and -startup-k8s-sync-timeout must be greater than 0")
+		return 1
+	}
+	if c.flagPprofListen != "" {
+		if err := subcommand.ValidatePprofAddr(c.flagPprofListen); err != nil {
+			c.UI.Error(err.Error())
+			return 1
+		}
+	}
+	auditSink, err := audit.NewSink(c.flagAuditLog)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 
 	// create the clientset
 	if c.clientset == nil {
@@ -150,52 +292,141 @@ func (c *Command) Run(args []string) int {
 		c.UI.Error(fmt.Sprintf("Unknown log level: %s", c.flagLogLevel))
 		return 1
 	}
-	logger := hclog.New(&hclog.LoggerOptions{
-		Level:  level,
-		Output: os.Stderr,
+	// Each subsystem gets its own logger so that their levels can be
+	// changed independently at runtime.
+	loggers := &logging.Loggers{
+		Level: level,
+		JSON:  c.flagLogJSON,
+	}
+
+	// Audit events are recorded in the background, since the syncer
+	// records them while holding its lock.
+	if auditSink != nil {
+		buffered := audit.NewBufferedSink(auditSink, auditBufferSize, loggers.Named("audit"))
+		defer buffered.Close(auditCloseTimeout)
+		auditSink = buffered
+	}
+
+	// Slow and stuck services are reported with events on the services.
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: c.clientset.CoreV1().Events(""),
 	})
+	defer broadcaster.Shutdown()
+	recorder := broadcaster.NewRecorder(scheme.Scheme, apiv1.EventSource{Component: "consul-k8s-sync-catalog"})
 
-	// Get the sync interval
+	// Get the sync interval. If it isn't set, the syncer uses its default,
+	// which the sync loop check needs to know.
 	var syncInterval time.Duration
 	c.flagConsulWritePeriod.Merge(&syncInterval)
+	syncPeriod := syncInterval
+	if syncPeriod == 0 {
+		syncPeriod = catalogtoconsul.ConsulSyncPeriod
+	}
 
 	// Create the context we'll use to cancel everything
 	ctx, cancelF := context.WithCancel(context.Background())
 
+	// Startup waits for Consul before starting anything that talks to it,
+	// then for the informers to sync. The health endpoints are served
+	// throughout so that a slow start shows up as not ready.
+	var informersSynced []func() bool
+	start := startup.NewSequence(
+		startup.Phase{
+			Name:    "consul",
+			Timeout: c.flagStartupConsulTimeout,
+			Run: func(ctx context.Context) error {
+				return startup.WaitForConsul(ctx, c.consulClient, time.Second)
+			},
+		},
+		startup.Phase{
+			Name:    "k8s-informers",
+			Timeout: c.flagStartupK8SSyncTimeout,
+			Run: func(ctx context.Context) error {
+				return startup.Poll(ctx, time.Second, func() error {
+					for _, synced := range informersSynced {
+						if !synced() {
+							return errors.New("not synced yet")
+						}
+					}
+					return nil
+				})
+			},
+		},
+	)
+
+	// Readiness is made up of a check of each subsystem. All the checks are
+	// added to the suite and the ready and live endpoints serve a selection.
+	suite := &health.Checker{}
+	suite.Add("startup", start.Check)
+	suite.Add("consul", c.checkConsul)
+	suite.Add("consul-catalog", c.checkCatalog)
+	suite.Add("acl-token", c.checkACLToken)
+	defaultReadyChecks := []string{"startup", "consul"}
+	var defaultLiveChecks []string
+
 	// Start the K8S-to-Consul syncer
 	var toConsulCh chan struct{}
 	if c.flagToConsul {
 		// Build the Consul sync and start it
 		syncer := &catalogtoconsul.ConsulSyncer{
 			Client:            c.consulClient,
-			Log:               logger.Named("to-consul/sink"),
+			Log:               loggers.Named("to-consul/sink"),
 			Namespace:         c.flagK8SSourceNamespace,
 			SyncPeriod:        syncInterval,
 			ServicePollPeriod: syncInterval * 2,
+			ConsulNodeName:    c.flagConsulNodeName,
 			ConsulK8STag:      c.flagConsulK8STag,
+			Audit:             &audit.Recorder{Component: "sync-catalog", Sink: auditSink},
 		}
-		go syncer.Run(ctx)
 
 		// Build the controller and start it
 		ctl := &controller.Controller{
-			Log: logger.Named("to-consul/controller"),
+			Log:            loggers.Named("to-consul/controller"),
+			Name:           "to-consul",
+			Workers:        c.flagWorkers,
+			RetryBaseDelay: c.flagRetryBaseDelay,
+			RetryMaxDelay:  c.flagRetryMaxDelay,
+			ResyncPeriod:   c.flagResyncPeriod,
+			SlowThreshold:  c.flagSlowThreshold,
+			StuckThreshold: c.flagStuckThreshold,
+			Recorder:       recorder,
+
+			ShardByNamespace:    c.flagNamespaceFairness,
+			NamespacePriorities: priorities,
+
 			Resource: &catalogtoconsul.ServiceResource{
-				Log:                   logger.Named("to-consul/source"),
-				Client:                c.clientset,
-				Syncer:                syncer,
-				Namespace:             c.flagK8SSourceNamespace,
-				ExplicitEnable:        !c.flagK8SDefault,
-				ClusterIPSync:         c.flagSyncClusterIPServices,
-				NodePortSync:          catalogtoconsul.NodePortSyncType(c.flagNodePortSyncType),
-				ConsulK8STag:          c.flagConsulK8STag,
-				ConsulServicePrefix:   c.flagConsulServicePrefix,
-				AddK8SNamespaceSuffix: c.flagAddK8SNamespaceSuffix,
+				Log:                     loggers.Named("to-consul/source"),
+				Client:                  c.clientset,
+				Syncer:                  syncer,
+				Namespace:               c.flagK8SSourceNamespace,
+				ExplicitEnable:          !c.flagK8SDefault,
+				ClusterIPSync:           c.flagSyncClusterIPServices,
+				NodePortSync:            catalogtoconsul.NodePortSyncType(c.flagNodePortSyncType),
+				ConsulK8STag:            c.flagConsulK8STag,
+				ConsulServicePrefix:     c.flagConsulServicePrefix,
+				ConsulNodeName:          c.flagConsulNodeName,
+				AddK8SNamespaceSuffix:   c.flagAddK8SNamespaceSuffix,
+				PodMetaAnnotationPrefix: c.flagPodMetaPrefix,
 			},
 		}
 
+		suite.Add("to-consul-controller", health.Synced(ctl.HasSynced))
+		suite.Add("to-consul-initial-sync", health.Synced(syncer.HasSynced))
+		suite.Add("to-consul-sync-loop", func() error {
+			return syncer.CheckSyncLoop(syncLoopPeriods * syncPeriod)
+		})
+		defaultReadyChecks = append(defaultReadyChecks, "to-consul-controller", "to-consul-initial-sync")
+		defaultLiveChecks = append(defaultLiveChecks, "to-consul-sync-loop")
+		informersSynced = append(informersSynced, ctl.HasSynced)
+
 		toConsulCh = make(chan struct{})
 		go func() {
 			defer close(toConsulCh)
+			if !waitDone(ctx, start.Done("consul")) {
+				return
+			}
+			go syncer.Run(ctx)
 			ctl.Run(ctx.Done())
 		}()
 	}
@@ -206,7 +437,7 @@ func (c *Command) Run(args []string) int {
 		sink := &catalogtok8s.K8SSink{
 			Client:    c.clientset,
 			Namespace: c.flagK8SWriteNamespace,
-			Log:       logger.Named("to-k8s/sink"),
+			Log:       loggers.Named("to-k8s/sink"),
 		}
 
 		source := &catalogtok8s.Source{
@@ -214,79 +445,210 @@ func (c *Command) Run(args []string) int {
 			Domain:       c.flagConsulDomain,
 			Sink:         sink,
 			Prefix:       c.flagK8SServicePrefix,
-			Log:          logger.Named("to-k8s/source"),
+			Log:          loggers.Named("to-k8s/source"),
 			ConsulK8STag: c.flagConsulK8STag,
 		}
-		go source.Run(ctx)
 
 		// Build the controller and start it
 		ctl := &controller.Controller{
-			Log:      logger.Named("to-k8s/controller"),
-			Resource: sink,
+			Log:            loggers.Named("to-k8s/controller"),
+			Name:           "to-k8s",
+			Resource:       sink,
+			SlowThreshold:  c.flagSlowThreshold,
+			StuckThreshold: c.flagStuckThreshold,
+			Recorder:       recorder,
 		}
 
+		suite.Add("to-k8s-controller", health.Synced(ctl.HasSynced))
+		defaultReadyChecks = append(defaultReadyChecks, "to-k8s-controller")
+		informersSynced = append(informersSynced, ctl.HasSynced)
+
 		toK8SCh = make(chan struct{})
 		go func() {
 			defer close(toK8SCh)
+			if !waitDone(ctx, start.Done("consul")) {
+				return
+			}
+			go source.Run(ctx)
 			ctl.Run(ctx.Done())
 		}()
 	}
 
+	readyChecks := defaultReadyChecks
+	if c.flagReadyChecks != "" {
+		readyChecks = splitNames(c.flagReadyChecks)
+	}
+	readyChecker, err := suite.Select(readyChecks)
+	if err != nil {
+		cancelF()
+		c.UI.Error(fmt.Sprintf("Error in -health-ready-checks: %s", err))
+		return 1
+	}
+	liveChecks := defaultLiveChecks
+	if c.flagLiveChecks != "" {
+		liveChecks = splitNames(c.flagLiveChecks)
+	}
+	liveChecker, err := suite.Select(liveChecks)
+	if err != nil {
+		cancelF()
+		c.UI.Error(fmt.Sprintf("Error in -health-live-checks: %s", err))
+		return 1
+	}
+
 	// Start healthcheck handler
 	go func() {
 		mux := http.NewServeMux()
-		mux.HandleFunc("/health/ready", c.handleReady)
+		mux.Handle("/health/ready", readyChecker)
+		mux.Handle("/health/live", liveChecker)
+		mux.Handle("/metrics", promhttp.Handler())
 		var handler http.Handler = mux
 
 		c.UI.Info(fmt.Sprintf("Listening on %q...", c.flagListen))
-		if err := http.ListenAndServe(c.flagListen, handler); err != nil {
+		var err error
+		if c.flagTLSCertFile != "" {
+			err = http.ListenAndServeTLS(c.flagListen, c.flagTLSCertFile, c.flagTLSKeyFile, handler)
+		} else {
+			err = http.ListenAndServe(c.flagListen, handler)
+		}
+		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error listening: %s", err))
 		}
 	}()
 
+	if c.flagPprofListen != "" {
+		go func() {
+			c.UI.Info(fmt.Sprintf("Serving pprof on %q...", c.flagPprofListen))
+			if err := subcommand.ServePprof(c.flagPprofListen, loggers); err != nil {
+				c.UI.Error(fmt.Sprintf("Error serving pprof: %s", err))
+			}
+		}()
+	}
+
+	startupCh := make(chan error, 1)
+	go func() { startupCh <- start.Run(ctx) }()
+
 	// Wait on an interrupt to exit
 	c.sigCh = make(chan os.Signal, 1)
 	signal.Notify(c.sigCh, os.Interrupt)
-	select {
-	// Unexpected exit
-	case <-toConsulCh:
-		cancelF()
-		if toK8SCh != nil {
-			<-toK8SCh
-		}
-		return 1
+	for {
+		select {
+		case err := <-startupCh:
+			if err == nil {
+				// Startup completed, so stop waiting on it.
+				startupCh = nil
+				continue
+			}
+			c.UI.Error(err.Error())
+			cancelF()
+			if toConsulCh != nil {
+				<-toConsulCh
+			}
+			if toK8SCh != nil {
+				<-toK8SCh
+			}
+			return 1
 
-	// Unexpected exit
-	case <-toK8SCh:
-		cancelF()
-		if toConsulCh != nil {
-			<-toConsulCh
-		}
-		return 1
+		// Unexpected exit
+		case <-toConsulCh:
+			cancelF()
+			if toK8SCh != nil {
+				<-toK8SCh
+			}
+			return 1
 
-	// Interrupted, gracefully exit
-	case <-c.sigCh:
-		cancelF()
-		if toConsulCh != nil {
-			<-toConsulCh
-		}
-		if toK8SCh != nil {
-			<-toK8SCh
+		// Unexpected exit
+		case <-toK8SCh:
+			cancelF()
+			if toConsulCh != nil {
+				<-toConsulCh
+			}
+			return 1
+
+		// Interrupted, gracefully exit
+		case <-c.sigCh:
+			cancelF()
+			if toConsulCh != nil {
+				<-toConsulCh
+			}
+			if toK8SCh != nil {
+				<-toK8SCh
+			}
+			return 0
 		}
-		return 0
 	}
 }
 
-func (c *Command) handleReady(rw http.ResponseWriter, req *http.Request) {
-	// The main readiness check is whether sync can talk to
-	// the consul cluster, in this case querying for the leader
-	_, err := c.consulClient.Status().Leader()
+// waitDone waits until done is closed and returns true, or returns false
+// if ctx is done first.
+func waitDone(ctx context.Context, done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// checkConsul checks that sync can talk to the Consul cluster and that the
+// cluster has a leader, so that writes can succeed.
+func (c *Command) checkConsul() error {
+	leader, err := c.consulClient.Status().Leader()
 	if err != nil {
 		c.UI.Error(fmt.Sprintf("[GET /health/ready] Error getting leader status: %s", err))
-		rw.WriteHeader(500)
-		return
+		return fmt.Errorf("error getting leader status: %s", err)
+	}
+	if leader == "" {
+		return errors.New("no cluster leader")
 	}
-	rw.WriteHeader(204)
+	return nil
+}
+
+// checkCatalog checks that sync can read the Consul catalog, e.g. that its
+// token hasn't lost the permissions it needs.
+func (c *Command) checkCatalog() error {
+	_, _, err := c.consulClient.Catalog().Services(&api.QueryOptions{AllowStale: true})
+	if err != nil {
+		return fmt.Errorf("error reading catalog: %s", err)
+	}
+	return nil
+}
+
+// checkACLToken checks that the ACL token of sync is valid. It passes if
+// ACLs are disabled.
+func (c *Command) checkACLToken() error {
+	_, _, err := c.consulClient.ACL().TokenReadSelf(nil)
+	if err != nil && !strings.Contains(err.Error(), "ACL support disabled") {
+		return fmt.Errorf("error reading ACL token: %s", err)
+	}
+	return nil
+}
+
+// splitNames splits a comma-separated list of names, ignoring blanks.
+func splitNames(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parsePriorities parses comma-separated <namespace>=<n> pairs.
+func parsePriorities(s string) (map[string]int, error) {
+	priorities := make(map[string]int)
+	for _, pair := range splitNames(s) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%q is not of the form <namespace>=<n>", pair)
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("priority of namespace %q must be a positive integer", parts[0])
+		}
+		priorities[parts[0]] = n
+	}
+	return priorities, nil
 }
 
 func (c *Command) Synopsis() string { return synopsis }
@@ -310,4 +672,13 @@ Usage: consul-k8s sync-catalog [options]
   services, and allows external services to discover and communicate with
   K8S services.
 
+  GET /health/ready?verbose lists the readiness of each part of the sync,
+  e.g. the connection to Consul and whether the initial sync completed.
+  GET /health/live serves the checks of -health-live-checks the same way.
+  By default it fails if syncing to Consul is stuck.
+
+  At startup nothing is synced until Consul has a leader. The "startup"
+  check reports which phase of the startup is being waited for, and the
+  command exits if a phase takes longer than its timeout.
+
 `
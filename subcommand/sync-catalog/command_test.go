@@ -1,10 +1,14 @@
 package synccatalog
 
 import (
+	"fmt"
+	"net/http"
 	"testing"
 	"time"
 
+	catalogtoconsul "github.com/hashicorp/consul-k8s/catalog/to-consul"
 	"github.com/hashicorp/consul/agent"
+	"github.com/hashicorp/consul/sdk/freeport"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/mitchellh/cli"
 	"github.com/stretchr/testify/require"
@@ -172,6 +176,87 @@ func TestCommand_Run_ToConsulTwoServicesSameNameDifferentNamespace(t *testing.T)
 	})
 }
 
+// Test that with the default flags the sync loop liveness check passes
+// before and after the first full sync to Consul.
+func TestRun_DefaultLiveCheck(t *testing.T) {
+	t.Parallel()
+
+	k8s, testAgent := completeSetup(t)
+	defer testAgent.Shutdown()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		clientset:    k8s,
+		consulClient: testAgent.Client(),
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", freeport.Get(1)[0])
+	exitChan := runCommandAsynchronously(&cmd, []string{"-listen", addr})
+	defer stopCommand(t, &cmd, exitChan)
+
+	status := func(r *retry.R, path string) int {
+		resp, err := http.Get("http://" + addr + path)
+		require.NoError(r, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	retry.Run(t, func(r *retry.R) {
+		require.Equal(r, http.StatusNoContent, status(r, "/health/live"))
+	})
+
+	// The first full sync happens after the default sync period.
+	timer := &retry.Timer{Timeout: 2 * catalogtoconsul.ConsulSyncPeriod, Wait: time.Second}
+	retry.RunWith(timer, t, func(r *retry.R) {
+		require.Equal(r, http.StatusNoContent, status(r, "/health/ready"))
+	})
+	retry.Run(t, func(r *retry.R) {
+		require.Equal(r, http.StatusNoContent, status(r, "/health/live"))
+	})
+}
+
+// Test that the command fails if a health check that doesn't exist is
+// selected.
+func TestRun_UnknownHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	k8s, testAgent := completeSetup(t)
+	defer testAgent.Shutdown()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		clientset:    k8s,
+		consulClient: testAgent.Client(),
+	}
+	responseCode := cmd.Run([]string{
+		"-to-k8s=false",
+		"-health-live-checks", "consul,to-k8s-controller",
+	})
+	require.Equal(t, 1, responseCode)
+	require.Contains(t, ui.ErrorWriter.String(), `Error in -health-live-checks: unknown check "to-k8s-controller"`)
+}
+
+// Test that the command fails if the namespace priorities can't be parsed.
+func TestRun_InvalidNamespacePriorities(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"kube-system":         `"kube-system" is not of the form <namespace>=<n>`,
+		"=2":                  `"=2" is not of the form <namespace>=<n>`,
+		"kube-system=0":       `priority of namespace "kube-system" must be a positive integer`,
+		"default=1,ops=three": `priority of namespace "ops" must be a positive integer`,
+	}
+	for flag, expErr := range cases {
+		t.Run(flag, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			responseCode := cmd.Run([]string{"-k8s-namespace-priorities", flag})
+			require.Equal(t, 1, responseCode)
+			require.Contains(t, ui.ErrorWriter.String(), "Error in -k8s-namespace-priorities: "+expErr)
+		})
+	}
+}
+
 // Set up test consul agent and fake kubernetes cluster client
 func completeSetup(t *testing.T) (*fake.Clientset, *agent.TestAgent) {
 	k8s := fake.NewSimpleClientset()